@@ -1,13 +1,31 @@
-package main
-
-import (
-	"log"
-
-	"github.com/lollmark/digital_calc/internal"
-)
-
-func main() {
-	agent := application.NewAgent()
-	log.Println("Starting Agent...")
-	agent.Run()
-}
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := application.SetupLogging(os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+	shutdown, err := application.SetupTracing(context.Background(), "agent")
+	if err != nil {
+		slog.Error("cannot set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdown(context.Background())
+
+	agent := application.NewAgent()
+	slog.Info("starting agent", "agent_id", agent.ID, "workers", agent.ComputingPower)
+	agent.Run(ctx)
+}
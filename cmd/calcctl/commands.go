@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lollmark/digital_calc/pkg/client"
+)
+
+// password takes the password from --password, CALCCTL_PASSWORD or, as
+// a last resort, the first line of stdin.
+func (a *app) password(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if pw := os.Getenv("CALCCTL_PASSWORD"); pw != "" {
+		return pw, nil
+	}
+	fmt.Fprint(a.stderr, "Password: ")
+	line, err := bufio.NewReader(a.stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no password given")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid expression id %q", s)
+	}
+	return id, nil
+}
+
+func runRegister(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("register")
+	pw := fs.String("password", "", "password (CALCCTL_PASSWORD, or read from stdin)")
+	pos, err := a.parse(fs, args, "login")
+	if err != nil {
+		return err
+	}
+	password, err := a.password(*pw)
+	if err != nil {
+		return err
+	}
+	if err := a.client.Register(ctx, pos[0], password); err != nil {
+		return err
+	}
+	return a.print(map[string]string{"login": pos[0], "status": "registered"}, func() {
+		fmt.Fprintf(a.stdout, "registered %s\n", pos[0])
+	})
+}
+
+func runLogin(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("login")
+	pw := fs.String("password", "", "password (CALCCTL_PASSWORD, or read from stdin)")
+	pos, err := a.parse(fs, args, "login")
+	if err != nil {
+		return err
+	}
+	password, err := a.password(*pw)
+	if err != nil {
+		return err
+	}
+	tok, err := a.client.Login(ctx, pos[0], password)
+	if err != nil {
+		return err
+	}
+	if err := saveToken(a.server, tok); err != nil {
+		return fmt.Errorf("cannot save the token: %w", err)
+	}
+	return a.print(map[string]string{"login": pos[0], "token": tok}, func() {
+		fmt.Fprintf(a.stdout, "logged in as %s\n", pos[0])
+	})
+}
+
+func runCalc(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("calc")
+	wait := fs.Bool("wait", false, "wait for the result")
+	complexMode := fs.Bool("complex", false, "compute over complex numbers, e.g. sqrt(-1) or 3+4i")
+	vars := map[string]float64{}
+	fs.Func("var", "variable of the expression as name=value, may be repeated", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		v, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil {
+			return fmt.Errorf("want name=number, got %q", s)
+		}
+		vars[name] = v
+		return nil
+	})
+	timeout := fs.Duration("timeout", 0, "give up waiting after this long, e.g. 30s (0 waits forever)")
+	pos, err := a.parse(fs, args, "expression")
+	if err != nil {
+		return err
+	}
+	if err := a.authorize(); err != nil {
+		return err
+	}
+	calculate := a.client.CalculateWithVariables
+	if *complexMode {
+		calculate = a.client.CalculateComplex
+	}
+	id, err := calculate(ctx, pos[0], vars)
+	if err != nil {
+		return err
+	}
+	if !*wait {
+		return a.print(map[string]int64{"id": id}, func() {
+			fmt.Fprintf(a.stdout, "submitted expression %d\n", id)
+		})
+	}
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	e, err := a.client.Wait(ctx, id, 0)
+	if err != nil {
+		return fmt.Errorf("expression %d: %w", id, err)
+	}
+	return a.printExpression(e)
+}
+
+func runList(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("list")
+	var opts client.ListOptions
+	fs.IntVar(&opts.Limit, "limit", 0, "page size, 1-200 (default 50)")
+	fs.StringVar(&opts.Cursor, "cursor", "", "next page cursor printed by the previous call")
+	status := fs.String("status", "", "comma-separated statuses, e.g. pending,done")
+	fs.StringVar(&opts.Query, "q", "", "substring of the expression")
+	fs.StringVar(&opts.Sort, "sort", "", "id, created_at or updated_at, - for descending (default -created_at)")
+	if _, err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if *status != "" {
+		opts.Status = strings.Split(*status, ",")
+	}
+	if err := a.authorize(); err != nil {
+		return err
+	}
+	list, err := a.client.List(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return a.print(list, func() {
+		a.expressionTable(list.Expressions)
+		if list.NextCursor != "" {
+			fmt.Fprintf(a.stderr, "more: calcctl list --cursor %s\n", list.NextCursor)
+		}
+	})
+}
+
+func runShow(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("show")
+	pos, err := a.parse(fs, args, "id")
+	if err != nil {
+		return err
+	}
+	id, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+	if err := a.authorize(); err != nil {
+		return err
+	}
+	d, err := a.client.Detail(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.print(d, func() { a.detail(d) })
+}
+
+func runCancel(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("cancel")
+	pos, err := a.parse(fs, args, "id")
+	if err != nil {
+		return err
+	}
+	id, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+	if err := a.authorize(); err != nil {
+		return err
+	}
+	e, err := a.client.Cancel(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.printExpression(e)
+}
+
+func runWatch(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("watch")
+	interval := fs.Duration("interval", client.DefaultPollInterval, "how often to poll")
+	pos, err := a.parse(fs, args, "id")
+	if err != nil {
+		return err
+	}
+	id, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+	if err := a.authorize(); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(a.stdout)
+	_, err = a.client.Watch(ctx, id, *interval, func(e *client.Expression) {
+		// one line per change, so that the output can be piped
+		if a.output == "json" {
+			enc.Encode(e)
+			return
+		}
+		fmt.Fprintf(a.stdout, "%s  %-9s %s\n", e.UpdatedAt.Local().Format(time.TimeOnly), e.Status, result(e))
+	})
+	return err
+}
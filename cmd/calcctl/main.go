@@ -0,0 +1,173 @@
+// Command calcctl is a command-line client of the calculator service.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/lollmark/digital_calc/pkg/client"
+)
+
+const usage = `Usage: calcctl [flags] <command> [arguments]
+
+Commands:
+  register <login>           create a user
+  login <login>              log in and save the token
+  calc <expression>          submit an expression; --wait prints the result
+  list                       list your expressions
+  show <id>                  show an expression with its task tree
+  cancel <id>                cancel a pending expression
+  watch <id>                 print every change of an expression until it is finished
+  repl                       evaluate expressions interactively; --remote uses the orchestrator
+
+Flags, accepted before or after the command:
+  --server URL               orchestrator address (CALCCTL_SERVER, default http://localhost:8080)
+  -o, --output table|json    output format (default table)
+
+Run "calcctl <command> -h" for the flags of a command.
+`
+
+// errUsage makes run exit with status 2 after the usage text.
+var errUsage = errors.New("usage")
+
+var commands = map[string]func(ctx context.Context, a *app, args []string) error{
+	"register": runRegister,
+	"login":    runLogin,
+	"calc":     runCalc,
+	"list":     runList,
+	"show":     runShow,
+	"cancel":   runCancel,
+	"watch":    runWatch,
+	"repl":     runRepl,
+}
+
+// app is the state shared by the commands.
+type app struct {
+	server string
+	output string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	client *client.Client
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	a := &app{stdin: stdin, stdout: stdout, stderr: stderr}
+	fs := a.flagSet("calcctl")
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	name, rest := fs.Arg(0), fs.Args()[1:]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "calcctl: unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+	err := cmd(ctx, a, rest)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "calcctl: %v\n", err)
+		return 1
+	}
+}
+
+// flagSet returns a flag set with the global flags, so that they work
+// before and after the command name.
+func (a *app) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	server := os.Getenv("CALCCTL_SERVER")
+	if server == "" {
+		server = "http://localhost:8080"
+	}
+	if a.server == "" {
+		a.server = server
+	}
+	if a.output == "" {
+		a.output = "table"
+	}
+	fs.StringVar(&a.server, "server", a.server, "orchestrator address")
+	fs.StringVar(&a.output, "output", a.output, "output format: table or json")
+	fs.StringVar(&a.output, "o", a.output, "shorthand for --output")
+	return fs
+}
+
+// parse parses the flags of a command and checks the number of
+// positional arguments.
+func (a *app) parse(fs *flag.FlagSet, args []string, positional ...string) ([]string, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(a.stderr, "Usage: calcctl %s [flags]", fs.Name())
+		for _, p := range positional {
+			fmt.Fprintf(a.stderr, " <%s>", p)
+		}
+		fmt.Fprintln(a.stderr)
+		fs.PrintDefaults()
+	}
+	// flags may follow the positional arguments too; everything after
+	// "--" is positional, e.g. an expression starting with a minus
+	var pos, tail []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, tail = args[:i], args[i+1:]
+			break
+		}
+	}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	pos = append(pos, tail...)
+	if len(pos) != len(positional) {
+		fs.Usage()
+		return nil, errUsage
+	}
+	if a.output != "table" && a.output != "json" {
+		return nil, fmt.Errorf("unknown output format %q", a.output)
+	}
+	a.client = client.New(a.server)
+	return pos, nil
+}
+
+// authorize loads the token saved by login for the server.
+func (a *app) authorize() error {
+	if tok := os.Getenv("CALCCTL_TOKEN"); tok != "" {
+		a.client.SetToken(tok)
+		return nil
+	}
+	tokens, err := loadTokens()
+	if err != nil {
+		return err
+	}
+	tok, ok := tokens[a.server]
+	if !ok {
+		return fmt.Errorf("not logged in to %s, run calcctl login first", a.server)
+	}
+	a.client.SetToken(tok)
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lollmark/digital_calc/pkg/client"
+)
+
+// print writes v as JSON or calls table for the human-readable form.
+func (a *app) print(v interface{}, table func()) error {
+	if a.output == "json" {
+		return a.printJSON(v)
+	}
+	table()
+	return nil
+}
+
+func (a *app) printJSON(v interface{}) error {
+	enc := json.NewEncoder(a.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (a *app) printExpression(e *client.Expression) error {
+	return a.print(e, func() { a.expressionTable([]client.Expression{*e}) })
+}
+
+func formatFloat(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+func result(e *client.Expression) string {
+	return value(e.Result, e.ComplexResult, e.Unit)
+}
+
+// value prints a result or node value, a+bi in complex mode, with its
+// unit if it has one.
+func value(v *float64, c *string, unit string) string {
+	s := "-"
+	switch {
+	case c != nil:
+		s = *c
+	case v != nil:
+		s = formatFloat(*v)
+	default:
+		return s
+	}
+	if unit != "" {
+		s += " " + unit
+	}
+	return s
+}
+
+func (a *app) expressionTable(list []client.Expression) {
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tRESULT\tCREATED\tEXPRESSION")
+	for i := range list {
+		e := &list[i]
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n",
+			e.ID, e.Status, result(e), e.CreatedAt.Local().Format(time.DateTime), e.Expression)
+	}
+	tw.Flush()
+}
+
+func (a *app) detail(d *client.Detail) {
+	e := &d.Expression
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%d\n", e.ID)
+	fmt.Fprintf(tw, "Expression:\t%s\n", e.Expression)
+	fmt.Fprintf(tw, "Status:\t%s\n", e.Status)
+	fmt.Fprintf(tw, "Result:\t%s\n", result(e))
+	fmt.Fprintf(tw, "Created:\t%s\n", e.CreatedAt.Local().Format(time.DateTime))
+	if e.FinishedAt != nil {
+		fmt.Fprintf(tw, "Finished:\t%s\n", e.FinishedAt.Local().Format(time.DateTime))
+	}
+	fmt.Fprintf(tw, "Wall clock:\t%dms\n", d.Timings.WallClockMs)
+	fmt.Fprintf(tw, "Critical path:\t%dms\n", d.Timings.CriticalPathMs)
+	tw.Flush()
+	if d.Tree != nil {
+		fmt.Fprintln(a.stdout)
+		a.treeTo(a.stdout, d.Tree, "", "")
+	}
+}
+
+// treeTo prints a node and its operands below it:
+//
+//   - done = 21  task 1-0 on agent/0
+//     ├── + done = 3
+//     │   ├── 1
+//     │   └── 2
+//     └── 7
+func (a *app) treeTo(w io.Writer, n *client.Node, first, rest string) {
+	line := ""
+	if n.Variable != "" {
+		line = n.Variable + ": "
+	}
+	if n.Operator == "" {
+		line += value(n.Value, n.ComplexValue, n.Unit)
+	} else {
+		line += n.Operator
+		if n.State != "" {
+			line += " " + n.State
+		}
+		if n.Value != nil || n.ComplexValue != nil {
+			line += " = " + value(n.Value, n.ComplexValue, n.Unit)
+		}
+		if n.TaskID != "" {
+			line += "  task " + n.TaskID
+		}
+		if n.AgentID != "" {
+			line += " on " + n.AgentID
+		}
+	}
+	fmt.Fprintln(w, first+line)
+	var children []*client.Node
+	for _, c := range []*client.Node{n.Condition, n.Left, n.Right} {
+		if c != nil {
+			children = append(children, c)
+		}
+	}
+	for i, c := range children {
+		if i == len(children)-1 {
+			a.treeTo(w, c, rest+"└── ", rest+"    ")
+		} else {
+			a.treeTo(w, c, rest+"├── ", rest+"│   ")
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lollmark/digital_calc/internal"
+	"github.com/lollmark/digital_calc/pkg/calculator"
+	"github.com/lollmark/digital_calc/pkg/client"
+	"golang.org/x/term"
+)
+
+const replHelp = `Enter an expression to evaluate it, or name = expression to keep the
+result in a variable; ans is the previous result. Several statements
+separated by ';' are evaluated together, e.g. a = 2+3; b = a*4; b-1.
+Numbers may have units, e.g. 5 m / 2 s, which results keep.
+
+  :ast <expression>    show the parse tree
+  :complex             turn complex mode on and off, e.g. for sqrt(-1)
+  :time [expression]   time one expression, or turn timing of every one on and off
+  :vars                list the variables
+  :help                show this help
+  :quit                leave (or Ctrl-D)
+`
+
+// maxHistory is how many lines the history file keeps.
+const maxHistory = 500
+
+var (
+	errQuit    = errors.New("quit")
+	assignment = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=([^=].*)$`)
+	identifier = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+)
+
+// repl is a session of calcctl repl.
+type repl struct {
+	app     *app
+	out     io.Writer
+	remote  bool
+	complex bool
+	timeout time.Duration
+	timing  bool
+	vars    map[string]quantity
+}
+
+// quantity is a value of the repl: a number, complex in complex mode,
+// with its dimension.
+type quantity struct {
+	v   complex128
+	dim application.Dimension
+}
+
+func runRepl(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("repl")
+	remote := fs.Bool("remote", false, "evaluate on the orchestrator instead of locally")
+	timeout := fs.Duration("timeout", time.Minute, "how long to wait for a remote result")
+	complexMode := fs.Bool("complex", false, "start in complex mode, see :complex")
+	if _, err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if *remote {
+		if err := a.authorize(); err != nil {
+			return err
+		}
+	}
+	r := &repl{app: a, out: a.stdout, remote: *remote, complex: *complexMode, timeout: *timeout, vars: map[string]quantity{}}
+
+	readLine := bufio.NewScanner(a.stdin)
+	read := func() (string, error) {
+		if !readLine.Scan() {
+			if err := readLine.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return readLine.Text(), nil
+	}
+	// a terminal gets line editing and history; piped input is read as
+	// a script, without prompts
+	if f, ok := a.stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		state, err := term.MakeRaw(int(f.Fd()))
+		if err != nil {
+			return err
+		}
+		defer term.Restore(int(f.Fd()), state)
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{f, a.stdout}, "calc> ")
+		h := loadHistory()
+		defer h.close()
+		t.History = h
+		r.out = t
+		read = t.ReadLine
+		fmt.Fprintln(t, `calcctl repl, :help for help`)
+	}
+
+	for {
+		line, err := read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, term.ErrPasteIndicator) {
+			return err
+		}
+		err = r.exec(ctx, line)
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+		}
+	}
+}
+
+// exec runs one line of input.
+func (r *repl) exec(ctx context.Context, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	if strings.HasPrefix(line, ":") {
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch cmd {
+		case ":q", ":quit", ":exit":
+			return errQuit
+		case ":help", ":h":
+			fmt.Fprint(r.out, replHelp)
+		case ":vars":
+			names := make([]string, 0, len(r.vars))
+			for name := range r.vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(r.out, "%s = %s\n", name, r.format(r.vars[name]))
+			}
+		case ":ast":
+			node, _, err := application.ParseASTInScope(arg, r.scope())
+			if err != nil {
+				return err
+			}
+			r.app.treeTo(r.out, astNode(node), "", "")
+		case ":complex":
+			r.complex = !r.complex
+			fmt.Fprintf(r.out, "complex mode %s\n", map[bool]string{true: "on", false: "off"}[r.complex])
+		case ":time":
+			if arg == "" {
+				r.timing = !r.timing
+				fmt.Fprintf(r.out, "timing %s\n", map[bool]string{true: "on", false: "off"}[r.timing])
+				return nil
+			}
+			return r.print(ctx, "ans", arg, true)
+		default:
+			return fmt.Errorf("unknown command %s, :help lists them", cmd)
+		}
+		return nil
+	}
+	// a line with ';' is a program of its own, its assignments are not
+	// kept
+	if m := assignment.FindStringSubmatch(line); m != nil && !strings.Contains(line, ";") {
+		return r.print(ctx, m[1], m[2], r.timing)
+	}
+	return r.print(ctx, "ans", line, r.timing)
+}
+
+// print evaluates an expression, keeps the result in the variable name
+// and prints it.
+func (r *repl) print(ctx context.Context, name, expr string, timed bool) error {
+	v, timing, err := r.eval(ctx, expr)
+	if err != nil {
+		return err
+	}
+	r.vars[name] = v
+	if name == "ans" {
+		fmt.Fprintln(r.out, r.format(v))
+	} else {
+		fmt.Fprintf(r.out, "%s = %s\n", name, r.format(v))
+	}
+	if timed {
+		fmt.Fprintln(r.out, timing)
+	}
+	return nil
+}
+
+// format prints a value the way calc does: a+bi in complex mode, with
+// its unit in base units.
+func (r *repl) format(q quantity) string {
+	re := real(q.v)
+	var c *string
+	if r.complex {
+		s := application.FormatComplex(q.v)
+		c = &s
+	}
+	return value(&re, c, q.dim.String())
+}
+
+// scope passes the variables, with their units and imaginary parts, to
+// the parser.
+func (r *repl) scope() application.Scope {
+	scope := application.Scope{
+		Vars:    make(map[string]float64, len(r.vars)),
+		Imag:    make(map[string]float64, len(r.vars)),
+		Dims:    make(map[string]application.Dimension, len(r.vars)),
+		Complex: r.complex,
+	}
+	for name, q := range r.vars {
+		scope.Vars[name], scope.Imag[name], scope.Dims[name] = real(q.v), imag(q.v), q.dim
+	}
+	return scope
+}
+
+// eval computes an expression locally or on the orchestrator and
+// describes how long it took.
+func (r *repl) eval(ctx context.Context, src string) (quantity, string, error) {
+	start := time.Now()
+	if !r.remote {
+		node, _, err := application.ParseASTInScope(src, r.scope())
+		if err != nil {
+			return quantity{}, "", err
+		}
+		var v complex128
+		if r.complex {
+			v, err = application.EvalComplexAST(node)
+		} else if name := complexVar(node, map[*application.ASTNode]bool{}); name != "" {
+			return quantity{}, "", fmt.Errorf("%s is a complex number, turn on complex mode with :complex", name)
+		} else {
+			var re float64
+			re, err = application.EvalAST(node)
+			v = complex(re, 0)
+		}
+		if errors.Is(err, calculation.ErrNotReal) {
+			return quantity{}, "", fmt.Errorf("%w, turn on complex mode with :complex", err)
+		}
+		if err != nil {
+			return quantity{}, "", err
+		}
+		return quantity{v, node.Dim}, fmt.Sprintf("took %s", time.Since(start)), nil
+	}
+
+	vars, err := r.remoteVars(src)
+	if err != nil {
+		return quantity{}, "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	c := r.app.client
+	calculate := c.CalculateWithVariables
+	if r.complex {
+		calculate = c.CalculateComplex
+	}
+	id, err := calculate(ctx, src, vars)
+	if err != nil {
+		return quantity{}, "", err
+	}
+	e, err := c.Wait(ctx, id, 100*time.Millisecond)
+	if err != nil {
+		return quantity{}, "", fmt.Errorf("expression %d: %w", id, err)
+	}
+	q, err := remoteResult(e)
+	if err != nil {
+		return quantity{}, "", err
+	}
+	roundTrip := time.Since(start)
+	d, err := c.Detail(ctx, id)
+	if err != nil {
+		return quantity{}, "", err
+	}
+	return q, fmt.Sprintf("took %s: expression %d, wall clock %dms, critical path %dms",
+		roundTrip.Round(time.Millisecond), id, d.Timings.WallClockMs, d.Timings.CriticalPathMs), nil
+}
+
+// complexVar returns the name of a variable with an imaginary part that
+// the tree uses, if there is one.
+func complexVar(n *application.ASTNode, seen map[*application.ASTNode]bool) string {
+	if n == nil || seen[n] {
+		return ""
+	}
+	seen[n] = true
+	if n.IsLeaf {
+		if n.Imag != 0 {
+			return n.Name
+		}
+		return ""
+	}
+	for _, c := range []*application.ASTNode{n.Cond, n.Left, n.Right} {
+		if name := complexVar(c, seen); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// remoteVars returns the variables to send with src. The API takes
+// plain real numbers only, so a variable src uses that has a unit or an
+// imaginary part is an error rather than being lost.
+func (r *repl) remoteVars(src string) (map[string]float64, error) {
+	vars := make(map[string]float64, len(r.vars))
+	used := map[string]bool{}
+	for _, name := range identifier.FindAllString(src, -1) {
+		used[name] = true
+	}
+	for name, q := range r.vars {
+		if (q.dim != application.Dimension{} || imag(q.v) != 0) && used[name] {
+			return nil, fmt.Errorf("%s = %s cannot be sent to the orchestrator, which takes variables without units and imaginary parts", name, r.format(q))
+		}
+		vars[name] = real(q.v)
+	}
+	return vars, nil
+}
+
+// remoteResult converts a finished expression to a value of the repl.
+func remoteResult(e *client.Expression) (quantity, error) {
+	if e.Status != client.StatusDone || (e.Result == nil && e.ComplexResult == nil) {
+		return quantity{}, fmt.Errorf("expression %d is %s", e.ID, e.Status)
+	}
+	var q quantity
+	if e.ComplexResult != nil {
+		v, err := strconv.ParseComplex(*e.ComplexResult, 128)
+		if err != nil {
+			return quantity{}, fmt.Errorf("expression %d: bad result %q", e.ID, *e.ComplexResult)
+		}
+		q.v = v
+	} else {
+		q.v = complex(*e.Result, 0)
+	}
+	if e.Unit != "" {
+		// the unit is written so that it can follow a number again
+		node, err := application.ParseAST("1 " + e.Unit)
+		if err != nil {
+			return quantity{}, fmt.Errorf("expression %d: bad unit %q", e.ID, e.Unit)
+		}
+		q.dim = node.Dim
+	}
+	return q, nil
+}
+
+// astNode converts a parse tree for printing.
+func astNode(n *application.ASTNode) *client.Node {
+	if n == nil {
+		return nil
+	}
+	if n.IsLeaf {
+		v := n.Value
+		leaf := &client.Node{Value: &v, Variable: n.Name, Unit: n.Dim.String()}
+		if n.Imag != 0 {
+			c := application.FormatComplex(complex(n.Value, n.Imag))
+			leaf.ComplexValue = &c
+		}
+		return leaf
+	}
+	return &client.Node{Variable: n.Name, Operator: n.Operator, Condition: astNode(n.Cond), Left: astNode(n.Left), Right: astNode(n.Right)}
+}
+
+// history keeps the lines of the terminal in the calcctl config
+// directory between sessions.
+type history struct {
+	lines []string
+	file  *os.File
+}
+
+func loadHistory() *history {
+	h := &history{}
+	path, err := configPath("history")
+	if err != nil {
+		return h
+	}
+	if b, err := os.ReadFile(path); err == nil && len(b) > 0 {
+		h.lines = strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+		if len(h.lines) > maxHistory {
+			h.lines = h.lines[len(h.lines)-maxHistory:]
+		}
+		// rewrite the file so that it does not grow forever
+		os.WriteFile(path, []byte(strings.Join(h.lines, "\n")+"\n"), 0o600)
+	}
+	if os.MkdirAll(filepath.Dir(path), 0o700) == nil {
+		h.file, _ = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	}
+	return h
+}
+
+func (h *history) Add(entry string) {
+	if n := len(h.lines); n > 0 && h.lines[n-1] == entry {
+		return
+	}
+	h.lines = append(h.lines, entry)
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[1:]
+	}
+	if h.file != nil {
+		fmt.Fprintln(h.file, entry)
+	}
+}
+
+func (h *history) Len() int { return len(h.lines) }
+
+func (h *history) At(idx int) string { return h.lines[len(h.lines)-1-idx] }
+
+func (h *history) close() {
+	if h.file != nil {
+		h.file.Close()
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// session runs calcctl repl over the given input, which is not a
+// terminal, so it is read as a script without prompts.
+func session(t *testing.T, input string, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	a := &app{stdin: strings.NewReader(input), stdout: &stdout, stderr: &stderr}
+	if err := runRepl(context.Background(), a, args); err != nil {
+		t.Fatalf("repl: %v, %s", err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		line, name, expr string
+	}{
+		{"x = 2+3", "x", " 2+3"},
+		{"_a1=ans", "_a1", "ans"},
+		{"x == 5", "", ""},
+		{"x >= 5", "", ""},
+		{"2x = 5", "", ""},
+		{"f(x) = x", "", ""},
+	}
+	for _, tc := range tests {
+		m := assignment.FindStringSubmatch(tc.line)
+		if tc.name == "" {
+			if m != nil {
+				t.Errorf("%q: expected no assignment, got %q", tc.line, m)
+			}
+			continue
+		}
+		if m == nil || m[1] != tc.name || m[2] != tc.expr {
+			t.Errorf("%q: expected %s = %q, got %q", tc.line, tc.name, tc.expr, m)
+		}
+	}
+}
+
+func TestRepl_Script(t *testing.T) {
+	out := session(t, strings.Join([]string{
+		"x = 2+3",
+		"x*4",
+		"ans + 1",
+		"y=ans",
+		"x == 5",
+		"",
+		"a = 1; a + 1",
+		"1/0",
+		":bogus",
+		":vars",
+		":quit",
+		"99",
+	}, "\n"))
+	lines := strings.Split(out, "\n")
+	want := []string{
+		"x = 5",
+		"20",
+		"21",
+		"y = 21",
+		"1",
+		"2",
+	}
+	for i, w := range want {
+		if i >= len(lines) || lines[i] != w {
+			t.Fatalf("line %d: expected %q, got output\n%s", i+1, w, out)
+		}
+	}
+	rest := strings.Join(lines[len(want):], "\n")
+	if !strings.HasPrefix(rest, "error: ") || !strings.Contains(rest, "error: unknown command :bogus, :help lists them\n") {
+		t.Errorf("expected two errors, got\n%s", rest)
+	}
+	// the assignment inside a line with ';' is not kept
+	if !strings.HasSuffix(out, "ans = 2\nx = 5\ny = 21\n") {
+		t.Errorf("expected :vars and nothing after :quit, got\n%s", out)
+	}
+}
+
+func TestRepl_AstAndTime(t *testing.T) {
+	out := session(t, "x = 3\n:ast 1+2*x\n:time\n1+1\n:time\n2+2\n:time 2*3\n")
+	want := regexp.MustCompile(`^x = 3
+\+
+├── 1
+└── \*
+    ├── 2
+    └── x: 3
+timing on
+2
+took \S+
+timing off
+4
+6
+took \S+
+$`)
+	if !want.MatchString(out) {
+		t.Errorf("unexpected output\n%s", out)
+	}
+	if out := session(t, ":ast 1+\n:help\n"); !strings.HasPrefix(out, "error: ") || !strings.Contains(out, replHelp) {
+		t.Errorf("expected a parse error and the help, got\n%s", out)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	path, err := configPath("history")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a missing file gives an empty history that is created on Add
+	h := loadHistory()
+	if h.Len() != 0 {
+		t.Fatalf("expected no history, got %d lines", h.Len())
+	}
+	h.Add("1+1")
+	h.Add("1+1")
+	h.Add("2+2")
+	h.close()
+	if b, _ := os.ReadFile(path); string(b) != "1+1\n2+2\n" {
+		t.Fatalf("expected repeated lines to be kept once, got %q", b)
+	}
+
+	var lines []string
+	for i := 0; i < maxHistory+100; i++ {
+		lines = append(lines, fmt.Sprint(i))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h = loadHistory()
+	defer h.close()
+	if h.Len() != maxHistory || h.At(0) != fmt.Sprint(maxHistory+99) || h.At(maxHistory-1) != "100" {
+		t.Fatalf("expected the last %d lines, got %d from %q to %q", maxHistory, h.Len(), h.At(h.Len()-1), h.At(0))
+	}
+	if b, _ := os.ReadFile(path); strings.Count(string(b), "\n") != maxHistory {
+		t.Errorf("expected the file to be trimmed to %d lines, got %d", maxHistory, strings.Count(string(b), "\n"))
+	}
+
+	h.Add("last")
+	if h.Len() != maxHistory || h.At(0) != "last" || h.At(maxHistory-1) != "101" {
+		t.Errorf("expected Add to drop the oldest line, got %d from %q to %q", h.Len(), h.At(h.Len()-1), h.At(0))
+	}
+	if b, _ := os.ReadFile(path); !strings.HasSuffix(string(b), "\nlast\n") {
+		t.Errorf("expected the line to be appended to the file")
+	}
+}
+
+func TestRepl_UnitsAndComplex(t *testing.T) {
+	out := session(t, strings.Join([]string{
+		"d = 100 m",
+		"t = 20 s",
+		"d / t",
+		"ans * 2 s",
+		"ans + 1 s",
+		"1 km / 1 h",
+		":vars",
+		"sqrt(-1)",
+		":complex",
+		"z = sqrt(-4) + 1",
+		"z * conj(z)",
+		":ast 2 km * z",
+		":complex",
+		"z + 1",
+	}, "\n"))
+	want := `d = 100 m
+t = 20 s
+5 m/s
+10 m
+error: + at position 4 needs operands of the same dimension, got m and s
+0.2777777777777778 m/s
+ans = 0.2777777777777778 m/s
+d = 100 m
+t = 20 s
+error: result is not a real number, turn on complex mode with :complex
+complex mode on
+z = 1+2i
+5+0i
+*
+├── 2000 m
+└── z: 1+2i
+complex mode off
+error: z is a complex number, turn on complex mode with :complex
+`
+	if out != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out)
+	}
+	if out := session(t, "sqrt(-4)\n", "--complex"); out != "0+2i\n" {
+		t.Errorf("expected --complex to start in complex mode, got %q", out)
+	}
+}
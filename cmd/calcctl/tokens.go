@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// configPath is the path of a file in the calcctl config directory.
+func configPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "calcctl", name), nil
+}
+
+// tokensPath is the file login saves tokens in, one per server.
+func tokensPath() (string, error) { return configPath("tokens.json") }
+
+func loadTokens() (map[string]string, error) {
+	path, err := tokensPath()
+	if err != nil {
+		return nil, err
+	}
+	tokens := map[string]string{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func saveToken(server, token string) error {
+	tokens, err := loadTokens()
+	if err != nil {
+		return err
+	}
+	tokens[server] = token
+	path, err := tokensPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(tokens, "", "  ")
+	return os.WriteFile(path, b, 0o600)
+}
@@ -1,15 +1,35 @@
-package main
-
-import (
-	"log"
-
-	"github.com/lollmark/digital_calc/internal"
-)
-
-func main() {
-	app := application.NewOrchestrator()
-	log.Println("Starting Orchestrator on port", app.Config.Addr)
-	if err := app.RunServer(); err != nil {
-		log.Fatal(err)
-	}
-}
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := application.SetupLogging(os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+	shutdown, err := application.SetupTracing(context.Background(), "orchestrator")
+	if err != nil {
+		slog.Error("cannot set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdown(context.Background())
+
+	app := application.NewOrchestrator()
+	defer app.DB.Close()
+	slog.Info("starting orchestrator", "port", app.Config.Addr)
+	if err := app.RunServer(ctx); err != nil {
+		slog.Error("orchestrator stopped", "error", err)
+		os.Exit(1)
+	}
+}
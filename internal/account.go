@@ -0,0 +1,188 @@
+package application
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrLoginLength     = errors.New("login must be 3 to 32 characters long")
+	ErrLoginCharset    = errors.New("login may contain only latin letters, digits, '.', '_' and '-'")
+	ErrPasswordLength  = errors.New("password must be 8 to 72 characters long")
+	ErrPasswordWeak    = errors.New("password must contain at least one letter and one digit")
+	ErrPasswordIsLogin = errors.New("password must differ from the login")
+)
+
+func ValidateLogin(login string) error {
+	if len(login) < 3 || len(login) > 32 {
+		return ErrLoginLength
+	}
+	for _, c := range login {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return ErrLoginCharset
+		}
+	}
+	return nil
+}
+
+// ValidatePassword checks the password policy. The upper bound is
+// bcrypt's: it silently ignores everything past 72 bytes.
+func ValidatePassword(pw string) error {
+	if len(pw) < 8 || len(pw) > 72 {
+		return ErrPasswordLength
+	}
+	var letter, digit bool
+	for _, c := range pw {
+		letter = letter || unicode.IsLetter(c)
+		digit = digit || unicode.IsDigit(c)
+	}
+	if !letter || !digit {
+		return ErrPasswordWeak
+	}
+	return nil
+}
+
+func ValidateCredentials(login, pw string) error {
+	if err := ValidateLogin(login); err != nil {
+		return err
+	}
+	if err := ValidatePassword(pw); err != nil {
+		return err
+	}
+	if pw == login {
+		return ErrPasswordIsLogin
+	}
+	return nil
+}
+
+type account struct {
+	ID             int           `db:"id"`
+	PasswordHash   string        `db:"password_hash"`
+	FailedAttempts int           `db:"failed_attempts"`
+	LockedUntil    sql.NullInt64 `db:"locked_until"`
+	TokenVersion   int           `db:"token_version"`
+}
+
+func (a *account) lockedFor(now time.Time) time.Duration {
+	if !a.LockedUntil.Valid {
+		return 0
+	}
+	return time.Unix(a.LockedUntil.Int64, 0).Sub(now)
+}
+
+// recordFailedLogin counts a wrong password and locks the account once
+// MaxLoginAttempts is reached; the counter starts over after the lock.
+func (o *Orchestrator) recordFailedLogin(uid int) {
+	until := time.Now().Add(o.Config.LockoutDuration).Unix()
+	_, err := o.DB.Exec(`
+        UPDATE users
+           SET failed_attempts = CASE WHEN failed_attempts + 1 >= ? THEN 0 ELSE failed_attempts + 1 END,
+               locked_until    = CASE WHEN failed_attempts + 1 >= ? THEN ? ELSE locked_until END
+         WHERE id = ?`,
+		o.Config.MaxLoginAttempts, o.Config.MaxLoginAttempts, until, uid,
+	)
+	if err != nil {
+		slog.Error("failed to record login failure", "user_id", uid, "error", err)
+	}
+}
+
+func (o *Orchestrator) resetFailedLogins(uid int) {
+	if _, err := o.DB.Exec("UPDATE users SET failed_attempts = 0, locked_until = NULL WHERE id = ?", uid); err != nil {
+		slog.Error("failed to reset login failures", "user_id", uid, "error", err)
+	}
+}
+
+// ChangePasswordHandler — POST /api/v1/password
+func (o *Orchestrator) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	var req ChangePasswordReq
+	if !o.decodeJSON(w, r, &req) {
+		return
+	}
+	var u struct {
+		Login        string `db:"login"`
+		PasswordHash string `db:"password_hash"`
+	}
+	if err := o.DB.Get(&u, "SELECT login,password_hash FROM users WHERE id=?", uid); err != nil {
+		apiError(w, http.StatusNotFound, CodeNotFound, "user not found")
+		return
+	}
+	if err := CheckPassword(u.PasswordHash, req.OldPassword); err != nil {
+		apiError(w, http.StatusUnauthorized, CodeInvalidCredentials, "invalid creds")
+		return
+	}
+	if err := ValidateCredentials(u.Login, req.NewPassword); err != nil {
+		apiError(w, http.StatusBadRequest, CodeValidationFailed, err.Error())
+		return
+	}
+	if req.NewPassword == req.OldPassword {
+		apiError(w, http.StatusBadRequest, CodeValidationFailed, "new password must differ from the old one")
+		return
+	}
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	// tokens issued before stop working, see checkToken
+	if _, err := o.DB.Exec("UPDATE users SET password_hash=?, token_version=token_version+1 WHERE id=?", hash, uid); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteAccountHandler — DELETE /api/v1/account. The current password
+// must be repeated in the body; everything the user submitted goes too.
+func (o *Orchestrator) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	var req DeleteAccountReq
+	if !o.decodeJSON(w, r, &req) {
+		return
+	}
+	var hash string
+	if err := o.DB.Get(&hash, "SELECT password_hash FROM users WHERE id=?", uid); err != nil {
+		apiError(w, http.StatusNotFound, CodeNotFound, "user not found")
+		return
+	}
+	if err := CheckPassword(hash, req.Password); err != nil {
+		apiError(w, http.StatusUnauthorized, CodeInvalidCredentials, "invalid creds")
+		return
+	}
+	if err := o.deleteUser(uid); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o *Orchestrator) deleteUser(uid int) error {
+	tx, err := o.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		"DELETE FROM tasks WHERE expr_id IN (SELECT id FROM expressions WHERE user_id = ?)",
+		"DELETE FROM webhook_deliveries WHERE expr_id IN (SELECT id FROM expressions WHERE user_id = ?)",
+		"DELETE FROM batch_items WHERE batch_id IN (SELECT id FROM batches WHERE user_id = ?)",
+		"DELETE FROM batches WHERE user_id = ?",
+		"DELETE FROM idempotency_keys WHERE user_id = ?",
+		"DELETE FROM definitions WHERE user_id = ?",
+		"DELETE FROM expressions WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, q := range stmts {
+		if _, err := tx.Exec(q, uid); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
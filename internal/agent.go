@@ -1,77 +1,208 @@
-package application
-
-import (
-	"context"
-	"log"
-	"os"
-	"strconv"
-	"time"
-
-	"github.com/lollmark/digital_calc/pkg/calculator"
-	"github.com/lollmark/digital_calc/proto/calc"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/status"
-)
-
-type Agent struct {
-	ComputingPower int
-	grpcClient     calc.CalcClient
-}
-
-func NewAgent() *Agent {
-	cp, err := strconv.Atoi(os.Getenv("COMPUTING_POWER"))
-	if err != nil || cp < 1 {
-		cp = 1
-	}
-	target := os.Getenv("ORCHESTRATOR_URL")
-	if target == "" {
-		target = "localhost:8080"
-	} else {
-		target = target[len("http://"):]
-	}
-	conn, err := grpc.Dial(
-		target,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-	if err != nil {
-		log.Fatalf("agent: cannot connect to gRPC: %v", err)
-	}
-	client := calc.NewCalcClient(conn)
-	return &Agent{ComputingPower: cp, grpcClient: client}
-}
-
-func (a *Agent) Run() {
-	for i := 0; i < a.ComputingPower; i++ {
-		go a.Worker(i)
-	}
-	select {}
-}
-
-func (a *Agent) Worker(id int) {
-	for {
-		task, err := a.grpcClient.GetTask(context.Background(), &calc.Empty{})
-		if err != nil {
-			if st, ok := status.FromError(err); ok && st.Code() == codes.NotFound {
-				time.Sleep(500 * time.Millisecond)
-				continue
-			}
-			log.Printf("worker %d: GetTask error: %v", id, err)
-			time.Sleep(500 * time.Millisecond)
-			continue
-		}
-		time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
-		result, err := calculation.Compute(task.Operation, task.Arg1, task.Arg2)
-		if err != nil {
-			continue
-		}
-		_, err = a.grpcClient.PostResult(context.Background(), &calc.ResultReq{
-			Id:     task.Id,
-			Result: result,
-		})
-		if err != nil {
-			log.Printf("worker %d: PostResult error: %v", id, err)
-		}
-	}
-}
+package application
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lollmark/digital_calc/proto/calc"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type Agent struct {
+	ID             string
+	ComputingPower int
+	MetricsAddr    string // пусто — метрики не отдаются
+	// ShutdownTimeout is how long workers may keep computing the tasks
+	// they hold once Run is asked to stop; unfinished tasks are released.
+	ShutdownTimeout time.Duration
+	grpcClient      calc.CalcClient
+}
+
+func NewAgent() *Agent {
+	cp, err := strconv.Atoi(os.Getenv("COMPUTING_POWER"))
+	if err != nil || cp < 1 {
+		cp = 1
+	}
+	target := os.Getenv("ORCHESTRATOR_URL")
+	if target == "" {
+		target = "localhost:8080"
+	} else {
+		target = target[len("http://"):]
+	}
+	conn, err := grpc.Dial(
+		target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		slog.Error("cannot connect to the orchestrator", "target", target, "error", err)
+		os.Exit(1)
+	}
+	id := os.Getenv("AGENT_ID")
+	if id == "" {
+		host, _ := os.Hostname()
+		id = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	st, _ := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_SEC"))
+	if st == 0 {
+		st = 30
+	}
+	client := calc.NewCalcClient(conn)
+	return &Agent{
+		ID:              id,
+		ComputingPower:  cp,
+		MetricsAddr:     os.Getenv("AGENT_METRICS_ADDR"),
+		ShutdownTimeout: time.Duration(st) * time.Second,
+		grpcClient:      client,
+	}
+}
+
+// Run starts ComputingPower workers and returns once ctx is done and
+// every worker has finished or released its task.
+func (a *Agent) Run(ctx context.Context) {
+	if a.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", AgentMetricsHandler())
+		srv := &http.Server{Addr: a.MetricsAddr, Handler: mux}
+		go func() {
+			slog.Info("agent metrics listening", "addr", a.MetricsAddr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("agent metrics server failed", "error", err)
+			}
+		}()
+		defer srv.Close()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < a.ComputingPower; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			a.Worker(ctx, id)
+		}(i)
+	}
+	<-ctx.Done()
+	slog.Info("shutting down", "timeout", a.ShutdownTimeout)
+	wg.Wait()
+	slog.Info("stopped")
+}
+
+// Worker takes and computes tasks until ctx is done. A task in hand at
+// that moment is still finished if it takes no longer than
+// ShutdownTimeout; otherwise it is handed back to the queue.
+func (a *Agent) Worker(ctx context.Context, id int) {
+	// оркестратор показывает, какой воркер какого агента считает задачу
+	agent := fmt.Sprintf("%s/%d", a.ID, id)
+	md := metadata.Pairs(agentIDKey, agent)
+	ctx = withLog(metadata.NewOutgoingContext(ctx, md), "agent_id", agent)
+	// results and releases are sent even when ctx is already done
+	detached := context.WithoutCancel(ctx)
+	worker := strconv.Itoa(id)
+	for ctx.Err() == nil {
+		var header metadata.MD
+		// not cancelled with ctx: a task the orchestrator already handed
+		// out must reach the worker so it can be finished or released
+		task, err := a.grpcClient.GetTask(detached, &calc.Empty{}, grpc.Header(&header))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if st, ok := status.FromError(err); !ok || st.Code() != codes.NotFound {
+				slog.ErrorContext(ctx, "GetTask failed", "error", err)
+				agentErrors.WithLabelValues(worker, "get_task").Inc()
+			}
+			sleepCtx(ctx, 500*time.Millisecond)
+			continue
+		}
+		// the orchestrator sends the trace of the task in the header;
+		// Compute continues it and PostResult carries it back
+		var tp string
+		if v := header.Get(traceParentKey); len(v) > 0 {
+			tp = v[0]
+		}
+		tctx, span := tracer().Start(withTraceParent(withLog(detached, "task_id", task.Id), tp), "Compute", trace.WithAttributes(
+			attribute.String("task.id", task.Id),
+			attribute.String("task.operation", task.Operation),
+		))
+		start := time.Now()
+		if !a.wait(ctx, time.Duration(task.OperationTime)*time.Millisecond) {
+			span.SetStatus(otelcodes.Error, "released on shutdown")
+			span.End()
+			if _, err := a.grpcClient.ReleaseTask(tctx, &calc.ReleaseReq{Id: task.Id}); err != nil {
+				slog.ErrorContext(tctx, "ReleaseTask failed", "error", err)
+				agentErrors.WithLabelValues(worker, "release").Inc()
+			} else {
+				slog.InfoContext(tctx, "task released on shutdown")
+			}
+			return
+		}
+		result, err := compute(task.Operation, complex(task.Arg1, task.Arg1Imag), complex(task.Arg2, task.Arg2Imag), task.Complex)
+		agentBusy.WithLabelValues(worker).Add(time.Since(start).Seconds())
+		if err != nil {
+			slog.WarnContext(tctx, "compute failed", "operation", task.Operation, "error", err)
+			span.SetStatus(otelcodes.Error, err.Error())
+			span.End()
+			agentErrors.WithLabelValues(worker, "compute").Inc()
+			continue
+		}
+		span.End()
+		pctx := tctx
+		if tp := traceParent(tctx); tp != "" {
+			pctx = metadata.AppendToOutgoingContext(tctx, traceParentKey, tp)
+		}
+		_, err = a.grpcClient.PostResult(pctx, &calc.ResultReq{
+			Id:         task.Id,
+			Result:     real(result),
+			ResultImag: imag(result),
+		})
+		if err != nil {
+			slog.ErrorContext(tctx, "PostResult failed", "error", err)
+			agentErrors.WithLabelValues(worker, "post_result").Inc()
+			continue
+		}
+		slog.DebugContext(tctx, "task computed", "operation", task.Operation, "result", result)
+		agentTasks.WithLabelValues(worker).Inc()
+	}
+}
+
+// wait sleeps for the operation time of a task. If ctx is done first it
+// keeps waiting for at most ShutdownTimeout and reports whether the
+// task made it.
+func (a *Agent) wait(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+	}
+	deadline := time.NewTimer(a.ShutdownTimeout)
+	defer deadline.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-deadline.C:
+		return false
+	}
+}
+
+// sleepCtx sleeps for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,81 @@
+package application
+
+// Bodies of the HTTP API requests and responses that are not stored
+// rows. openapi.go builds the published schemas from these types, so a
+// field added here shows up in /api/v1/openapi.json.
+
+type Credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type LoginResp struct {
+	Token string `json:"token"`
+}
+
+type CalculateReq struct {
+	Expression  string             `json:"expression"`
+	Variables   map[string]float64 `json:"variables,omitempty"`
+	CallbackURL string             `json:"callback_url,omitempty"`
+	// Complex computes the expression over complex numbers.
+	Complex bool `json:"complex,omitempty"`
+}
+
+type CalculateResp struct {
+	ID int64 `json:"id"`
+}
+
+type BatchReq struct {
+	Items []BatchItemReq `json:"items"`
+}
+
+type ChangePasswordReq struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type DeleteAccountReq struct {
+	Password string `json:"password"`
+}
+
+type UsageCounter struct {
+	Used  int `json:"used"`
+	Limit int `json:"limit"`
+}
+
+type UsageResp struct {
+	RequestsPerMinute   UsageCounter `json:"requests_per_minute"`
+	PendingExpressions  UsageCounter `json:"pending_expressions"`
+	MaxASTNodes         int          `json:"max_ast_nodes"`
+	MaxExpressionLength int          `json:"max_expression_length"`
+}
+
+// HealthChecks holds "ok" or the reason a dependency is not ready.
+type HealthChecks struct {
+	DB   string `json:"db"`
+	GRPC string `json:"grpc"`
+}
+
+type HealthResp struct {
+	Status string        `json:"status"`
+	Checks *HealthChecks `json:"checks,omitempty"`
+}
+
+type ExpressionList struct {
+	Expressions []Expression `json:"expressions"`
+	NextCursor  string       `json:"next_cursor,omitempty"`
+}
+
+// ExpressionStatus is the short form of GET /api/v1/expressions/{id}.
+// Its capitalized keys predate the other endpoints; the web UI reads them.
+type ExpressionStatus struct {
+	ID            int      `db:"id" json:"ID"`
+	Status        string   `db:"status" json:"Status"`
+	Result        *float64 `db:"result" json:"Result"`
+	ComplexResult *string  `db:"complex_result" json:"ComplexResult,omitempty"`
+	Unit          *string  `db:"unit" json:"Unit,omitempty"`
+}
+
+type ExpressionStatusResp struct {
+	Expression ExpressionStatus `json:"expression"`
+}
@@ -0,0 +1,129 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Machine-readable codes of ErrorBody.
+const (
+	CodeBadRequest          = "bad_request"
+	CodeInvalidJSON         = "invalid_json"
+	CodeBodyTooLarge        = "body_too_large"
+	CodeValidationFailed    = "validation_failed"
+	CodeInvalidExpression   = "invalid_expression"
+	CodeMissingToken        = "missing_token"
+	CodeInvalidToken        = "invalid_token"
+	CodeInvalidCredentials  = "invalid_credentials"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeUserExists          = "user_exists"
+	CodeNotPending          = "not_pending"
+	CodeDefinitionInUse     = "definition_in_use"
+	CodeRateLimited         = "rate_limited"
+	CodeTooManyPending      = "too_many_pending"
+	CodeAccountLocked       = "account_locked"
+	CodeIdempotencyConflict = "idempotency_conflict"
+	CodeIdempotencyMismatch = "idempotency_key_reused"
+	CodeInternal            = "internal_error"
+)
+
+type ErrorInfo struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrorBody is the body of every error response of the HTTP API.
+type ErrorBody struct {
+	Error ErrorInfo `json:"error"`
+}
+
+func apiError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorBody{ErrorInfo{code, msg}})
+}
+
+// internalError logs err with the request's log context and answers 500
+// without details.
+func internalError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "request failed", "error", err)
+	apiError(w, http.StatusInternalServerError, CodeInternal, "server error")
+}
+
+// writeError answers an HTTP request with the status matching err.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		input *InvalidInputError
+		expr  *InvalidExpressionError
+		limit *LimitError
+	)
+	switch {
+	case errors.As(err, &input):
+		apiError(w, http.StatusBadRequest, CodeValidationFailed, err.Error())
+	case errors.As(err, &expr):
+		apiError(w, http.StatusUnprocessableEntity, CodeInvalidExpression, err.Error())
+	case errors.As(err, &limit):
+		retryAfter(w, limit.RetryAfter)
+		apiError(w, http.StatusTooManyRequests, limit.Code, err.Error())
+	case errors.Is(err, ErrUserExists):
+		apiError(w, http.StatusConflict, CodeUserExists, err.Error())
+	case errors.Is(err, ErrInvalidCreds):
+		apiError(w, http.StatusUnauthorized, CodeInvalidCredentials, err.Error())
+	case errors.Is(err, ErrNotFound):
+		apiError(w, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, ErrNotPending):
+		apiError(w, http.StatusConflict, CodeNotPending, err.Error())
+	case errors.Is(err, ErrDefinitionInUse):
+		apiError(w, http.StatusConflict, CodeDefinitionInUse, err.Error())
+	default:
+		internalError(w, r, err)
+	}
+}
+
+// decodeJSON reads the request body into dst, rejecting bodies over
+// Config.MaxBodyBytes, unknown fields and trailing data. On failure it
+// writes the error response and returns false.
+func (o *Orchestrator) decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, o.Config.MaxBodyBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must contain a single JSON object")
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &tooLarge):
+		apiError(w, http.StatusRequestEntityTooLarge, CodeBodyTooLarge,
+			fmt.Sprintf("body is larger than %d bytes", tooLarge.Limit))
+	case errors.Is(err, io.EOF):
+		apiError(w, http.StatusBadRequest, CodeInvalidJSON, "body must not be empty")
+	default:
+		apiError(w, http.StatusBadRequest, CodeInvalidJSON, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return false
+}
+
+// allowMethods answers 405 with an Allow header to requests whose method
+// is not listed.
+func allowMethods(h http.Handler, methods ...string) http.Handler {
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method == m {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", allow)
+		apiError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not allowed here")
+	})
+}
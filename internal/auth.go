@@ -1,62 +1,107 @@
-package application
-
-import (
-	"context"
-	"net/http"
-	"strings"
-	"time"
-
-	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
-)
-
-var jwtKey = []byte("your-very-secret-key")
-
-type Claims struct {
-	UserID int `json:"user_id"`
-	jwt.RegisteredClaims
-}
-
-func HashPassword(pw string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
-	return string(hash), err
-}
-
-func CheckPassword(hash, pw string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
-}
-
-// Middleware: require JWT
-func (o *Orchestrator) AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenStr := r.Header.Get("Authorization") // e.g. "Bearer eyJ..."
-		if strings.HasPrefix(tokenStr, "Bearer ") {
-			tokenStr = tokenStr[len("Bearer "):]
-		}
-		if tokenStr == "" {
-			http.Error(w, "missing token", http.StatusUnauthorized)
-			return
-		}
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
-			return
-		}
-		ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
-
-func CreateToken(userID int) (string, error) {
-	claims := &Claims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
-}
+package application
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var jwtKey = []byte("your-very-secret-key")
+
+// ErrInvalidToken rejects a token that is malformed, expired or
+// revoked: its user changed the password since or no longer exists.
+var ErrInvalidToken = errors.New("invalid token")
+
+type Claims struct {
+	UserID int `json:"user_id"`
+	// TokenVersion is users.token_version when the token was issued;
+	// changing the password bumps it, which revokes older tokens.
+	TokenVersion int `json:"token_version"`
+	jwt.RegisteredClaims
+}
+
+func HashPassword(pw string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func CheckPassword(hash, pw string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
+}
+
+// Middleware: require JWT
+func (o *Orchestrator) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenStr := r.Header.Get("Authorization") // e.g. "Bearer eyJ..."
+		if strings.HasPrefix(tokenStr, "Bearer ") {
+			tokenStr = tokenStr[len("Bearer "):]
+		}
+		if tokenStr == "" {
+			apiError(w, http.StatusUnauthorized, CodeMissingToken, "missing token")
+			return
+		}
+		uid, err := o.checkToken(tokenStr)
+		if errors.Is(err, ErrInvalidToken) {
+			apiError(w, http.StatusUnauthorized, CodeInvalidToken, "invalid token")
+			return
+		}
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		ctx := withUser(r.Context(), uid)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ParseToken checks the signature and expiry of a token issued by
+// CreateToken and returns its claims.
+func ParseToken(tokenStr string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// checkToken parses a token and returns its user id if the user still
+// exists and has not changed the password since the token was issued;
+// otherwise it returns ErrInvalidToken, or the error of the lookup.
+func (o *Orchestrator) checkToken(tokenStr string) (int, error) {
+	claims, err := ParseToken(tokenStr)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	var version int
+	err = o.DB.Get(&version, "SELECT token_version FROM users WHERE id=?", claims.UserID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && version != claims.TokenVersion {
+		return 0, ErrInvalidToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
+
+func CreateToken(userID, tokenVersion int) (string, error) {
+	claims := &Claims{
+		UserID:       userID,
+		TokenVersion: tokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtKey)
+}
@@ -0,0 +1,215 @@
+package application
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type BatchItemReq struct {
+	ClientID   string             `json:"client_id,omitempty"`
+	Expression string             `json:"expression"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	Complex    bool               `json:"complex,omitempty"`
+}
+
+// BatchItem is the outcome of one submitted item: either the id of the
+// stored expression or the reason it was rejected.
+type BatchItem struct {
+	ClientID      string   `db:"client_id" json:"client_id,omitempty"`
+	ID            *int64   `db:"expr_id" json:"id,omitempty"`
+	Error         *string  `db:"error" json:"error,omitempty"`
+	Status        *string  `db:"status" json:"status,omitempty"`
+	Result        *float64 `db:"result" json:"result,omitempty"`
+	ComplexResult *string  `db:"complex_result" json:"complex_result,omitempty"`
+	Unit          *string  `db:"unit" json:"unit,omitempty"`
+}
+
+type BatchResp struct {
+	BatchID int64       `json:"batch_id"`
+	Items   []BatchItem `json:"items"`
+}
+
+// Batch is the pollable state of a batch. Total counts every item,
+// Failed the ones rejected at submission.
+type Batch struct {
+	ID        int64       `json:"id"`
+	CreatedAt Millis      `json:"created_at"`
+	Total     int         `json:"total"`
+	Pending   int         `json:"pending"`
+	Done      int         `json:"done"`
+	Failed    int         `json:"failed"`
+	Cancelled int         `json:"cancelled"`
+	Items     []BatchItem `json:"items"`
+}
+
+type preparedItem struct {
+	BatchItemReq
+	*preparedExpr
+	err error
+}
+
+// BatchHandler — POST /api/v1/calculate/batch. Every item is validated
+// on its own; the valid ones are stored and scheduled in one transaction
+// and the invalid ones are reported next to them.
+func (o *Orchestrator) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	var req BatchReq
+	if !o.decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Items) == 0 {
+		apiError(w, http.StatusBadRequest, CodeValidationFailed, "batch is empty")
+		return
+	}
+	if len(req.Items) > o.Config.MaxBatchSize {
+		apiError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("batch has more than %d items", o.Config.MaxBatchSize))
+		return
+	}
+	if err := o.allowRequest(uid); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	defs, err := definitions(o.DB, uid)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	items := make([]preparedItem, len(req.Items))
+	pending := 0
+	for i, it := range req.Items {
+		items[i].BatchItemReq = it
+		if items[i].err = o.validateVariables(it.Variables); items[i].err == nil {
+			items[i].preparedExpr, items[i].err = o.prepareExpression(it.Expression, it.Variables, defs, it.Complex)
+		}
+		if items[i].err == nil && !items[i].immediate() {
+			pending++
+		}
+	}
+	if pending > 0 {
+		unlock := o.pending.lock(uid)
+		defer unlock()
+		if err := o.checkPending(uid, pending); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	resp, err := o.insertBatch(r.Context(), uid, items)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (o *Orchestrator) insertBatch(ctx context.Context, uid int, items []preparedItem) (*BatchResp, error) {
+	tx, err := o.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO batches(user_id, created_at) VALUES(?, ?)", uid, NowMillis())
+	if err != nil {
+		return nil, err
+	}
+	batchID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]BatchItem, len(items))
+	for i, it := range items {
+		out[i].ClientID = it.ClientID
+		if it.err != nil {
+			msg := it.err.Error()
+			out[i].Error = &msg
+		} else {
+			// one trace per expression, as for single submissions,
+			// linked to the trace of the batch request
+			ictx, span := tracer().Start(ctx, "Calculate",
+				trace.WithNewRoot(), trace.WithLinks(trace.LinkFromContext(ctx)))
+			id, err := o.insertExpression(ictx, tx, uid, it.preparedExpr, "")
+			span.SetAttributes(attribute.Int64("expression.id", id))
+			span.End()
+			if err != nil {
+				return nil, err
+			}
+			out[i].ID = &id
+		}
+		_, err := tx.Exec(
+			"INSERT INTO batch_items(batch_id, position, client_id, expr_id, error) VALUES(?, ?, ?, ?, ?)",
+			batchID, i, it.ClientID, out[i].ID, out[i].Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &BatchResp{BatchID: batchID, Items: out}, nil
+}
+
+// GetBatch loads a batch of uid with the current state of its items.
+func (o *Orchestrator) GetBatch(uid int, id int64) (*Batch, error) {
+	b := &Batch{ID: id}
+	err := o.DB.Get(&b.CreatedAt, "SELECT created_at FROM batches WHERE id = ? AND user_id = ?", id, uid)
+	if err != nil {
+		return nil, err
+	}
+	err = o.DB.Select(&b.Items, `
+        SELECT bi.client_id, bi.expr_id, bi.error, e.status, e.result, e.complex_result, e.unit
+          FROM batch_items bi
+          LEFT JOIN expressions e ON e.id = bi.expr_id
+         WHERE bi.batch_id = ?
+         ORDER BY bi.position`, id)
+	if err != nil {
+		return nil, err
+	}
+	b.Total = len(b.Items)
+	for _, it := range b.Items {
+		switch {
+		case it.Error != nil:
+			b.Failed++
+		case it.Status != nil && *it.Status == "done":
+			b.Done++
+		case it.Status != nil && *it.Status == "cancelled":
+			b.Cancelled++
+		default:
+			b.Pending++
+		}
+	}
+	return b, nil
+}
+
+// batchByIDHandler — GET /api/v1/batches/{id}
+func (o *Orchestrator) batchByIDHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	id, err := strconv.ParseInt(r.URL.Path[len("/api/v1/batches/"):], 10, 64)
+	if err != nil {
+		apiError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	b, err := o.GetBatch(uid, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		apiError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
@@ -1,39 +1,232 @@
-package application
-
-import (
-	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
-)
-
-func NewDB(path string) (*sqlx.DB, error) {
-	db, err := sqlx.Connect("sqlite3", path)
-	if err != nil {
-		return nil, err
-	}
-	schema := `
-    CREATE TABLE IF NOT EXISTS users (
-      id INTEGER PRIMARY KEY AUTOINCREMENT,
-      login TEXT UNIQUE NOT NULL,
-      password_hash TEXT NOT NULL
-    );
-    CREATE TABLE IF NOT EXISTS expressions (
-      id INTEGER PRIMARY KEY AUTOINCREMENT,
-      user_id INTEGER NOT NULL,
-      expr TEXT NOT NULL,
-      status TEXT NOT NULL,
-      result REAL,
-      FOREIGN KEY(user_id) REFERENCES users(id)
-    );
-    CREATE TABLE IF NOT EXISTS tasks (
-      id TEXT PRIMARY KEY,
-      expr_id INTEGER NOT NULL,
-      arg1 REAL,
-      arg2 REAL,
-      operation TEXT,
-      operation_time INTEGER,
-      done BOOLEAN NOT NULL DEFAULT 0,
-      FOREIGN KEY(expr_id) REFERENCES expressions(id)
-    );`
-	_, err = db.Exec(schema)
-	return db, err
-}
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// schema creates the tables as they were first released. Columns added
+// to them later are declared only in migrations, which add them to new
+// and old databases alike.
+const schema = `
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS expressions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	expr TEXT NOT NULL,
+	status TEXT NOT NULL,
+	result REAL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS batches (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS batch_items (
+	batch_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	client_id TEXT,
+	expr_id INTEGER,
+	error TEXT,
+	PRIMARY KEY(batch_id, position),
+	FOREIGN KEY(batch_id) REFERENCES batches(id),
+	FOREIGN KEY(expr_id) REFERENCES expressions(id)
+);
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	status_code INTEGER NOT NULL,
+	content_type TEXT,
+	body BLOB,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY(user_id, key),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	expr_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL,
+	last_error TEXT,
+	delivered_at INTEGER,
+	failed_at INTEGER,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(expr_id) REFERENCES expressions(id)
+);
+CREATE TABLE IF NOT EXISTS definitions (
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	params TEXT NOT NULL DEFAULT '',
+	body TEXT,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY(user_id, name, version),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);` + tasksTable
+
+// tasksTable holds one row per operator node of an expression's AST;
+// node is the pre-order index assigned by numberNodes. It is the table
+// rebuildTasks converts the first release to; later columns are in
+// migrations.
+const tasksTable = `
+CREATE TABLE IF NOT EXISTS tasks (
+	id TEXT PRIMARY KEY,
+	expr_id INTEGER NOT NULL,
+	node INTEGER DEFAULT 0,
+	arg1 REAL,
+	arg2 REAL,
+	operation TEXT,
+	operation_time INTEGER,
+	in_progress BOOLEAN NOT NULL DEFAULT 0,
+	done BOOLEAN NOT NULL DEFAULT 0,
+	result REAL,
+	agent_id TEXT,
+	queued_at INTEGER,
+	started_at INTEGER,
+	finished_at INTEGER,
+	UNIQUE(expr_id, node),
+	FOREIGN KEY(expr_id) REFERENCES expressions(id)
+);`
+
+// migrations are the columns added after the first release of a table,
+// the only place they are declared; CREATE TABLE IF NOT EXISTS leaves
+// old databases untouched, so they are added here one by one.
+var migrations = []struct{ table, column, decl string }{
+	{"users", "failed_attempts", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "locked_until", "INTEGER"},
+	{"users", "token_version", "INTEGER NOT NULL DEFAULT 0"},
+	{"expressions", "created_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"expressions", "updated_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"expressions", "finished_at", "INTEGER"},
+	{"expressions", "callback_url", "TEXT"},
+	{"expressions", "variables", "TEXT"},
+	{"expressions", "definitions", "TEXT"},
+	{"expressions", "complex", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"expressions", "complex_result", "TEXT"},
+	{"expressions", "unit", "TEXT"},
+	{"tasks", "trace_parent", "TEXT"},
+	{"tasks", "arg1_imag", "REAL NOT NULL DEFAULT 0"},
+	{"tasks", "arg2_imag", "REAL NOT NULL DEFAULT 0"},
+	{"tasks", "result_imag", "REAL NOT NULL DEFAULT 0"},
+}
+
+// indexes may reference migrated columns, so they are created last.
+const indexes = `
+CREATE INDEX IF NOT EXISTS expressions_user_created ON expressions(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(next_attempt_at)
+	WHERE delivered_at IS NULL AND failed_at IS NULL;`
+
+func NewDB(path string) (*sqlx.DB, error) {
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows a single writer anyway, and ":memory:" databases
+	// are per connection, so keep the pool to one connection.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := rebuildTasks(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, m := range migrations {
+		if err := addColumn(db, m.table, m.column, m.decl); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := db.Exec(indexes); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// rebuildTasks converts the tasks table of the first release, which
+// was unique on (expr_id, arg1, arg2, operation) and had no node column.
+// Legacy rows keep a NULL node; the scheduler queues those nodes anew.
+// The very first tables had no in_progress column either.
+func rebuildTasks(db *sqlx.DB) error {
+	var n int
+	if err := db.Get(&n, "SELECT COUNT(*) FROM pragma_table_info('tasks') WHERE name = 'node'"); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if err := addColumn(db, "tasks", "in_progress", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		"ALTER TABLE tasks RENAME TO tasks_legacy",
+		tasksTable,
+		`INSERT INTO tasks(id, expr_id, node, arg1, arg2, operation, operation_time, in_progress, done)
+         SELECT id, expr_id, NULL, arg1, arg2, operation, operation_time, in_progress, done FROM tasks_legacy`,
+		"DROP TABLE tasks_legacy",
+	}
+	for _, q := range stmts {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func addColumn(db *sqlx.DB, table, column, decl string) error {
+	var n int
+	err := db.Get(&n, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
+
+// Millis is a unix time in milliseconds, the way timestamps are stored
+// in the db. It is rendered as RFC 3339 in JSON.
+type Millis int64
+
+func NowMillis() Millis { return Millis(time.Now().UnixMilli()) }
+
+func (m Millis) Time() time.Time { return time.UnixMilli(int64(m)).UTC() }
+
+func (m Millis) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Time().Format(time.RFC3339Nano))
+}
+
+func (m *Millis) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	*m = Millis(t.UnixMilli())
+	return nil
+}
@@ -0,0 +1,344 @@
+package application
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrDefinitionInUse rejects deleting a definition others refer to.
+var ErrDefinitionInUse = errors.New("definition is in use")
+
+// Definition is a saved constant (no Params) or formula of a user.
+// Every change is stored as a new version; expressions keep the versions
+// they were submitted with.
+type Definition struct {
+	Name      string   `json:"name"`
+	Params    []string `json:"params,omitempty"`
+	Body      string   `json:"body"`
+	Version   int      `json:"version"`
+	CreatedAt Millis   `json:"created_at"`
+}
+
+type DefinitionReq struct {
+	Params []string `json:"params,omitempty"`
+	Body   string   `json:"body"`
+}
+
+type DefinitionList struct {
+	Definitions []Definition `json:"definitions"`
+}
+
+// definitionRow is a row of the definitions table; a NULL body marks
+// the version that deleted the definition.
+type definitionRow struct {
+	Name      string  `db:"name"`
+	Version   int     `db:"version"`
+	Params    string  `db:"params"`
+	Body      *string `db:"body"`
+	CreatedAt Millis  `db:"created_at"`
+}
+
+func (r *definitionRow) definition() *Definition {
+	d := &Definition{Name: r.Name, Version: r.Version, CreatedAt: r.CreatedAt}
+	if r.Params != "" {
+		d.Params = strings.Split(r.Params, ",")
+	}
+	if r.Body != nil {
+		d.Body = *r.Body
+	}
+	return d
+}
+
+// definitions returns the current definitions of a user by name.
+func definitions(q sqlx.Queryer, uid int) (map[string]*Definition, error) {
+	var rows []definitionRow
+	err := sqlx.Select(q, &rows, `
+        SELECT d.name, d.version, d.params, d.body, d.created_at
+          FROM definitions d
+         WHERE d.user_id = ? AND d.body IS NOT NULL
+           AND d.version = (SELECT MAX(version) FROM definitions WHERE user_id = d.user_id AND name = d.name)`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defs := make(map[string]*Definition, len(rows))
+	for i := range rows {
+		defs[rows[i].Name] = rows[i].definition()
+	}
+	return defs, nil
+}
+
+// sortedDefinitions returns the definitions ordered by name.
+func sortedDefinitions(defs map[string]*Definition) []Definition {
+	out := make([]Definition, 0, len(defs))
+	for _, d := range defs {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// checkDefinitions expands every definition with placeholder arguments,
+// which finds unknown names, wrong numbers of arguments, cycles and
+// definitions too large for any expression. Bodies are checked in
+// complex mode, so they may use imaginary numbers; an expression in real
+// mode rejects those when it uses the definition. Units are checked in
+// constants only: a parameter of a formula may be any quantity, so a
+// formula is checked when it is called.
+func (o *Orchestrator) checkDefinitions(defs map[string]*Definition) error {
+	for _, d := range sortedDefinitions(defs) {
+		p := &parser{defs: newExpansion(defs, o.Config.MaxASTNodes, true)}
+		p.defs.skipUnits = len(d.Params) > 0
+		args := make([]*ASTNode, len(d.Params))
+		for i := range args {
+			args[i] = &ASTNode{IsLeaf: true}
+		}
+		if _, err := p.expand(defs[d.Name], args); err != nil {
+			return err
+		}
+		if p.defs.exceeded() {
+			return fmt.Errorf("in %s: expands to more than %d nodes", d.Name, o.Config.MaxASTNodes)
+		}
+	}
+	return nil
+}
+
+func validateDefinition(name string, req DefinitionReq) error {
+	if !variableName.MatchString(name) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	if slices.Contains(Builtins(), name) {
+		return fmt.Errorf("%s is a builtin function", name)
+	}
+	seen := map[string]bool{}
+	for _, p := range req.Params {
+		if !variableName.MatchString(p) {
+			return fmt.Errorf("invalid parameter name %q", p)
+		}
+		if seen[p] || p == name {
+			return fmt.Errorf("parameter %s is repeated or named as the formula", p)
+		}
+		seen[p] = true
+	}
+	return nil
+}
+
+func (o *Orchestrator) ListDefinitions(uid int) ([]Definition, error) {
+	defs, err := definitions(o.DB, uid)
+	if err != nil {
+		return nil, err
+	}
+	return sortedDefinitions(defs), nil
+}
+
+// GetDefinition returns a version of a definition, the current one for
+// version 0.
+func (o *Orchestrator) GetDefinition(uid int, name string, version int) (*Definition, error) {
+	var row definitionRow
+	q := `SELECT name, version, params, body, created_at FROM definitions
+           WHERE user_id = ? AND name = ? ORDER BY version DESC LIMIT 1`
+	args := []interface{}{uid, name}
+	if version > 0 {
+		q = `SELECT name, version, params, body, created_at FROM definitions
+              WHERE user_id = ? AND name = ? AND version = ?`
+		args = append(args, version)
+	}
+	err := o.DB.Get(&row, q, args...)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && row.Body == nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.definition(), nil
+}
+
+// DefinitionVersions returns every version of a definition, oldest
+// first, including the ones before it was last deleted.
+func (o *Orchestrator) DefinitionVersions(uid int, name string) ([]Definition, error) {
+	var rows []definitionRow
+	err := o.DB.Select(&rows, `
+        SELECT name, version, params, body, created_at FROM definitions
+         WHERE user_id = ? AND name = ? AND body IS NOT NULL
+         ORDER BY version`, uid, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+	out := make([]Definition, len(rows))
+	for i := range rows {
+		out[i] = *rows[i].definition()
+	}
+	return out, nil
+}
+
+// PutDefinition saves a new version of a definition and reports
+// whether it was created. All definitions of the user must still
+// expand with it in place.
+func (o *Orchestrator) PutDefinition(ctx context.Context, uid int, name string, req DefinitionReq) (*Definition, bool, error) {
+	if err := validateDefinition(name, req); err != nil {
+		return nil, false, &InvalidInputError{err}
+	}
+	if len(req.Body) > o.Config.MaxExpressionLength {
+		return nil, false, &InvalidExpressionError{fmt.Errorf("body is longer than %d characters", o.Config.MaxExpressionLength)}
+	}
+	tx, err := o.DB.Beginx()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+	defs, err := definitions(tx, uid)
+	if err != nil {
+		return nil, false, err
+	}
+	_, existed := defs[name]
+	var version int
+	if err := tx.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM definitions WHERE user_id = ? AND name = ?", uid, name); err != nil {
+		return nil, false, err
+	}
+	d := &Definition{Name: name, Params: req.Params, Body: req.Body, Version: version + 1, CreatedAt: NowMillis()}
+	defs[name] = d
+	if err := o.checkDefinitions(defs); err != nil {
+		return nil, false, &InvalidExpressionError{err}
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO definitions(user_id, name, version, params, body, created_at) VALUES(?, ?, ?, ?, ?, ?)",
+		uid, name, d.Version, strings.Join(d.Params, ","), d.Body, d.CreatedAt,
+	); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	slog.InfoContext(ctx, "definition saved", "name", name, "version", d.Version)
+	return d, !existed, nil
+}
+
+// DeleteDefinition removes a definition unless others use it. Its
+// versions stay for the expressions submitted with them.
+func (o *Orchestrator) DeleteDefinition(ctx context.Context, uid int, name string) error {
+	tx, err := o.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	defs, err := definitions(tx, uid)
+	if err != nil {
+		return err
+	}
+	d, ok := defs[name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(defs, name)
+	if err := o.checkDefinitions(defs); err != nil {
+		return fmt.Errorf("%w: %v", ErrDefinitionInUse, err)
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO definitions(user_id, name, version, params, body, created_at) VALUES(?, ?, ?, '', NULL, ?)",
+		uid, name, d.Version+1, NowMillis(),
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "definition deleted", "name", name)
+	return nil
+}
+
+// encodeDefinitions returns the definitions as stored with an
+// expression, nil if there are none.
+func encodeDefinitions(defs map[string]*Definition) (*string, error) {
+	if len(defs) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(defs)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// definitionsHandler — GET /api/v1/definitions
+func (o *Orchestrator) definitionsHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	defs, err := o.ListDefinitions(uid)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DefinitionList{Definitions: defs})
+}
+
+// definitionHandler — GET, PUT and DELETE /api/v1/definitions/{name}
+func (o *Orchestrator) definitionHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	name := r.PathValue("name")
+	switch r.Method {
+	case http.MethodGet:
+		version := 0
+		if v := r.URL.Query().Get("version"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				apiError(w, http.StatusBadRequest, CodeBadRequest, "version must be a positive integer")
+				return
+			}
+			version = n
+		}
+		d, err := o.GetDefinition(uid, name, version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	case http.MethodPut:
+		var req DefinitionReq
+		if !o.decodeJSON(w, r, &req) {
+			return
+		}
+		d, created, err := o.PutDefinition(r.Context(), uid, name, req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(d)
+	case http.MethodDelete:
+		if err := o.DeleteDefinition(r.Context(), uid, name); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// definitionVersionsHandler — GET /api/v1/definitions/{name}/versions
+func (o *Orchestrator) definitionVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	defs, err := o.DefinitionVersions(uid, r.PathValue("name"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DefinitionList{Definitions: defs})
+}
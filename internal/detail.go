@@ -0,0 +1,219 @@
+package application
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Node states in the detail view.
+const (
+	NodeWaiting = "waiting" // operands are not computed yet
+	NodeQueued  = "queued"  // task is waiting for an agent
+	NodeRunning = "running" // task was handed to an agent
+	NodeDone    = "done"
+	NodeSkipped = "skipped" // not taken by a conditional, never computed
+)
+
+// NodeDetail is one node of the expression tree in the detail view.
+// Literals are always done and carry only their value. A node assigned
+// to a variable names it and appears at every use of the variable.
+// Conditionals have no task; an "if" node has a Condition, and Left and
+// Right are its branches. Values are set the way Expression sets its
+// result: Value while it is real, ComplexValue in complex mode, in the
+// SI base units of Unit.
+type NodeDetail struct {
+	State        string      `json:"state"`
+	Value        *float64    `json:"value,omitempty"`
+	ComplexValue *string     `json:"complex_value,omitempty"`
+	Unit         string      `json:"unit,omitempty"`
+	Variable     string      `json:"variable,omitempty"`
+	Operator     string      `json:"operator,omitempty"`
+	TaskID       string      `json:"task_id,omitempty"`
+	AgentID      string      `json:"agent_id,omitempty"`
+	QueuedAt     *Millis     `json:"queued_at,omitempty"`
+	StartedAt    *Millis     `json:"started_at,omitempty"`
+	FinishedAt   *Millis     `json:"finished_at,omitempty"`
+	Condition    *NodeDetail `json:"condition,omitempty"`
+	Left         *NodeDetail `json:"left,omitempty"`
+	Right        *NodeDetail `json:"right,omitempty"`
+}
+
+// Timings compares the real duration of an expression with the best it
+// could take: the critical path is the longest chain of dependent tasks
+// counting only their run time, as if agents and queues were unlimited.
+type Timings struct {
+	WallClockMs    int64 `json:"wall_clock_ms"`
+	CriticalPathMs int64 `json:"critical_path_ms"`
+}
+
+type ExpressionDetail struct {
+	Expression Expression  `json:"expression"`
+	Tree       *NodeDetail `json:"tree"`
+	Timings    Timings     `json:"timings"`
+	// Definitions are the versions of the saved constants and formulas
+	// the expression was submitted with.
+	Definitions []Definition `json:"definitions,omitempty"`
+}
+
+// nodeDetail renders n; skipped is set under an operand a conditional
+// did not take. A node shared by several uses (a variable, a comparator
+// of median) has its operands rendered at its first use only, in seen;
+// later uses repeat its own fields, so that the tree stays as large as
+// the task graph.
+func nodeDetail(n *ASTNode, tasks map[int]*taskRow, seen map[*ASTNode]bool, skipped, complexMode bool) *NodeDetail {
+	if n == nil {
+		return nil
+	}
+	if n.IsLeaf {
+		d := &NodeDetail{State: NodeDone, Variable: n.Name, Unit: n.Dim.String()}
+		d.Value, d.ComplexValue = valueFields(n.value(), complexMode)
+		return d
+	}
+	d := &NodeDetail{
+		State:    NodeWaiting,
+		Unit:     n.Dim.String(),
+		Variable: n.Name,
+		Operator: n.Operator,
+	}
+	if skipped {
+		d.State = NodeSkipped
+	}
+	repeated := seen[n]
+	seen[n] = true
+	if n.Conditional() {
+		if !repeated {
+			d.Condition = nodeDetail(n.Cond, tasks, seen, skipped, complexMode)
+			left, right := skipped, skipped
+			if cond, ok := operand(n.Condition(), tasks); ok {
+				next := n.Decide(cond)
+				left = left || n.Operator == OpIf && next != n.Left
+				right = right || next != n.Right
+			}
+			d.Left = nodeDetail(n.Left, tasks, seen, left, complexMode)
+			d.Right = nodeDetail(n.Right, tasks, seen, right, complexMode)
+		}
+		if v, ok := operand(n, tasks); ok {
+			d.State = NodeDone
+			d.Value, d.ComplexValue = valueFields(v, complexMode)
+		}
+		return d
+	}
+	if !repeated {
+		d.Left = nodeDetail(n.Left, tasks, seen, skipped, complexMode)
+		d.Right = nodeDetail(n.Right, tasks, seen, skipped, complexMode)
+	}
+	t, ok := tasks[n.ID]
+	if !ok {
+		return d
+	}
+	switch {
+	case t.Done:
+		d.State = NodeDone
+		if v, ok := operand(n, tasks); ok {
+			d.Value, d.ComplexValue = valueFields(v, complexMode)
+		}
+	case t.InProgress:
+		d.State = NodeRunning
+	default:
+		d.State = NodeQueued
+	}
+	d.TaskID = t.ID
+	if t.AgentID != nil {
+		d.AgentID = *t.AgentID
+	}
+	d.QueuedAt, d.StartedAt, d.FinishedAt = t.QueuedAt, t.StartedAt, t.FinishedAt
+	return d
+}
+
+// criticalPath returns the longest run time of a chain of tasks ending
+// at n; a running task counts up to now. The operand a conditional
+// takes starts only after its condition is known. Shared nodes are
+// measured once, in memo.
+func criticalPath(n *ASTNode, tasks map[int]*taskRow, now Millis, memo map[*ASTNode]int64) int64 {
+	if n == nil || n.IsLeaf {
+		return 0
+	}
+	if c, ok := memo[n]; ok {
+		return c
+	}
+	path := func(n *ASTNode) int64 { return criticalPath(n, tasks, now, memo) }
+	var c int64
+	switch n.Operator {
+	case OpIf:
+		c = path(n.Cond) + max(path(n.Left), path(n.Right))
+	case OpAnd, OpOr:
+		c = path(n.Left) + path(n.Right)
+	default:
+		if t, ok := tasks[n.ID]; ok && t.StartedAt != nil {
+			end := now
+			if t.FinishedAt != nil {
+				end = *t.FinishedAt
+			}
+			c = int64(end - *t.StartedAt)
+		}
+		c += max(path(n.Left), path(n.Right))
+	}
+	memo[n] = c
+	return c
+}
+
+// ExpressionDetail loads an expression of uid together with its task tree.
+func (o *Orchestrator) ExpressionDetail(uid, id int) (*ExpressionDetail, error) {
+	var e struct {
+		Expression
+		Variables   *string `db:"variables"`
+		Definitions *string `db:"definitions"`
+	}
+	err := o.DB.Get(&e, `
+        SELECT id, expr, variables, definitions, status, complex, result, complex_result, unit, created_at, updated_at, finished_at
+          FROM expressions
+         WHERE user_id = ? AND id = ?`, uid, id)
+	if err != nil {
+		return nil, err
+	}
+	ast, err := parseStored(e.Expr, e.Variables, e.Definitions, e.Complex)
+	if err != nil {
+		return nil, err
+	}
+	numberNodes(ast)
+	tasks, err := o.exprTasks(o.DB, int64(id))
+	if err != nil {
+		return nil, err
+	}
+	d := &ExpressionDetail{Expression: e.Expression, Tree: nodeDetail(ast, tasks, map[*ASTNode]bool{}, false, e.Complex)}
+	if e.Definitions != nil {
+		var defs map[string]*Definition
+		if err := json.Unmarshal([]byte(*e.Definitions), &defs); err != nil {
+			return nil, err
+		}
+		d.Definitions = sortedDefinitions(defs)
+	}
+	now := NowMillis()
+	end := now
+	if e.FinishedAt != nil {
+		end = *e.FinishedAt
+	}
+	if e.CreatedAt > 0 {
+		d.Timings.WallClockMs = int64(end - e.CreatedAt)
+	}
+	d.Timings.CriticalPathMs = criticalPath(ast, tasks, now, map[*ASTNode]int64{})
+	return d, nil
+}
+
+// expressionDetailHandler — GET /api/v1/expressions/{id}/details
+func (o *Orchestrator) expressionDetailHandler(w http.ResponseWriter, r *http.Request, id int) {
+	uid := r.Context().Value("user_id").(int)
+	d, err := o.ExpressionDetail(uid, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		apiError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
@@ -0,0 +1,248 @@
+package application
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// Expression is a row of the expressions table as returned by the API.
+// Result is set while the value is real; an expression in complex mode
+// also has it as a+bi in ComplexResult. A result with a dimension is in
+// SI base units, which Unit names.
+type Expression struct {
+	ID            int      `db:"id" json:"id"`
+	Expr          string   `db:"expr" json:"expression"`
+	Status        string   `db:"status" json:"status"`
+	Complex       bool     `db:"complex" json:"complex,omitempty"`
+	Result        *float64 `db:"result" json:"result,omitempty"`
+	ComplexResult *string  `db:"complex_result" json:"complex_result,omitempty"`
+	Unit          *string  `db:"unit" json:"unit,omitempty"`
+	CreatedAt     Millis   `db:"created_at" json:"created_at"`
+	UpdatedAt     Millis   `db:"updated_at" json:"updated_at"`
+	FinishedAt    *Millis  `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+// ListQuery selects one page of a user's expressions.
+type ListQuery struct {
+	Limit         int
+	Cursor        string
+	Statuses      []string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Search        string
+	Sort          string
+}
+
+// sortColumns maps the accepted sort keys to their columns; every one of
+// them is an integer, which is what the cursor stores.
+var sortColumns = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+type cursor struct {
+	Sort string `json:"s"`
+	Key  int64  `json:"k"`
+	ID   int    `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil {
+		return c, errors.New("invalid cursor")
+	}
+	return c, nil
+}
+
+// ParseListQuery reads ListQuery from the query string of
+// GET /api/v1/expressions.
+func ParseListQuery(v url.Values) (ListQuery, error) {
+	q := ListQuery{
+		Limit:  defaultPageSize,
+		Cursor: v.Get("cursor"),
+		Search: v.Get("q"),
+		Sort:   v.Get("sort"),
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = n
+	}
+	if s := v.Get("status"); s != "" {
+		q.Statuses = strings.Split(s, ",")
+	}
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"created_after", &q.CreatedAfter}, {"created_before", &q.CreatedBefore}} {
+		s := v.Get(t.name)
+		if s == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, fmt.Errorf("%s must be an RFC 3339 time", t.name)
+		}
+		*t.dst = ts
+	}
+	return q, q.validate()
+}
+
+// validate checks the fields that do not depend on how the query was
+// transported.
+func (q ListQuery) validate() error {
+	if q.Limit < 1 || q.Limit > maxPageSize {
+		return fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	if _, _, err := q.order(); err != nil {
+		return err
+	}
+	if q.Cursor != "" {
+		if _, err := q.cursor(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q ListQuery) sortKey() string {
+	if q.Sort == "" {
+		return "-created_at"
+	}
+	return q.Sort
+}
+
+// order returns the sort column and direction.
+func (q ListQuery) order() (string, bool, error) {
+	sort := q.sortKey()
+	col, ok := sortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return "", false, fmt.Errorf("unknown sort key %q", sort)
+	}
+	return col, strings.HasPrefix(sort, "-"), nil
+}
+
+func (q ListQuery) cursor() (cursor, error) {
+	c, err := decodeCursor(q.Cursor)
+	if err == nil && c.Sort != q.sortKey() {
+		err = errors.New("cursor does not match sort")
+	}
+	return c, err
+}
+
+// ListExpressions returns a page of uid's expressions and the cursor of
+// the next page, which is empty on the last one.
+func (o *Orchestrator) ListExpressions(uid int, q ListQuery) ([]Expression, string, error) {
+	col, desc, err := q.order()
+	if err != nil {
+		return nil, "", err
+	}
+	limit := q.Limit
+	if limit < 1 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	where := []string{"user_id = ?"}
+	args := []interface{}{uid}
+	if len(q.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(",?", len(q.Statuses)-1)+")")
+		for _, s := range q.Statuses {
+			args = append(args, s)
+		}
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.CreatedAfter.UnixMilli())
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, q.CreatedBefore.UnixMilli())
+	}
+	if q.Search != "" {
+		esc := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.Search)
+		where = append(where, `expr LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+esc+"%")
+	}
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+	if q.Cursor != "" {
+		c, err := q.cursor()
+		if err != nil {
+			return nil, "", err
+		}
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", col, cmp))
+		args = append(args, c.Key, c.Key, c.ID)
+	}
+
+	query := fmt.Sprintf(`
+        SELECT id, expr, status, complex, result, complex_result, unit, created_at, updated_at, finished_at
+          FROM expressions
+         WHERE %s
+         ORDER BY %s %s, id %s
+         LIMIT ?`, strings.Join(where, " AND "), col, dir, dir)
+	args = append(args, limit+1)
+
+	exprs := []Expression{}
+	if err := o.DB.Select(&exprs, query, args...); err != nil {
+		return nil, "", err
+	}
+	if len(exprs) <= limit {
+		return exprs, "", nil
+	}
+	exprs = exprs[:limit]
+	last := exprs[limit-1]
+	key := int64(last.ID)
+	switch col {
+	case "created_at":
+		key = int64(last.CreatedAt)
+	case "updated_at":
+		key = int64(last.UpdatedAt)
+	}
+	return exprs, encodeCursor(cursor{Sort: q.sortKey(), Key: key, ID: last.ID}), nil
+}
+
+// expressionsHandler — GET /api/v1/expressions
+func (o *Orchestrator) expressionsHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	q, err := ParseListQuery(r.URL.Query())
+	if err != nil {
+		apiError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	exprs, next, err := o.ListExpressions(uid, q)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if exprs == nil {
+		exprs = []Expression{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ExpressionList{Expressions: exprs, NextCursor: next})
+}
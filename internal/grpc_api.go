@@ -0,0 +1,244 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/lollmark/digital_calc/proto/calc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// watchPollEvery is how often WatchExpression looks for changes.
+const watchPollEvery = 200 * time.Millisecond
+
+// publicMethods of CalcAPI that work without a token.
+var publicMethods = map[string]bool{
+	calc.CalcAPI_Register_FullMethodName: true,
+	calc.CalcAPI_Login_FullMethodName:    true,
+}
+
+// APIServer serves the client API over gRPC on top of the same
+// operations as the HTTP handlers.
+type APIServer struct {
+	calc.UnimplementedCalcAPIServer
+	o *Orchestrator
+}
+
+func NewAPIServer(o *Orchestrator) *APIServer {
+	return &APIServer{o: o}
+}
+
+// needsAuth reports whether a gRPC method requires a token. The agent
+// service Calc stays unauthenticated.
+func needsAuth(method string) bool {
+	return strings.HasPrefix(method, "/"+calc.CalcAPI_ServiceDesc.ServiceName+"/") && !publicMethods[method]
+}
+
+// authenticate reads "authorization: Bearer <token>" from the incoming
+// metadata and stores the user id in the context like AuthMiddleware.
+func (o *Orchestrator) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	vals := md.Get("authorization")
+	if len(vals) == 0 || vals[0] == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing token")
+	}
+	uid, err := o.checkToken(strings.TrimPrefix(vals[0], "Bearer "))
+	if errors.Is(err, ErrInvalidToken) {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "token check failed", "error", err)
+		return nil, status.Error(codes.Internal, "server error")
+	}
+	return withUser(ctx, uid), nil
+}
+
+// AuthUnaryInterceptor requires a token for the CalcAPI methods.
+func (o *Orchestrator) AuthUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !needsAuth(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	ctx, err := o.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// serverStream replaces the context of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// AuthStreamInterceptor is AuthUnaryInterceptor for streaming methods.
+func (o *Orchestrator) AuthStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !needsAuth(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	ctx, err := o.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &serverStream{ss, ctx})
+}
+
+// grpcError maps the errors of the shared operations to gRPC statuses,
+// the way writeError does for HTTP.
+func grpcError(ctx context.Context, err error) error {
+	var (
+		input *InvalidInputError
+		expr  *InvalidExpressionError
+		limit *LimitError
+	)
+	switch {
+	case errors.As(err, &input), errors.As(err, &expr):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &limit):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrUserExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrInvalidCreds):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrNotPending):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		slog.ErrorContext(ctx, "request failed", "error", err)
+		return status.Error(codes.Internal, "server error")
+	}
+}
+
+func toProtoExpression(e *Expression) *calc.Expression {
+	out := &calc.Expression{
+		Id:            int64(e.ID),
+		Expression:    e.Expr,
+		Status:        e.Status,
+		Result:        e.Result,
+		CreatedAt:     timestamppb.New(e.CreatedAt.Time()),
+		UpdatedAt:     timestamppb.New(e.UpdatedAt.Time()),
+		Complex:       e.Complex,
+		ComplexResult: e.ComplexResult,
+		Unit:          e.Unit,
+	}
+	if e.FinishedAt != nil {
+		out.FinishedAt = timestamppb.New(e.FinishedAt.Time())
+	}
+	return out
+}
+
+func (s *APIServer) Register(ctx context.Context, in *calc.Credentials) (*calc.Empty, error) {
+	if err := s.o.Register(in.Login, in.Password); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &calc.Empty{}, nil
+}
+
+func (s *APIServer) Login(ctx context.Context, in *calc.Credentials) (*calc.LoginResp, error) {
+	tok, err := s.o.Login(in.Login, in.Password)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &calc.LoginResp{Token: tok}, nil
+}
+
+func (s *APIServer) Calculate(ctx context.Context, in *calc.CalculateReq) (*calc.CalculateResp, error) {
+	uid := ctx.Value("user_id").(int)
+	id, err := s.o.Calculate(ctx, uid, in.Expression, in.Variables, in.CallbackUrl, in.Complex)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &calc.CalculateResp{Id: id}, nil
+}
+
+func (s *APIServer) GetExpression(ctx context.Context, in *calc.ExpressionReq) (*calc.Expression, error) {
+	uid := ctx.Value("user_id").(int)
+	e, err := s.o.GetExpression(uid, in.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return toProtoExpression(e), nil
+}
+
+func (s *APIServer) ListExpressions(ctx context.Context, in *calc.ListExpressionsReq) (*calc.ListExpressionsResp, error) {
+	uid := ctx.Value("user_id").(int)
+	q := ListQuery{
+		Limit:    int(in.Limit),
+		Cursor:   in.Cursor,
+		Statuses: in.Status,
+		Search:   in.Q,
+		Sort:     in.Sort,
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultPageSize
+	}
+	if in.CreatedAfter != nil {
+		q.CreatedAfter = in.CreatedAfter.AsTime()
+	}
+	if in.CreatedBefore != nil {
+		q.CreatedBefore = in.CreatedBefore.AsTime()
+	}
+	if err := q.validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	list, next, err := s.o.ListExpressions(uid, q)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	resp := &calc.ListExpressionsResp{NextCursor: next}
+	for i := range list {
+		resp.Expressions = append(resp.Expressions, toProtoExpression(&list[i]))
+	}
+	return resp, nil
+}
+
+func (s *APIServer) CancelExpression(ctx context.Context, in *calc.ExpressionReq) (*calc.Expression, error) {
+	uid := ctx.Value("user_id").(int)
+	e, err := s.o.CancelExpression(ctx, uid, in.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return toProtoExpression(e), nil
+}
+
+// WatchExpression sends the expression right away and then every time
+// it is updated, until it is no longer pending or the client goes away.
+func (s *APIServer) WatchExpression(in *calc.ExpressionReq, stream calc.CalcAPI_WatchExpressionServer) error {
+	ctx := stream.Context()
+	uid := ctx.Value("user_id").(int)
+	t := time.NewTicker(watchPollEvery)
+	defer t.Stop()
+	var last *Expression
+	for {
+		e, err := s.o.GetExpression(uid, in.Id)
+		if err != nil {
+			return grpcError(ctx, err)
+		}
+		// updated_at alone can repeat when the expression finishes
+		// within the millisecond it was created
+		if last == nil || e.UpdatedAt != last.UpdatedAt || e.Status != last.Status {
+			if err := stream.Send(toProtoExpression(e)); err != nil {
+				return err
+			}
+			last = e
+		}
+		if e.Status != "pending" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// readyCheckTimeout bounds the db ping of /readyz; a db held by a long
+// write counts as not ready.
+const readyCheckTimeout = 2 * time.Second
+
+// HealthzHandler — GET /healthz. The process is up and serving HTTP.
+func (o *Orchestrator) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthResp{Status: "ok"})
+}
+
+// ReadyzHandler — GET /readyz. The orchestrator can take work: the db
+// answers and the gRPC server agents talk to is serving. It turns 503
+// as soon as a shutdown starts.
+func (o *Orchestrator) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := HealthChecks{DB: "ok", GRPC: "ok"}
+	ready := true
+	ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+	defer cancel()
+	if err := o.DB.PingContext(ctx); err != nil {
+		checks.DB = err.Error()
+		ready = false
+	}
+	if !o.serving.Load() {
+		checks.GRPC = "not serving"
+		ready = false
+	}
+	resp := HealthResp{Status: "ready", Checks: &checks}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		resp.Status = "not_ready"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,148 @@
+package application
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	maxIdempotencyKeyLen = 255
+	// a placeholder this old belongs to a request that never finished,
+	// e.g. because the orchestrator was restarted
+	abandonedKeyAfter = Millis(60 * 1000)
+)
+
+// responseRecorder passes a response through while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	rr.status = code
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+type storedResponse struct {
+	RequestHash string `db:"request_hash"`
+	StatusCode  int    `db:"status_code"`
+	ContentType string `db:"content_type"`
+	Body        []byte `db:"body"`
+	CreatedAt   Millis `db:"created_at"`
+}
+
+// Idempotent makes a POST handler safe to retry: a request carrying an
+// Idempotency-Key the user already sent within Config.IdempotencyTTL gets
+// the stored response instead of being executed again. Only successful
+// responses are stored, so a retry after an error runs for real.
+// Must be wrapped by AuthMiddleware.
+func (o *Orchestrator) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			apiError(w, http.StatusBadRequest, CodeBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		uid := r.Context().Value("user_id").(int)
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, o.Config.MaxBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apiError(w, http.StatusRequestEntityTooLarge, CodeBodyTooLarge,
+				fmt.Sprintf("body is larger than %d bytes", tooLarge.Limit))
+			return
+		}
+		if err != nil {
+			apiError(w, http.StatusBadRequest, CodeBadRequest, "cannot read body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		now := NowMillis()
+		expired := now - Millis(o.Config.IdempotencyTTL.Milliseconds())
+		_, err = o.DB.Exec(
+			"DELETE FROM idempotency_keys WHERE created_at < ? OR (status_code = 0 AND created_at < ?)",
+			expired, now-abandonedKeyAfter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to purge idempotency keys", "error", err)
+		}
+		// the placeholder row (status 0) claims the key while the request runs
+		res, err := o.DB.Exec(
+			`INSERT OR IGNORE INTO idempotency_keys(user_id, key, request_hash, status_code, created_at)
+             VALUES (?, ?, ?, 0, ?)`, uid, key, hash, now)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			o.replay(w, r, uid, key, hash)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 200 && rec.status < 300 {
+			_, err = o.DB.Exec(
+				`UPDATE idempotency_keys SET status_code = ?, content_type = ?, body = ?
+                 WHERE user_id = ? AND key = ?`,
+				rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes(), uid, key)
+		} else {
+			_, err = o.DB.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?", uid, key)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to store idempotent response", "error", err)
+		}
+	})
+}
+
+func (o *Orchestrator) replay(w http.ResponseWriter, r *http.Request, uid int, key, hash string) {
+	var s storedResponse
+	err := o.DB.Get(&s, `
+        SELECT request_hash, status_code, COALESCE(content_type, '') AS content_type,
+               COALESCE(body, x'') AS body, created_at
+          FROM idempotency_keys
+         WHERE user_id = ? AND key = ?`, uid, key)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// the first request failed and released the key just now
+		apiError(w, http.StatusConflict, CodeIdempotencyConflict, "request with this Idempotency-Key failed, retry")
+		return
+	case err != nil:
+		internalError(w, r, err)
+		return
+	case s.RequestHash != hash:
+		apiError(w, http.StatusUnprocessableEntity, CodeIdempotencyMismatch, "Idempotency-Key was used with a different request")
+		return
+	case s.StatusCode == 0:
+		w.Header().Set("Retry-After", "1")
+		apiError(w, http.StatusConflict, CodeIdempotencyConflict, "request with this Idempotency-Key is in progress")
+		return
+	}
+	if s.ContentType != "" {
+		w.Header().Set("Content-Type", s.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(s.StatusCode)
+	w.Write(s.Body)
+}
@@ -0,0 +1,193 @@
+package application
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp // operators and punctuation
+)
+
+// token is a lexeme of an expression; pos is its byte offset in the
+// input, which error messages report.
+type token struct {
+	kind  tokenKind
+	text  string
+	pos   int
+	value float64 // of a tokNumber
+	imag  bool    // a tokNumber with the suffix i, like 4i
+}
+
+// operators are the one-character operators and punctuation;
+// operators2 the two-character ones, which take precedence.
+const operators = "+-*/^()[]=;,<>!?:"
+
+var operators2 = []string{"<=", ">=", "==", "!=", "&&", "||"}
+
+func isDigit(ch byte) bool  { return ch >= '0' && ch <= '9' }
+func isLetter(ch byte) bool { return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' }
+
+func isSpace(ch byte) bool { return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' }
+
+// tokenize splits an expression into tokens, skipping whitespace. The
+// last token is always tokEOF at the end of the input.
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		ch := input[i]
+		switch {
+		case isSpace(ch):
+			i++
+		case isDigit(ch) || ch == '.' && i+1 < len(input) && isDigit(input[i+1]):
+			t, err := scanNumber(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, t)
+			i += len(t.text)
+		case isLetter(ch):
+			start := i
+			for i < len(input) && (isLetter(input[i]) || isDigit(input[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: input[start:i], pos: start})
+		case i+1 < len(input) && slices.Contains(operators2, input[i:i+2]):
+			tokens = append(tokens, token{kind: tokOp, text: input[i : i+2], pos: i})
+			i += 2
+		case strings.IndexByte(operators, ch) >= 0:
+			tokens = append(tokens, token{kind: tokOp, text: input[i : i+1], pos: i})
+			i++
+		default:
+			r := []rune(input[i:])[0]
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(input)}), nil
+}
+
+// scanNumber reads a number literal at start: a decimal such as 12,
+// 1.5, .5 or 2.5e-3, a hexadecimal 0xFF or a binary 0b1010. Digits may
+// be separated by single underscores, as in 1_000_000. A literal that
+// runs into letters, digits or dots it cannot take, like 1.2.3 or 0b12,
+// is an error at the first such character. A decimal followed by i, as
+// in 4i or 2.5e3i, is imaginary; whether that is allowed is up to the
+// parser.
+func scanNumber(input string, start int) (token, error) {
+	i := start
+	// digits reads a run of digits, single underscores allowed between
+	// them, and returns how many digits there were.
+	digits := func(valid func(byte) bool) (int, error) {
+		n := 0
+		for i < len(input) {
+			switch {
+			case valid(input[i]):
+				n++
+			case input[i] == '_' && n > 0 && i+1 < len(input) && valid(input[i+1]):
+			case input[i] == '_':
+				return 0, fmt.Errorf("'_' must separate digits at position %d", i)
+			default:
+				return n, nil
+			}
+			i++
+		}
+		return n, nil
+	}
+
+	base := 10
+	if input[i] == '0' && i+1 < len(input) {
+		switch input[i+1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		}
+	}
+	if base != 10 {
+		i += 2
+		valid := isHexDigit
+		name := "hexadecimal"
+		if base == 2 {
+			valid = func(ch byte) bool { return ch == '0' || ch == '1' }
+			name = "binary"
+		}
+		n, err := digits(valid)
+		if err != nil {
+			return token{}, err
+		}
+		if n == 0 {
+			return token{}, fmt.Errorf("expected %s digit at position %d", name, i)
+		}
+		if err := numberEnd(input, i); err != nil {
+			return token{}, err
+		}
+		text := input[start:i]
+		v, _ := new(big.Int).SetString(strings.ReplaceAll(text[2:], "_", ""), base)
+		f, _ := new(big.Float).SetInt(v).Float64()
+		if math.IsInf(f, 0) {
+			return token{}, fmt.Errorf("number %s at position %d is out of range", text, start)
+		}
+		return token{kind: tokNumber, text: text, pos: start, value: f}, nil
+	}
+
+	if _, err := digits(isDigit); err != nil {
+		return token{}, err
+	}
+	if i < len(input) && input[i] == '.' {
+		i++
+		if i < len(input) && input[i] == '_' {
+			return token{}, fmt.Errorf("'_' must separate digits at position %d", i)
+		}
+		if _, err := digits(isDigit); err != nil {
+			return token{}, err
+		}
+	}
+	if i < len(input) && (input[i] == 'e' || input[i] == 'E') {
+		i++
+		if i < len(input) && (input[i] == '+' || input[i] == '-') {
+			i++
+		}
+		n, err := digits(isDigit)
+		if err != nil {
+			return token{}, err
+		}
+		if n == 0 {
+			return token{}, fmt.Errorf("expected exponent digit at position %d", i)
+		}
+	}
+	end := i
+	if i < len(input) && input[i] == 'i' {
+		i++
+	}
+	if err := numberEnd(input, i); err != nil {
+		return token{}, err
+	}
+	text := input[start:i]
+	f, err := strconv.ParseFloat(strings.ReplaceAll(input[start:end], "_", ""), 64)
+	if err != nil {
+		return token{}, fmt.Errorf("number %s at position %d is out of range", text, start)
+	}
+	return token{kind: tokNumber, text: text, pos: start, value: f, imag: end < i}, nil
+}
+
+// numberEnd rejects a number literal followed directly by a character
+// that cannot start the next token.
+func numberEnd(input string, i int) error {
+	if i < len(input) && (isLetter(input[i]) || isDigit(input[i]) || input[i] == '.') {
+		return fmt.Errorf("unexpected %q in number at position %d", input[i], i)
+	}
+	return nil
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F'
+}
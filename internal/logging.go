@@ -0,0 +1,229 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lollmark/digital_calc/proto/calc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader carries the request id in HTTP requests and responses.
+// Over gRPC the same id travels in the "x-request-id" metadata key.
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "x-request-id"
+
+// SetupLogging installs the default slog logger. LOG_LEVEL is one of
+// debug, info (default), warn or error; LOG_FORMAT is text (default) or
+// json.
+func SetupLogging(w io.Writer) error {
+	var level slog.Level
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		if err := level.UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q", s)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	return nil
+}
+
+type logAttrsKey struct{}
+
+// withLog returns a context whose log lines carry the given key-value
+// pairs in addition to those already in ctx.
+func withLog(ctx context.Context, args ...any) context.Context {
+	prev, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	attrs := make([]slog.Attr, len(prev), len(prev)+r.NumAttrs())
+	copy(attrs, prev)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, logAttrsKey{}, attrs)
+}
+
+type logUserKey struct{}
+
+// logUser is put in the context by logRequests and grpcLogContext and
+// filled in by authentication, which runs inside them on a context of
+// its own, so that the line they log once the call is served names the
+// user.
+type logUser struct {
+	id int
+	ok bool
+}
+
+// withLogUser returns a context carrying an empty logUser.
+func withLogUser(ctx context.Context) (context.Context, *logUser) {
+	u := &logUser{}
+	return context.WithValue(ctx, logUserKey{}, u), u
+}
+
+// attrs returns the user_id attribute if a user was authenticated; u
+// may be nil.
+func (u *logUser) attrs() []any {
+	if u == nil || !u.ok {
+		return nil
+	}
+	return []any{"user_id", u.id}
+}
+
+// withUser returns a context of an authenticated request of uid: the
+// id is stored under "user_id" and tags its log lines, including the
+// access line of the request.
+func withUser(ctx context.Context, uid int) context.Context {
+	if u, ok := ctx.Value(logUserKey{}).(*logUser); ok {
+		u.id, u.ok = uid, true
+	}
+	return withLog(context.WithValue(ctx, "user_id", uid), "user_id", uid)
+}
+
+// contextHandler adds the attributes stored by withLog to every record
+// logged with a context.
+type contextHandler struct{ slog.Handler }
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID keeps a client supplied id if it is short and printable, so
+// that ids can be followed across services, and makes a new one otherwise.
+func requestID(id string) string {
+	if id == "" || len(id) > 64 {
+		return newRequestID()
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return newRequestID()
+		}
+	}
+	return id
+}
+
+// quietPaths are polled by probes and scrapers every few seconds and are
+// logged at debug level only.
+var quietPaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// logRequests assigns every HTTP request an id, returns it in
+// X-Request-ID and logs the request once it is served.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r.Header.Get(RequestIDHeader))
+		w.Header().Set(RequestIDHeader, id)
+		ctx, user := withLogUser(withLog(r.Context(), "request_id", id))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+		level := slog.LevelInfo
+		switch {
+		case quietPaths[r.URL.Path]:
+			level = slog.LevelDebug
+		case sw.status >= 500:
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "http request", append([]any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		}, user.attrs()...)...)
+	})
+}
+
+// grpcLogContext gives a gRPC call a request id, sends it back in the
+// response header and tags the log lines of agent calls with the agent.
+func grpcLogContext(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(requestIDKey); len(v) > 0 {
+			id = v[0]
+		}
+	}
+	id = requestID(id)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+	ctx = withLog(ctx, "request_id", id)
+	if a := agentID(ctx); a != "" {
+		ctx = withLog(ctx, "agent_id", a)
+	}
+	ctx, _ = withLogUser(ctx)
+	return ctx
+}
+
+// LoggingUnaryInterceptor assigns request ids to unary gRPC calls and
+// logs them. Agents poll all the time, so successful agent calls are
+// logged at debug level.
+func LoggingUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx = grpcLogContext(ctx)
+	resp, err := handler(ctx, req)
+	logGRPC(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// LoggingStreamInterceptor is LoggingUnaryInterceptor for streams.
+func LoggingStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx := grpcLogContext(ss.Context())
+	err := handler(srv, &serverStream{ss, ctx})
+	logGRPC(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// logGRPC logs a served call; ctx is the one made by grpcLogContext.
+func logGRPC(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch {
+	case code == codes.Internal || code == codes.Unknown:
+		level = slog.LevelError
+	case strings.HasPrefix(method, "/"+calc.Calc_ServiceDesc.ServiceName+"/"):
+		level = slog.LevelDebug
+	}
+	user, _ := ctx.Value(logUserKey{}).(*logUser)
+	slog.Log(ctx, level, "grpc call", append([]any{
+		"method", method,
+		"code", code.String(),
+		"duration_ms", time.Since(start).Milliseconds(),
+	}, user.attrs()...)...)
+}
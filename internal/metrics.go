@@ -0,0 +1,184 @@
+package application
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const metricsNamespace = "calc"
+
+// Event metrics are process-wide; the state of the queue is read from
+// the database on every scrape by dbCollector.
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	grpcCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "grpc_server_handled_total",
+		Help:      "gRPC calls by method and status code.",
+	}, []string{"method", "code"})
+	grpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "grpc_server_handling_seconds",
+		Help:      "gRPC call latency by method; streams are timed until they end.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "task_duration_seconds",
+		Help:      "Time from handing a task to an agent to receiving its result, by operation.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"operation"})
+	taskQueueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "task_queue_wait_seconds",
+		Help:      "Time a task waited in the queue before an agent took it, by operation.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"operation"})
+)
+
+var (
+	queuedDesc = prometheus.NewDesc(metricsNamespace+"_tasks_queued",
+		"Tasks waiting for an agent, by operation.", []string{"operation"}, nil)
+	inProgressDesc = prometheus.NewDesc(metricsNamespace+"_tasks_in_progress",
+		"Tasks handed to an agent and not finished yet.", nil, nil)
+	expressionsDesc = prometheus.NewDesc(metricsNamespace+"_expressions",
+		"Stored expressions by status.", []string{"status"}, nil)
+)
+
+// dbCollector reports the queue and expression counts at scrape time.
+type dbCollector struct{ o *Orchestrator }
+
+func (c dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queuedDesc
+	ch <- inProgressDesc
+	ch <- expressionsDesc
+}
+
+func (c dbCollector) Collect(ch chan<- prometheus.Metric) {
+	var queued []struct {
+		Operation string `db:"operation"`
+		N         int    `db:"n"`
+	}
+	err := c.o.DB.Select(&queued, `
+        SELECT operation, COUNT(*) AS n
+          FROM tasks
+         WHERE in_progress = 0 AND done = 0
+         GROUP BY operation`)
+	if err != nil {
+		slog.Error("failed to collect metrics", "error", err)
+		return
+	}
+	for _, q := range queued {
+		ch <- prometheus.MustNewConstMetric(queuedDesc, prometheus.GaugeValue, float64(q.N), q.Operation)
+	}
+
+	var inProgress int
+	if err := c.o.DB.Get(&inProgress, "SELECT COUNT(*) FROM tasks WHERE in_progress = 1"); err != nil {
+		slog.Error("failed to collect metrics", "error", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(inProgressDesc, prometheus.GaugeValue, float64(inProgress))
+
+	var statuses []struct {
+		Status string `db:"status"`
+		N      int    `db:"n"`
+	}
+	if err := c.o.DB.Select(&statuses, "SELECT status, COUNT(*) AS n FROM expressions GROUP BY status"); err != nil {
+		slog.Error("failed to collect metrics", "error", err)
+		return
+	}
+	for _, s := range statuses {
+		ch <- prometheus.MustNewConstMetric(expressionsDesc, prometheus.GaugeValue, float64(s.N), s.Status)
+	}
+}
+
+// MetricsHandler serves the orchestrator metrics in the Prometheus
+// text format.
+func (o *Orchestrator) MetricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, grpcCalls, grpcDuration, taskDuration, taskQueueWait,
+		dbCollector{o},
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// instrumentHTTP counts and times the requests of one route. The route
+// is the registered pattern, not the path, to keep label values bounded.
+func instrumentHTTP(route string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"route": route}
+	return promhttp.InstrumentHandlerDuration(httpDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels), h))
+}
+
+// MetricsUnaryInterceptor counts and times unary gRPC calls.
+func MetricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeGRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+// MetricsStreamInterceptor counts and times streaming gRPC calls.
+func MetricsStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeGRPC(info.FullMethod, start, err)
+	return err
+}
+
+func observeGRPC(method string, start time.Time, err error) {
+	grpcCalls.WithLabelValues(method, status.Code(err).String()).Inc()
+	grpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// Agent metrics, served by Agent.Run when AGENT_METRICS_ADDR is set.
+var (
+	agentBusy = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "agent_worker_busy_seconds_total",
+		Help:      "Time each worker spent computing tasks.",
+	}, []string{"worker"})
+	agentTasks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "agent_tasks_completed_total",
+		Help:      "Tasks computed and reported by each worker.",
+	}, []string{"worker"})
+	agentErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "agent_errors_total",
+		Help:      "Worker errors by stage: get_task, compute or post_result.",
+	}, []string{"worker", "stage"})
+)
+
+// AgentMetricsHandler serves the agent metrics in the Prometheus text
+// format.
+func AgentMetricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		agentBusy, agentTasks, agentErrors,
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,269 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// apiParam is a path, query or header parameter of an operation.
+type apiParam struct {
+	Name, In, Type, Description string
+}
+
+// apiOperation describes one route of Routes. Request and the values of
+// Responses are zero values of the body types (nil for no body); error
+// statuses map to nil and are documented as ErrorBody.
+type apiOperation struct {
+	Method, Path, Summary string
+	Auth                  bool
+	Params                []apiParam
+	Request               interface{}
+	Responses             map[int]interface{}
+}
+
+var (
+	idParam             = apiParam{"id", "path", "integer", ""}
+	nameParam           = apiParam{"name", "path", "string", ""}
+	idempotencyKeyParam = apiParam{"Idempotency-Key", "header", "string", "makes the request safe to retry, see README"}
+)
+
+var apiOperations = []apiOperation{
+	{Method: "POST", Path: "/api/v1/register", Summary: "Create a user",
+		Request:   Credentials{},
+		Responses: map[int]interface{}{200: nil, 400: nil, 409: nil}},
+	{Method: "POST", Path: "/api/v1/login", Summary: "Get a token",
+		Request:   Credentials{},
+		Responses: map[int]interface{}{200: LoginResp{}, 400: nil, 401: nil, 429: nil}},
+	{Method: "POST", Path: "/api/v1/password", Summary: "Change the password", Auth: true,
+		Request:   ChangePasswordReq{},
+		Responses: map[int]interface{}{200: nil, 400: nil, 401: nil}},
+	{Method: "DELETE", Path: "/api/v1/account", Summary: "Delete the user and everything they submitted", Auth: true,
+		Request:   DeleteAccountReq{},
+		Responses: map[int]interface{}{204: nil, 400: nil, 401: nil}},
+	{Method: "GET", Path: "/api/v1/me/usage", Summary: "Quota usage", Auth: true,
+		Responses: map[int]interface{}{200: UsageResp{}}},
+	{Method: "POST", Path: "/api/v1/calculate", Summary: "Submit an expression", Auth: true,
+		Params:    []apiParam{idempotencyKeyParam},
+		Request:   CalculateReq{},
+		Responses: map[int]interface{}{201: CalculateResp{}, 400: nil, 409: nil, 422: nil, 429: nil}},
+	{Method: "POST", Path: "/api/v1/calculate/batch", Summary: "Submit several expressions", Auth: true,
+		Params:    []apiParam{idempotencyKeyParam},
+		Request:   BatchReq{},
+		Responses: map[int]interface{}{201: BatchResp{}, 400: nil, 409: nil, 422: nil, 429: nil}},
+	{Method: "GET", Path: "/api/v1/batches/{id}", Summary: "Batch progress", Auth: true,
+		Params:    []apiParam{idParam},
+		Responses: map[int]interface{}{200: Batch{}, 404: nil}},
+	{Method: "GET", Path: "/api/v1/expressions", Summary: "List expressions", Auth: true,
+		Params: []apiParam{
+			{"limit", "query", "integer", "page size, 1-200"},
+			{"cursor", "query", "string", "next_cursor of the previous page"},
+			{"status", "query", "string", "comma-separated statuses"},
+			{"created_after", "query", "string", "RFC 3339 time"},
+			{"created_before", "query", "string", "RFC 3339 time"},
+			{"q", "query", "string", "substring of the expression"},
+			{"sort", "query", "string", "id, created_at or updated_at, - for descending"},
+		},
+		Responses: map[int]interface{}{200: ExpressionList{}, 400: nil}},
+	{Method: "GET", Path: "/api/v1/expressions/{id}", Summary: "Expression status", Auth: true,
+		Params:    []apiParam{idParam},
+		Responses: map[int]interface{}{200: ExpressionStatusResp{}, 404: nil}},
+	{Method: "POST", Path: "/api/v1/expressions/{id}/cancel", Summary: "Cancel a pending expression", Auth: true,
+		Params:    []apiParam{idParam},
+		Responses: map[int]interface{}{200: Expression{}, 404: nil, 409: nil}},
+	{Method: "GET", Path: "/api/v1/expressions/{id}/details", Summary: "Expression tree with task timings", Auth: true,
+		Params:    []apiParam{idParam},
+		Responses: map[int]interface{}{200: ExpressionDetail{}, 404: nil}},
+	{Method: "GET", Path: "/api/v1/definitions", Summary: "Current saved constants and formulas", Auth: true,
+		Responses: map[int]interface{}{200: DefinitionList{}}},
+	{Method: "GET", Path: "/api/v1/definitions/{name}", Summary: "A definition", Auth: true,
+		Params:    []apiParam{nameParam, {"version", "query", "integer", "an older version, the current one by default"}},
+		Responses: map[int]interface{}{200: Definition{}, 400: nil, 404: nil}},
+	{Method: "PUT", Path: "/api/v1/definitions/{name}", Summary: "Create a definition or save its new version", Auth: true,
+		Params:    []apiParam{nameParam},
+		Request:   DefinitionReq{},
+		Responses: map[int]interface{}{200: Definition{}, 201: Definition{}, 422: nil}},
+	{Method: "DELETE", Path: "/api/v1/definitions/{name}", Summary: "Delete a definition no other one uses", Auth: true,
+		Params:    []apiParam{nameParam},
+		Responses: map[int]interface{}{204: nil, 404: nil, 409: nil}},
+	{Method: "GET", Path: "/api/v1/definitions/{name}/versions", Summary: "Every version of a definition", Auth: true,
+		Params:    []apiParam{nameParam},
+		Responses: map[int]interface{}{200: DefinitionList{}, 404: nil}},
+	{Method: "GET", Path: "/api/v1/openapi.json", Summary: "This document",
+		Responses: map[int]interface{}{200: nil}},
+	{Method: "GET", Path: "/healthz", Summary: "Liveness probe",
+		Responses: map[int]interface{}{200: HealthResp{}}},
+	{Method: "GET", Path: "/readyz", Summary: "Readiness probe: the db answers and gRPC is serving",
+		Responses: map[int]interface{}{200: HealthResp{}, 503: HealthResp{}}},
+}
+
+var millisType = reflect.TypeOf(Millis(0))
+
+// schemaBuilder turns Go types into OpenAPI schemas following
+// encoding/json rules; named structs go to components.
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	if t == millisType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := b.components[t.Name()]; ok {
+			return ref
+		}
+		b.components[t.Name()] = nil // placeholder for recursive types
+		b.components[t.Name()] = b.object(t)
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := b.schema(f.Type)
+		omitempty := strings.Contains(opts, "omitempty")
+		if f.Type.Kind() == reflect.Ptr && !omitempty {
+			s["nullable"] = true
+		}
+		props[name] = s
+		if !omitempty {
+			required = append(required, name)
+		}
+	}
+	obj := map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// OpenAPISpec builds the OpenAPI 3 document of the HTTP API.
+func OpenAPISpec() map[string]interface{} {
+	b := &schemaBuilder{components: map[string]interface{}{}}
+	errorBody := jsonContent(b.schema(reflect.TypeOf(ErrorBody{})))
+	paths := map[string]interface{}{}
+	for _, op := range apiOperations {
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+		o := map[string]interface{}{"summary": op.Summary}
+		if op.Auth {
+			o["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+		}
+		if len(op.Params) > 0 {
+			var params []interface{}
+			for _, p := range op.Params {
+				param := map[string]interface{}{
+					"name":     p.Name,
+					"in":       p.In,
+					"required": p.In == "path",
+					"schema":   map[string]interface{}{"type": p.Type},
+				}
+				if p.Description != "" {
+					param["description"] = p.Description
+				}
+				params = append(params, param)
+			}
+			o["parameters"] = params
+		}
+		if op.Request != nil {
+			o["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(b.schema(reflect.TypeOf(op.Request))),
+			}
+		}
+		responses := map[string]interface{}{}
+		for code, body := range op.Responses {
+			resp := map[string]interface{}{"description": http.StatusText(code)}
+			switch {
+			case body != nil:
+				resp["content"] = jsonContent(b.schema(reflect.TypeOf(body)))
+			case code >= 400:
+				resp["content"] = errorBody
+			}
+			responses[strconv.Itoa(code)] = resp
+		}
+		if op.Auth {
+			responses["401"] = map[string]interface{}{"description": "missing or invalid token", "content": errorBody}
+		}
+		if op.Request != nil {
+			responses["400"] = map[string]interface{}{"description": "malformed or invalid body", "content": errorBody}
+			responses["413"] = map[string]interface{}{"description": "body is too large", "content": errorBody}
+		}
+		responses["405"] = map[string]interface{}{"description": "method not allowed", "content": errorBody}
+		o["responses"] = responses
+		item[strings.ToLower(op.Method)] = o
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Distributed calculator API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// OpenAPIHandler — GET /api/v1/openapi.json
+func (o *Orchestrator) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = json.MarshalIndent(OpenAPISpec(), "", "  ")
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
@@ -1,406 +1,637 @@
-package application
-
-import (
-	"context"
-	"encoding/json"
-	"log"
-	"math"
-	"net"
-	"net/http"
-	"os"
-	"strconv"
-	"sync"
-	"time"
-
-	"github.com/lollmark/digital_calc/proto/calc"
-	"github.com/jmoiron/sqlx"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
-)
-
-type Config struct {
-	Addr                string
-	TimeAddition        int
-	TimeSubtraction     int
-	TimeMultiplications int
-	TimeDivisions       int
-}
-
-func ConfigFromEnv() *Config {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	ta, _ := strconv.Atoi(os.Getenv("TIME_ADDITION_MS"))
-	if ta == 0 {
-		ta = 100
-	}
-	ts, _ := strconv.Atoi(os.Getenv("TIME_SUBTRACTION_MS"))
-	if ts == 0 {
-		ts = 100
-	}
-	tm, _ := strconv.Atoi(os.Getenv("TIME_MULTIPLICATIONS_MS"))
-	if tm == 0 {
-		tm = 100
-	}
-	td, _ := strconv.Atoi(os.Getenv("TIME_DIVISIONS_MS"))
-	if td == 0 {
-		td = 100
-	}
-	return &Config{
-		Addr:                port,
-		TimeAddition:        ta,
-		TimeSubtraction:     ts,
-		TimeMultiplications: tm,
-		TimeDivisions:       td,
-	}
-}
-
-type Orchestrator struct {
-	calc.UnimplementedCalcServer
-	Config      *Config
-	DB          *sqlx.DB
-	mu          sync.Mutex
-	exprCounter int64
-	taskCounter int64
-}
-
-func NewOrchestrator() *Orchestrator {
-	db, err := sqlx.Connect("sqlite3", "calcgo.db")
-	if err != nil {
-		log.Fatal("cannot connect to db:", err)
-	}
-	schema := `
-CREATE TABLE IF NOT EXISTS users (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	login TEXT UNIQUE NOT NULL,
-	password_hash TEXT NOT NULL
-);
-CREATE TABLE IF NOT EXISTS expressions (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	user_id INTEGER NOT NULL,
-	expr TEXT NOT NULL,
-	status TEXT NOT NULL,
-	result REAL,
-	FOREIGN KEY(user_id) REFERENCES users(id)
-);
-CREATE TABLE IF NOT EXISTS tasks (
-  id TEXT PRIMARY KEY,
-  expr_id INTEGER NOT NULL,
-  arg1 REAL,
-  arg2 REAL,
-  operation TEXT,
-  operation_time INTEGER,
-  in_progress BOOLEAN NOT NULL DEFAULT 0,
-  done BOOLEAN NOT NULL DEFAULT 0,
-  UNIQUE(expr_id, arg1, arg2, operation),
-  FOREIGN KEY(expr_id) REFERENCES expressions(id)
-);
-`
-	if _, err := db.Exec(schema); err != nil {
-		log.Fatal("migrate failed:", err)
-	}
-	return &Orchestrator{Config: ConfigFromEnv(), DB: db}
-}
-
-func (o *Orchestrator) RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct{ Login, Password string }
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
-		return
-	}
-	hash, err := HashPassword(req.Password)
-	if err != nil {
-		http.Error(w, "server error", http.StatusInternalServerError)
-		return
-	}
-	if _, err := o.DB.Exec("INSERT INTO users(login,password_hash) VALUES(?,?)", req.Login, hash); err != nil {
-		http.Error(w, "user exists", http.StatusConflict)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-}
-
-func (o *Orchestrator) LoginHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct{ Login, Password string }
-	json.NewDecoder(r.Body).Decode(&req)
-	var id int
-	var hash string
-	err := o.DB.Get(&hash, "SELECT password_hash FROM users WHERE login=?", req.Login)
-	if err != nil {
-		http.Error(w, "invalid creds", http.StatusUnauthorized)
-		return
-	}
-	err = CheckPassword(hash, req.Password)
-	if err != nil {
-		http.Error(w, "invalid creds", http.StatusUnauthorized)
-		return
-	}
-	o.DB.Get(&id, "SELECT id FROM users WHERE login=?", req.Login)
-	tok, err := CreateToken(id)
-	if err != nil {
-		http.Error(w, "server error", http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": tok})
-}
-
-func (o *Orchestrator) CalculateHandler(w http.ResponseWriter, r *http.Request) {
-	uid := r.Context().Value("user_id").(int)
-	var req struct{ Expression string }
-	json.NewDecoder(r.Body).Decode(&req)
-
-	ast, err := ParseAST(req.Expression)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-		return
-	}
-
-	result, err := EvalAST(ast)
-	if err != nil || math.IsInf(result, 0) || math.IsNaN(result) {
-		http.Error(w, "invalid expression or result out of range", http.StatusUnprocessableEntity)
-		return
-	}
-
-	if ast.IsLeaf {
-		res := o.DB.MustExec(
-			"INSERT INTO expressions(user_id,expr,status,result) VALUES(?,?,?,?)",
-			uid, req.Expression, "done", result,
-		)
-		id, _ := res.LastInsertId()
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]int64{"id": id})
-		return
-	}
-
-	res := o.DB.MustExec("INSERT INTO expressions(user_id,expr,status) VALUES(?,?,?)", uid, req.Expression, "pending")
-	exprID, _ := res.LastInsertId()
-	o.scheduleTasksDB(exprID, ast)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]int64{"id": exprID})
-}
-
-func (o *Orchestrator) schedulePendingTasksDB(exprID int64, root *ASTNode) {
-	if root == nil || root.IsLeaf {
-		return
-	}
-	o.schedulePendingTasksDB(exprID, root.Left)
-	o.schedulePendingTasksDB(exprID, root.Right)
-
-	if root.Left.IsLeaf && root.Right.IsLeaf && !root.TaskScheduled {
-		n := strconv.FormatInt(time.Now().UnixNano(), 10)
-		opTime := 0
-		switch root.Operator {
-		case "+":
-			opTime = o.Config.TimeAddition
-		case "-":
-			opTime = o.Config.TimeSubtraction
-		case "*":
-			opTime = o.Config.TimeMultiplications
-		case "/":
-			opTime = o.Config.TimeDivisions
-		}
-		o.DB.Exec(
-			`INSERT OR IGNORE INTO tasks
-             (id, expr_id, arg1, arg2, operation, operation_time)
-             VALUES (?, ?, ?, ?, ?, ?)`,
-			n, exprID, root.Left.Value, root.Right.Value, root.Operator, opTime,
-		)
-		root.TaskScheduled = true
-	}
-}
-
-func (o *Orchestrator) scheduleTasksDB(exprID int64, node *ASTNode) {
-	if node == nil || node.IsLeaf {
-		return
-	}
-	o.scheduleTasksDB(exprID, node.Left)
-	o.scheduleTasksDB(exprID, node.Right)
-	if node.Left.IsLeaf && node.Right.IsLeaf && !node.TaskScheduled {
-		n := strconv.FormatInt(time.Now().UnixNano(), 10)
-		var opTime int
-		switch node.Operator {
-		case "+":
-			opTime = o.Config.TimeAddition
-		case "-":
-			opTime = o.Config.TimeSubtraction
-		case "*":
-			opTime = o.Config.TimeMultiplications
-		case "/":
-			opTime = o.Config.TimeDivisions
-		}
-		o.DB.MustExec(
-			"INSERT INTO tasks(id,expr_id,arg1,arg2,operation,operation_time) VALUES(?,?,?,?,?,?)",
-			n, exprID, node.Left.Value, node.Right.Value, node.Operator, opTime,
-		)
-		node.TaskScheduled = true
-	}
-}
-
-func (o *Orchestrator) expressionsHandler(w http.ResponseWriter, r *http.Request) {
-	uid := r.Context().Value("user_id").(int)
-	var exprs []struct {
-		ID     int      `db:"id" json:"id"`
-		Expr   string   `db:"expr" json:"expression"`
-		Status string   `db:"status" json:"status"`
-		Result *float64 `db:"result" json:"result,omitempty"`
-	}
-	o.DB.Select(&exprs, "SELECT id,expr,status,result FROM expressions WHERE user_id=?", uid)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"expressions": exprs})
-}
-
-func (o *Orchestrator) expressionByIDHandler(w http.ResponseWriter, r *http.Request) {
-	uid := r.Context().Value("user_id").(int)
-	id, _ := strconv.Atoi(r.URL.Path[len("/api/v1/expressions/"):])
-	var expr struct {
-		ID     int      `db:"id"`
-		Status string   `db:"status"`
-		Result *float64 `db:"result"`
-	}
-	err := o.DB.Get(&expr, "SELECT id,status,result FROM expressions WHERE user_id=? AND id=?", uid, id)
-	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"expression": expr})
-}
-
-func (o *Orchestrator) GetTask(ctx context.Context, _ *calc.Empty) (*calc.TaskResp, error) {
-	o.mu.Lock()
-	defer o.mu.Unlock()
-
-	var t struct {
-		ID            string  `db:"id"`
-		Arg1          float64 `db:"arg1"`
-		Arg2          float64 `db:"arg2"`
-		Operation     string  `db:"operation"`
-		OperationTime int     `db:"operation_time"`
-	}
-	err := o.DB.Get(&t, `
-        SELECT id, arg1, arg2, operation, operation_time
-          FROM tasks
-         WHERE in_progress = 0 AND done = 0
-         LIMIT 1
-    `)
-	if err != nil {
-		return nil, status.Error(codes.NotFound, "no task")
-	}
-	if _, err := o.DB.Exec("UPDATE tasks SET in_progress = 1 WHERE id = ?", t.ID); err != nil {
-		log.Printf("failed to mark task %s in progress: %v", t.ID, err)
-	}
-
-	return &calc.TaskResp{
-		Id:            t.ID,
-		Arg1:          t.Arg1,
-		Arg2:          t.Arg2,
-		Operation:     t.Operation,
-		OperationTime: int32(t.OperationTime),
-	}, nil
-}
-
-// PostResult — grpc-обработчик прихода результата от агента
-func (o *Orchestrator) PostResult(ctx context.Context, in *calc.ResultReq) (*calc.Empty, error) {
-	// 1. Узнаём, к какому выражению (expr_id) относится эта задача
-	var exprID int64
-	if err := o.DB.Get(&exprID, "SELECT expr_id FROM tasks WHERE id = ?", in.Id); err != nil {
-		return nil, status.Error(codes.NotFound, "task not found")
-	}
-
-	// 2. Помечаем задачу как выполненную
-	if _, err := o.DB.Exec("UPDATE tasks SET done = 1 WHERE id = ?", in.Id); err != nil {
-		return nil, status.Error(codes.Internal, "failed to update task")
-	}
-
-	// 3. Перепланируем вновь доступные подзадачи из AST
-	var fullExpr string
-	if err := o.DB.Get(&fullExpr, "SELECT expr FROM expressions WHERE id = ?", exprID); err == nil {
-		ast, err := ParseAST(fullExpr)
-		if err == nil {
-			o.schedulePendingTasksDB(exprID, ast)
-		} else {
-			log.Printf("PostResult: cannot parse AST: %v", err)
-		}
-	}
-
-	// 4. Считаем, остались ли незавершённые задачи
-	var remaining int
-	if err := o.DB.Get(&remaining, "SELECT COUNT(*) FROM tasks WHERE expr_id = ? AND done = 0", exprID); err != nil {
-		return nil, status.Error(codes.Internal, "failed to count tasks")
-	}
-
-	// 5. Если это была последняя — полностью вычисляем результат по AST
-	if remaining == 0 {
-		// парсим AST заново
-		ast, err := ParseAST(fullExpr)
-		if err != nil {
-			log.Printf("PostResult: final AST parse error: %v", err)
-		} else {
-			result, err := EvalAST(ast)
-			if err != nil {
-				log.Printf("PostResult: AST evaluation error: %v", err)
-			} else {
-				// обновляем выражение в БД
-				if _, err := o.DB.Exec(
-					"UPDATE expressions SET status = ?, result = ? WHERE id = ?",
-					"done", result, exprID,
-				); err != nil {
-					log.Printf("PostResult: failed to update expression: %v", err)
-				}
-			}
-		}
-	}
-
-	return &calc.Empty{}, nil
-}
-
-func (o *Orchestrator) RunServer() error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/v1/register", o.RegisterHandler)
-	mux.HandleFunc("/api/v1/login", o.LoginHandler)
-	mux.Handle("/api/v1/calculate", o.AuthMiddleware(http.HandlerFunc(o.CalculateHandler)))
-	mux.Handle("/api/v1/expressions", o.AuthMiddleware(http.HandlerFunc(o.expressionsHandler)))
-	mux.Handle("/api/v1/expressions/", o.AuthMiddleware(http.HandlerFunc(o.expressionByIDHandler)))
-
-	handlerWithCORS := EnableCORS(mux)
-
-	httpSrv := &http.Server{
-		Addr:    ":" + o.Config.Addr,
-		Handler: handlerWithCORS,
-	}
-	go func() {
-		log.Println("HTTP listening on", o.Config.Addr)
-		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal(err)
-		}
-	}()
-
-	lis, err := net.Listen("tcp", ":9090")
-	if err != nil {
-		return err
-	}
-	grpcSrv := grpc.NewServer()
-	calc.RegisterCalcServer(grpcSrv, o)
-	log.Println("gRPC listening on 9090")
-	return grpcSrv.Serve(lis)
-}
-
-func EnableCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lollmark/digital_calc/pkg/calculator"
+	"github.com/lollmark/digital_calc/proto/calc"
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type Config struct {
+	Addr                string
+	GRPCAddr            string
+	TimeAddition        int
+	TimeSubtraction     int
+	TimeMultiplications int
+	TimeDivisions       int
+	TimeExponentiations int
+	MaxLoginAttempts    int
+	LockoutDuration     time.Duration
+
+	RateLimitPerMinute    int
+	MaxPendingExpressions int
+	MaxASTNodes           int
+	MaxExpressionLength   int
+	MaxBatchSize          int
+	IdempotencyTTL        time.Duration
+	WebhookSecret         string
+	WebhookMaxAttempts    int
+	WebhookAllowPrivate   bool
+	MaxBodyBytes          int64
+	ShutdownTimeout       time.Duration
+	TaskLease             time.Duration
+}
+
+func ConfigFromEnv() *Config {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9090"
+	}
+	ta, _ := strconv.Atoi(os.Getenv("TIME_ADDITION_MS"))
+	if ta == 0 {
+		ta = 100
+	}
+	ts, _ := strconv.Atoi(os.Getenv("TIME_SUBTRACTION_MS"))
+	if ts == 0 {
+		ts = 100
+	}
+	tm, _ := strconv.Atoi(os.Getenv("TIME_MULTIPLICATIONS_MS"))
+	if tm == 0 {
+		tm = 100
+	}
+	td, _ := strconv.Atoi(os.Getenv("TIME_DIVISIONS_MS"))
+	if td == 0 {
+		td = 100
+	}
+	te, _ := strconv.Atoi(os.Getenv("TIME_EXPONENTIATIONS_MS"))
+	if te == 0 {
+		te = 100
+	}
+	la, _ := strconv.Atoi(os.Getenv("LOGIN_MAX_ATTEMPTS"))
+	if la == 0 {
+		la = 5
+	}
+	ld, _ := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_SEC"))
+	if ld == 0 {
+		ld = 900
+	}
+	rl, _ := strconv.Atoi(os.Getenv("RATE_LIMIT_PER_MINUTE"))
+	if rl == 0 {
+		rl = 60
+	}
+	mp, _ := strconv.Atoi(os.Getenv("MAX_PENDING_EXPRESSIONS"))
+	if mp == 0 {
+		mp = 10
+	}
+	mn, _ := strconv.Atoi(os.Getenv("MAX_AST_NODES"))
+	if mn == 0 {
+		mn = 255
+	}
+	ml, _ := strconv.Atoi(os.Getenv("MAX_EXPRESSION_LENGTH"))
+	if ml == 0 {
+		ml = 1024
+	}
+	mb, _ := strconv.Atoi(os.Getenv("MAX_BATCH_SIZE"))
+	if mb == 0 {
+		mb = 100
+	}
+	it, _ := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL_SEC"))
+	if it == 0 {
+		it = 86400
+	}
+	whs := os.Getenv("WEBHOOK_SECRET")
+	if whs == "" {
+		whs = "your-webhook-secret"
+	}
+	wha, _ := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if wha == 0 {
+		wha = 10
+	}
+	whp, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE"))
+	mbb, _ := strconv.ParseInt(os.Getenv("MAX_BODY_BYTES"), 10, 64)
+	if mbb == 0 {
+		mbb = 1 << 20
+	}
+	st, _ := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_SEC"))
+	if st == 0 {
+		st = 30
+	}
+	tl, _ := strconv.Atoi(os.Getenv("TASK_LEASE_SEC"))
+	if tl == 0 {
+		tl = 60
+	}
+	return &Config{
+		Addr:                port,
+		GRPCAddr:            grpcPort,
+		TimeAddition:        ta,
+		TimeSubtraction:     ts,
+		TimeMultiplications: tm,
+		TimeDivisions:       td,
+		TimeExponentiations: te,
+		MaxLoginAttempts:    la,
+		LockoutDuration:     time.Duration(ld) * time.Second,
+
+		RateLimitPerMinute:    rl,
+		MaxPendingExpressions: mp,
+		MaxASTNodes:           mn,
+		MaxExpressionLength:   ml,
+		MaxBatchSize:          mb,
+		IdempotencyTTL:        time.Duration(it) * time.Second,
+		WebhookSecret:         whs,
+		WebhookMaxAttempts:    wha,
+		WebhookAllowPrivate:   whp,
+		MaxBodyBytes:          mbb,
+		ShutdownTimeout:       time.Duration(st) * time.Second,
+		TaskLease:             time.Duration(tl) * time.Second,
+	}
+}
+
+type Orchestrator struct {
+	calc.UnimplementedCalcServer
+	Config      *Config
+	DB          *sqlx.DB
+	mu          sync.Mutex
+	exprCounter int64
+	taskCounter int64
+	rate        rateWindow
+	pending     userLocks   // held from checkPending until the insert
+	serving     atomic.Bool // the gRPC server is up; reported by /readyz
+}
+
+func NewOrchestrator() *Orchestrator {
+	db, err := NewDB("calcgo.db")
+	if err != nil {
+		slog.Error("cannot open db", "error", err)
+		os.Exit(1)
+	}
+	return &Orchestrator{Config: ConfigFromEnv(), DB: db}
+}
+
+func (o *Orchestrator) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	var req Credentials
+	if !o.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := o.Register(req.Login, req.Password); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (o *Orchestrator) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req Credentials
+	if !o.decodeJSON(w, r, &req) {
+		return
+	}
+	tok, err := o.Login(req.Login, req.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoginResp{Token: tok})
+}
+
+func (o *Orchestrator) CalculateHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	var req CalculateReq
+	if !o.decodeJSON(w, r, &req) {
+		return
+	}
+
+	exprID, err := o.Calculate(r.Context(), uid, req.Expression, req.Variables, req.CallbackURL, req.Complex)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CalculateResp{ID: exprID})
+}
+
+// preparedExpr is a parsed expression ready to be stored, with the
+// variables and definitions it was parsed with.
+type preparedExpr struct {
+	expr    string
+	vars    map[string]float64
+	defs    map[string]*Definition
+	complex bool
+	ast     *ASTNode
+	result  complex128
+}
+
+// immediate reports whether the value of the expression is known
+// without any task: it is a number, or conditionals on numbers.
+func (p *preparedExpr) immediate() bool {
+	_, ok := operand(p.ast, nil)
+	return ok
+}
+
+// prepareExpression checks an expression against the size limits and
+// evaluates it once locally, so that broken input is rejected before
+// any task is queued. defs are the user's definitions; only the ones
+// the expression uses are kept. In complex mode the expression is
+// computed over complex numbers.
+func (o *Orchestrator) prepareExpression(expr string, vars map[string]float64, defs map[string]*Definition, complexMode bool) (*preparedExpr, error) {
+	if len(expr) > o.Config.MaxExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", o.Config.MaxExpressionLength)
+	}
+	ast, used, err := ParseASTInScope(expr, Scope{Vars: vars, Defs: defs, MaxNodes: o.Config.MaxASTNodes, Complex: complexMode})
+	if err != nil {
+		return nil, err
+	}
+	if CountNodes(ast) > o.Config.MaxASTNodes {
+		return nil, fmt.Errorf("expression has more than %d nodes", o.Config.MaxASTNodes)
+	}
+	result, err := evalAST(ast, complexMode)
+	switch {
+	case errors.Is(err, calculation.ErrNotReal):
+		return nil, fmt.Errorf("%w, submit it in complex mode", err)
+	case errors.Is(err, calculation.ErrNotOrdered):
+		return nil, err
+	case err != nil || !finite(result):
+		return nil, errors.New("invalid expression or result out of range")
+	}
+	return &preparedExpr{expr: expr, vars: vars, defs: used, complex: complexMode, ast: ast, result: result}, nil
+}
+
+// insertExpression stores a prepared expression and queues its first
+// tasks. An expression that needs no tasks, like a plain number, is
+// stored as done. An empty callback means no webhook.
+func (o *Orchestrator) insertExpression(ctx context.Context, db sqlx.Ext, uid int, p *preparedExpr, callback string) (int64, error) {
+	now := NowMillis()
+	var cb *string
+	if callback != "" {
+		cb = &callback
+	}
+	v, err := encodeVariables(p.vars)
+	if err != nil {
+		return 0, err
+	}
+	d, err := encodeDefinitions(p.defs)
+	if err != nil {
+		return 0, err
+	}
+	unit := nullString(p.ast.Dim.String())
+	if p.immediate() {
+		r, c := valueFields(p.result, p.complex)
+		res, err := db.Exec(
+			`INSERT INTO expressions(user_id,expr,variables,definitions,complex,unit,status,result,complex_result,created_at,updated_at,finished_at,callback_url)
+             VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+			uid, p.expr, v, d, p.complex, unit, "done", r, c, now, now, now, cb,
+		)
+		if err != nil {
+			return 0, err
+		}
+		exprID, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		return exprID, enqueueWebhook(db, exprID)
+	}
+	res, err := db.Exec(
+		"INSERT INTO expressions(user_id,expr,variables,definitions,complex,unit,status,created_at,updated_at,callback_url) VALUES(?,?,?,?,?,?,?,?,?,?)",
+		uid, p.expr, v, d, p.complex, unit, "pending", now, now, cb,
+	)
+	if err != nil {
+		return 0, err
+	}
+	exprID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return exprID, o.scheduleTasksDB(ctx, db, exprID, p.ast)
+}
+
+func (o *Orchestrator) expressionByIDHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	rest := r.URL.Path[len("/api/v1/expressions/"):]
+	if idStr, ok := strings.CutSuffix(rest, "/details"); ok {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			apiError(w, http.StatusNotFound, CodeNotFound, "not found")
+			return
+		}
+		o.expressionDetailHandler(w, r, id)
+		return
+	}
+	id, _ := strconv.Atoi(rest)
+	var expr ExpressionStatus
+	err := o.DB.Get(&expr, "SELECT id,status,result,complex_result,unit FROM expressions WHERE user_id=? AND id=?", uid, id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ExpressionStatusResp{Expression: expr})
+}
+
+// cancelHandler — POST /api/v1/expressions/{id}/cancel
+func (o *Orchestrator) cancelHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		apiError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	e, err := o.CancelExpression(r.Context(), uid, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+func (o *Orchestrator) GetTask(ctx context.Context, _ *calc.Empty) (*calc.TaskResp, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requeueExpired(ctx)
+
+	var t struct {
+		ID            string  `db:"id"`
+		ExprID        int64   `db:"expr_id"`
+		Arg1          float64 `db:"arg1"`
+		Arg1Imag      float64 `db:"arg1_imag"`
+		Arg2          float64 `db:"arg2"`
+		Arg2Imag      float64 `db:"arg2_imag"`
+		Complex       bool    `db:"complex"`
+		Operation     string  `db:"operation"`
+		OperationTime int     `db:"operation_time"`
+		QueuedAt      *Millis `db:"queued_at"`
+		TraceParent   *string `db:"trace_parent"`
+	}
+	err := o.DB.Get(&t, `
+        SELECT t.id, t.expr_id, t.arg1, t.arg1_imag, t.arg2, t.arg2_imag, e.complex,
+               t.operation, t.operation_time, t.queued_at, t.trace_parent
+          FROM tasks t JOIN expressions e ON e.id = t.expr_id
+         WHERE t.in_progress = 0 AND t.done = 0
+         ORDER BY t.queued_at
+         LIMIT 1
+    `)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "no task")
+	}
+	ctx = withLog(ctx, "task_id", t.ID, "expr_id", t.ExprID)
+	// the handoff continues the trace the task was scheduled in, and the
+	// agent continues it from the response header
+	tctx := ctx
+	if t.TraceParent != nil {
+		tctx = withTraceParent(ctx, *t.TraceParent)
+	}
+	tctx, span := tracer().Start(tctx, "GetTask", trace.WithAttributes(attribute.String("task.id", t.ID)))
+	defer span.End()
+	if tp := traceParent(tctx); tp != "" {
+		grpc.SetHeader(ctx, metadata.Pairs(traceParentKey, tp))
+	}
+	now := NowMillis()
+	if _, err := o.DB.Exec(
+		"UPDATE tasks SET in_progress = 1, agent_id = ?, started_at = ? WHERE id = ?",
+		nullString(agentID(ctx)), now, t.ID,
+	); err != nil {
+		slog.ErrorContext(ctx, "failed to mark task in progress", "error", err)
+	}
+	slog.DebugContext(ctx, "task handed out", "operation", t.Operation)
+	if t.QueuedAt != nil {
+		taskQueueWait.WithLabelValues(t.Operation).Observe(now.Time().Sub(t.QueuedAt.Time()).Seconds())
+	}
+
+	return &calc.TaskResp{
+		Id:            t.ID,
+		Arg1:          t.Arg1,
+		Arg2:          t.Arg2,
+		Operation:     t.Operation,
+		OperationTime: int32(t.OperationTime),
+		Arg1Imag:      t.Arg1Imag,
+		Arg2Imag:      t.Arg2Imag,
+		Complex:       t.Complex,
+	}, nil
+}
+
+// PostResult — grpc-обработчик прихода результата от агента
+func (o *Orchestrator) PostResult(ctx context.Context, in *calc.ResultReq) (*calc.Empty, error) {
+	// 1. Узнаём, к какому выражению (expr_id) относится эта задача
+	var task struct {
+		ExprID    int64   `db:"expr_id"`
+		Operation string  `db:"operation"`
+		StartedAt *Millis `db:"started_at"`
+	}
+	if err := o.DB.Get(&task, "SELECT expr_id, operation, started_at FROM tasks WHERE id = ?", in.Id); err != nil {
+		return nil, status.Error(codes.NotFound, "task not found")
+	}
+	exprID := task.ExprID
+	ctx = withLog(ctx, "task_id", in.Id, "expr_id", exprID)
+	ctx, span := tracer().Start(withTraceParent(ctx, incomingTraceParent(ctx)), "PostResult",
+		trace.WithAttributes(attribute.String("task.id", in.Id)))
+	defer span.End()
+
+	// 2. Сохраняем результат и помечаем задачу как выполненную. Принимается
+	// только результат задачи, которую держит этот агент: повторный или
+	// запоздавший результат не должен переписать уже использованный.
+	// Задачу, выданную агенту без agent-id (и задачи старых версий без
+	// agent_id), держит агент с пустым id
+	now := NowMillis()
+	res, err := o.DB.Exec(
+		`UPDATE tasks SET done = 1, in_progress = 0, result = ?, result_imag = ?, finished_at = ?
+          WHERE id = ? AND in_progress = 1 AND done = 0 AND COALESCE(agent_id, '') = ?`,
+		in.Result, in.ResultImag, now, in.Id, agentID(ctx),
+	)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to update task")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		slog.WarnContext(ctx, "result rejected: task is not in progress on this agent")
+		return nil, status.Error(codes.FailedPrecondition, "task is not in progress on this agent")
+	}
+	slog.DebugContext(ctx, "task result received", "operation", task.Operation)
+	if task.StartedAt != nil {
+		taskDuration.WithLabelValues(task.Operation).Observe(now.Time().Sub(task.StartedAt.Time()).Seconds())
+	}
+
+	// 3. Либо это был корень дерева и выражение готово, либо планируем
+	// узлы, у которых теперь известны оба операнда
+	o.advance(ctx, exprID)
+
+	return &calc.Empty{}, nil
+}
+
+// requeueExpired puts back in the queue the tasks whose agents have held
+// them for longer than their operation time plus TaskLease, as an agent
+// that stopped without releasing them never posts their results. Tasks
+// in progress with no start time, left by older versions, are requeued
+// too. The caller must hold o.mu.
+func (o *Orchestrator) requeueExpired(ctx context.Context) {
+	res, err := o.DB.Exec(`
+        UPDATE tasks SET in_progress = 0, agent_id = NULL, started_at = NULL
+         WHERE in_progress = 1 AND done = 0
+           AND (started_at IS NULL OR started_at + operation_time + ? < ?)`,
+		o.Config.TaskLease.Milliseconds(), NowMillis(),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to requeue expired tasks", "error", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.WarnContext(ctx, "task lease expired, tasks requeued", "tasks", n)
+	}
+}
+
+// ReleaseTask puts a task an agent took but will not finish back at the
+// front of the queue.
+func (o *Orchestrator) ReleaseTask(ctx context.Context, in *calc.ReleaseReq) (*calc.Empty, error) {
+	res, err := o.DB.Exec(
+		"UPDATE tasks SET in_progress = 0, agent_id = NULL, started_at = NULL WHERE id = ? AND in_progress = 1 AND done = 0",
+		in.Id,
+	)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to release task")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, status.Error(codes.NotFound, "task is not in progress")
+	}
+	slog.InfoContext(withLog(ctx, "task_id", in.Id), "task released")
+	return &calc.Empty{}, nil
+}
+
+// Routes builds the HTTP API handler.
+func (o *Orchestrator) Routes() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(path string, h http.Handler, methods ...string) {
+		mux.Handle(path, instrumentHTTP(path, traceHTTP(path, allowMethods(h, methods...))))
+	}
+	auth := func(h http.HandlerFunc) http.Handler { return o.AuthMiddleware(h) }
+	handle("/api/v1/register", http.HandlerFunc(o.RegisterHandler), http.MethodPost)
+	handle("/api/v1/login", http.HandlerFunc(o.LoginHandler), http.MethodPost)
+	handle("/api/v1/openapi.json", http.HandlerFunc(o.OpenAPIHandler), http.MethodGet)
+	handle("/api/v1/password", auth(o.ChangePasswordHandler), http.MethodPost)
+	handle("/api/v1/account", auth(o.DeleteAccountHandler), http.MethodDelete)
+	handle("/api/v1/me/usage", auth(o.UsageHandler), http.MethodGet)
+	handle("/api/v1/calculate", o.AuthMiddleware(o.Idempotent(http.HandlerFunc(o.CalculateHandler))), http.MethodPost)
+	handle("/api/v1/calculate/batch", o.AuthMiddleware(o.Idempotent(http.HandlerFunc(o.BatchHandler))), http.MethodPost)
+	handle("/api/v1/batches/", auth(o.batchByIDHandler), http.MethodGet)
+	handle("/api/v1/expressions", auth(o.expressionsHandler), http.MethodGet)
+	handle("/api/v1/expressions/", auth(o.expressionByIDHandler), http.MethodGet)
+	handle("/api/v1/expressions/{id}/cancel", auth(o.cancelHandler), http.MethodPost)
+	handle("/api/v1/definitions", auth(o.definitionsHandler), http.MethodGet)
+	handle("/api/v1/definitions/{name}", auth(o.definitionHandler), http.MethodGet, http.MethodPut, http.MethodDelete)
+	handle("/api/v1/definitions/{name}/versions", auth(o.definitionVersionsHandler), http.MethodGet)
+	handle("/metrics", o.MetricsHandler(), http.MethodGet)
+	handle("/healthz", http.HandlerFunc(o.HealthzHandler), http.MethodGet)
+	handle("/readyz", http.HandlerFunc(o.ReadyzHandler), http.MethodGet)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusNotFound, CodeNotFound, "no such endpoint")
+	})
+	return EnableCORS(logRequests(mux))
+}
+
+// RunServer serves HTTP and gRPC until ctx is done or a server fails.
+// On the way out it reports not ready, stops accepting requests and lets
+// the ones in flight finish within Config.ShutdownTimeout.
+func (o *Orchestrator) RunServer(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	webhooksDone := make(chan struct{})
+	go func() {
+		o.RunWebhooks(ctx)
+		close(webhooksDone)
+	}()
+
+	lis, err := net.Listen("tcp", ":"+o.Config.GRPCAddr)
+	if err != nil {
+		return err
+	}
+	grpcSrv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(MetricsUnaryInterceptor, LoggingUnaryInterceptor, o.AuthUnaryInterceptor),
+		grpc.ChainStreamInterceptor(MetricsStreamInterceptor, LoggingStreamInterceptor, o.AuthStreamInterceptor),
+	)
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(grpcSrv, hs)
+	calc.RegisterCalcServer(grpcSrv, o)
+	calc.RegisterCalcAPIServer(grpcSrv, NewAPIServer(o))
+	for name := range grpcSrv.GetServiceInfo() {
+		hs.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	httpSrv := &http.Server{
+		Addr:    ":" + o.Config.Addr,
+		Handler: o.Routes(),
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		slog.Info("gRPC listening", "addr", lis.Addr().String())
+		if err := grpcSrv.Serve(lis); err != nil {
+			errc <- fmt.Errorf("gRPC server: %w", err)
+		}
+	}()
+	go func() {
+		slog.Info("HTTP listening", "addr", httpSrv.Addr)
+		if err := httpSrv.ListenAndServe(); err != http.ErrServerClosed {
+			errc <- fmt.Errorf("HTTP server: %w", err)
+		}
+	}()
+	o.serving.Store(true)
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-errc:
+	}
+	slog.Info("shutting down", "timeout", o.Config.ShutdownTimeout)
+	o.serving.Store(false)
+	hs.Shutdown()
+	cancel()
+
+	sctx, stop := context.WithTimeout(context.Background(), o.Config.ShutdownTimeout)
+	defer stop()
+	if err := httpSrv.Shutdown(sctx); err != nil {
+		slog.Warn("HTTP requests did not finish in time", "error", err)
+		httpSrv.Close()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-sctx.Done():
+		// watch streams and slow calls are cut off
+		slog.Warn("gRPC calls did not finish in time")
+		grpcSrv.Stop()
+	}
+	<-webhooksDone
+	slog.Info("stopped")
+	return runErr
+}
+
+func EnableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Idempotent-Replayed")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,169 +1,993 @@
-package application
-
-import (
-	"fmt"
-	"strconv"
-	"strings"
-	"unicode"
-
-	"github.com/lollmark/digital_calc/pkg/calculator"
-)
-
-type ASTNode struct {
-	IsLeaf        bool
-	Value         float64
-	Operator      string
-	Left, Right   *ASTNode
-	TaskScheduled bool
-}
-
-func ParseAST(expression string) (*ASTNode, error) {
-	expr := strings.ReplaceAll(expression, " ", "")
-	if expr == "" {
-		return nil, fmt.Errorf("empty expression")
-	}
-	p := &parser{input: expr, pos: 0}
-	node, err := p.parseExpression()
-	if err != nil {
-		return nil, err
-	}
-	if p.pos < len(p.input) {
-		return nil, fmt.Errorf("unexpected token at position %d", p.pos)
-	}
-	return node, nil
-}
-
-type parser struct {
-	input string
-	pos   int
-}
-
-func (p *parser) peek() rune {
-	if p.pos < len(p.input) {
-		return rune(p.input[p.pos])
-	}
-	return 0
-}
-
-func (p *parser) get() rune {
-	ch := p.peek()
-	p.pos++
-	return ch
-}
-
-func (p *parser) parseExpression() (*ASTNode, error) {
-	node, err := p.parseTerm()
-	if err != nil {
-		return nil, err
-	}
-	for {
-		ch := p.peek()
-		if ch == '+' || ch == '-' {
-			op := string(p.get())
-			right, err := p.parseTerm()
-			if err != nil {
-				return nil, err
-			}
-			node = &ASTNode{
-				IsLeaf:   false,
-				Operator: op,
-				Left:     node,
-				Right:    right,
-			}
-		} else {
-			break
-		}
-	}
-	return node, nil
-}
-
-func (p *parser) parseTerm() (*ASTNode, error) {
-	node, err := p.parseFactor()
-	if err != nil {
-		return nil, err
-	}
-	for {
-		ch := p.peek()
-		if ch == '*' || ch == '/' {
-			op := string(p.get())
-			right, err := p.parseFactor()
-			if err != nil {
-				return nil, err
-			}
-			node = &ASTNode{
-				IsLeaf:   false,
-				Operator: op,
-				Left:     node,
-				Right:    right,
-			}
-		} else {
-			break
-		}
-	}
-	return node, nil
-}
-
-func (p *parser) parseFactor() (*ASTNode, error) {
-	ch := p.peek()
-	if ch == '(' {
-		p.get()
-		node, err := p.parseExpression()
-		if err != nil {
-			return nil, err
-		}
-		if p.peek() != ')' {
-			return nil, fmt.Errorf("missing closing parenthesis")
-		}
-		p.get()
-		return node, nil
-	}
-	start := p.pos
-	// Обрабатываем знак: унарный плюс разрешаем только если он стоит в начале или сразу после '('
-	if ch == '+' {
-		if p.pos > 0 && p.input[p.pos-1] != '(' {
-			return nil, fmt.Errorf("unexpected unary plus at position %d", p.pos)
-		}
-		p.get()
-	} else if ch == '-' {
-		p.get()
-	}
-	for {
-		ch = p.peek()
-		if unicode.IsDigit(ch) || ch == '.' {
-			p.get()
-		} else {
-			break
-		}
-	}
-	token := p.input[start:p.pos]
-	if token == "" {
-		return nil, fmt.Errorf("expected number at position %d", start)
-	}
-	value, err := strconv.ParseFloat(token, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid number %s", token)
-	}
-	return &ASTNode{
-		IsLeaf: true,
-		Value:  value,
-	}, nil
-}
-
-// В конце файла ast.go, после ParseAST и парсера:
-func EvalAST(node *ASTNode) (float64, error) {
-	if node.IsLeaf {
-		return node.Value, nil
-	}
-	left, err := EvalAST(node.Left)
-	if err != nil {
-		return 0, err
-	}
-	right, err := EvalAST(node.Right)
-	if err != nil {
-		return 0, err
-	}
-	if node.Operator == "/" && right == 0 {
-		return 0, fmt.Errorf("division by zero")
-	}
-	return calculation.Compute(node.Operator, left, right)
-}
+package application
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lollmark/digital_calc/pkg/calculator"
+)
+
+// Operators with a meaning of their own. Unary nodes have no Right.
+// The conditionals OpIf, OpAnd and OpOr never become tasks: their value
+// is that of the operand their condition selects, and the other operand
+// is not computed at all.
+const (
+	OpNeg = "neg" // unary minus
+	OpNot = "!"   // 1 if the operand is 0, else 0
+	OpIf  = "if"  // Cond ? Left : Right
+	OpAnd = "&&"  // Left && Right, 1 or 0
+	OpOr  = "||"  // Left || Right, 1 or 0
+)
+
+type ASTNode struct {
+	IsLeaf      bool
+	Value       float64
+	Imag        float64   // мнимая часть литерала в комплексном режиме
+	Dim         Dimension // размерность значения, см. units.go
+	Operator    string
+	Left, Right *ASTNode
+	Cond        *ASTNode // условие OpIf
+	ID          int      // номер узла-операции, см. numberNodes
+	Name        string   // переменная, значением которой является узел
+}
+
+// Conditional reports whether n is an OpIf, OpAnd or OpOr node.
+func (n *ASTNode) Conditional() bool {
+	return n.Operator == OpIf || n.Operator == OpAnd || n.Operator == OpOr
+}
+
+// Condition returns the operand that decides a conditional node.
+func (n *ASTNode) Condition() *ASTNode {
+	if n.Operator == OpIf {
+		return n.Cond
+	}
+	return n.Left
+}
+
+// Decide returns the operand a conditional node takes its value from
+// once its condition is known, or nil if the condition alone decides
+// it: false && x is 0 and true || x is 1.
+func (n *ASTNode) Decide(cond complex128) *ASTNode {
+	switch {
+	case n.Operator == OpIf && cond != 0:
+		return n.Left
+	case n.Operator == OpIf:
+		return n.Right
+	case n.Operator == OpAnd && cond == 0, n.Operator == OpOr && cond != 0:
+		return nil
+	}
+	return n.Right
+}
+
+// Decided returns the value of a conditional node given its condition
+// and, if Decide returned an operand, the value of that operand.
+func (n *ASTNode) Decided(cond, taken complex128) complex128 {
+	if n.Operator == OpIf {
+		return taken
+	}
+	if n.Decide(cond) == nil {
+		return truth(cond)
+	}
+	return truth(taken)
+}
+
+func truth(v complex128) complex128 {
+	if v != 0 {
+		return 1
+	}
+	return 0
+}
+
+func ParseAST(expression string) (*ASTNode, error) {
+	return ParseASTWithVars(expression, nil)
+}
+
+// ParseASTWithVars parses a program of statements separated by ';':
+// assignments "name = expression" and expressions, the last of which
+// is the result, e.g. "a = 2+3; b = a*4; b-1". Variables come from vars
+// or earlier assignments. An assigned expression is parsed once and
+// shared by every use of the variable, so the result is a DAG in which
+// it becomes a single task.
+func ParseASTWithVars(expression string, vars map[string]float64) (*ASTNode, error) {
+	ast, _, err := ParseASTInScope(expression, Scope{Vars: vars})
+	return ast, err
+}
+
+// Scope is what the names in an expression refer to besides its own
+// assignments.
+type Scope struct {
+	Vars map[string]float64
+	// Imag and Dims give variables of Vars an imaginary part and a
+	// dimension, as the calcctl repl keeps them for its results.
+	Imag map[string]float64
+	Dims map[string]Dimension
+	// Defs are saved constants, used by name, and formulas, called as
+	// name(arguments); variables and assignments hide them.
+	Defs map[string]*Definition
+	// MaxNodes stops expanding definitions once the expression has more
+	// nodes, so that nested formulas cannot take forever; 0 means no
+	// limit.
+	MaxNodes int
+	// Complex allows imaginary literals such as 4i and the imaginary
+	// unit i, unless a variable is called i.
+	Complex bool
+}
+
+// ParseASTInScope is ParseASTWithVars with definitions. It also returns
+// the definitions the expression uses, directly or through others.
+// Every use of a constant shares one node; every call of a formula is
+// a copy of its body with the arguments in place of its parameters.
+func ParseASTInScope(expression string, scope Scope) (*ASTNode, map[string]*Definition, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &parser{tokens: tokens, vars: make(map[string]*ASTNode, len(scope.Vars)), defs: newExpansion(scope.Defs, scope.MaxNodes, scope.Complex)}
+	for name, v := range scope.Vars {
+		p.vars[name] = &ASTNode{IsLeaf: true, Value: v, Imag: scope.Imag[name], Dim: scope.Dims[name], Name: name}
+	}
+	var node *ASTNode
+	list := false
+	for p.peek().kind != tokEOF {
+		if p.is(";") {
+			p.next()
+			continue
+		}
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, nil, err
+		}
+		node, list = stmt, stmt == nil
+		if !p.is(";") && p.peek().kind != tokEOF {
+			return nil, nil, p.unexpected()
+		}
+	}
+	if list {
+		return nil, nil, fmt.Errorf("the result is a list, not a number")
+	}
+	if node == nil {
+		return nil, nil, fmt.Errorf("empty expression")
+	}
+	if p.defs.exceeded() {
+		return nil, nil, fmt.Errorf("expression has more than %d nodes", p.defs.maxNodes)
+	}
+	return node, p.defs.used, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int // index of the current token
+	vars   map[string]*ASTNode
+	lists  map[string][]*ASTNode // variables assigned a list
+	defs   *expansion
+}
+
+// expansion is the state of expanding definitions, shared by the parser
+// of an expression and the parsers of the definition bodies it expands.
+type expansion struct {
+	defs map[string]*Definition
+	// tokens caches the tokenized bodies of defs
+	tokens map[string][]token
+	consts map[string]*ASTNode
+	used   map[string]*Definition
+	// stack holds the definitions being expanded, to catch cycles
+	stack           []string
+	nodes, maxNodes int
+	complex         bool // see Scope.Complex
+	// skipUnits is set while checking the bodies of formulas, whose
+	// parameters have no dimension yet
+	skipUnits bool
+}
+
+func newExpansion(defs map[string]*Definition, maxNodes int, complexMode bool) *expansion {
+	return &expansion{
+		defs:     defs,
+		tokens:   map[string][]token{},
+		consts:   map[string]*ASTNode{},
+		used:     map[string]*Definition{},
+		maxNodes: maxNodes,
+		complex:  complexMode,
+	}
+}
+
+func (e *expansion) exceeded() bool { return e.maxNodes > 0 && e.nodes > e.maxNodes }
+
+// node counts a new node against maxNodes.
+func (p *parser) node(n *ASTNode) *ASTNode {
+	p.defs.nodes++
+	return n
+}
+
+// operation counts a new operation node and works out its dimension;
+// what and pos name the operator in errors.
+func (p *parser) operation(n *ASTNode, what string, pos int) (*ASTNode, error) {
+	if err := n.checkUnits(what, pos); err != nil && !p.defs.skipUnits {
+		return nil, err
+	}
+	return p.node(n), nil
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+// ahead returns the token after the current one.
+func (p *parser) ahead() token {
+	return p.tokens[min(p.pos+1, len(p.tokens)-1)]
+}
+
+// next returns the current token and moves past it; it stays at tokEOF.
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the current token is the operator op.
+func (p *parser) is(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+// unexpected reports the current token as an error.
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return fmt.Errorf("unexpected end of expression at position %d", t.pos)
+	}
+	return fmt.Errorf("unexpected %s at position %d", t.text, t.pos)
+}
+
+// closing consumes the ')' of a group opened at pos.
+func (p *parser) closing(pos int) error {
+	if !p.is(")") {
+		return fmt.Errorf("missing closing parenthesis for ( at position %d", pos)
+	}
+	p.next()
+	return nil
+}
+
+// parseStatement parses an assignment or an expression and returns the
+// value of either; nil for the assignment of a list, "xs = [1, 2]",
+// which only aggregate functions can use.
+func (p *parser) parseStatement() (*ASTNode, error) {
+	t := p.peek()
+	if t.kind != tokIdent || p.ahead().text != "=" {
+		return p.parseExpression()
+	}
+	p.pos += 2
+	if p.is("[") {
+		items, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		if p.lists == nil {
+			p.lists = map[string][]*ASTNode{}
+		}
+		p.lists[t.text] = items
+		delete(p.vars, t.text)
+		return nil, nil
+	}
+	node, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if node.Name == "" {
+		node.Name = t.text
+	}
+	p.vars[t.text] = node
+	delete(p.lists, t.text)
+	return node, nil
+}
+
+// parseExpression parses a full expression: a conditional
+// "cond ? a : b", which is right-associative and binds loosest, or
+// anything tighter.
+func (p *parser) parseExpression() (*ASTNode, error) {
+	node, err := p.parseOr()
+	if err != nil || !p.is("?") {
+		return node, err
+	}
+	q := p.next()
+	then, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is(":") {
+		return nil, fmt.Errorf("expected : at position %d", p.peek().pos)
+	}
+	p.next()
+	otherwise, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return p.operation(&ASTNode{Operator: OpIf, Cond: node, Left: then, Right: otherwise}, q.text, q.pos)
+}
+
+func (p *parser) parseOr() (*ASTNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		node = p.node(&ASTNode{Operator: OpOr, Left: node, Right: right})
+	}
+	return node, nil
+}
+
+func (p *parser) parseAnd() (*ASTNode, error) {
+	node, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("&&") {
+		p.next()
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		node = p.node(&ASTNode{Operator: OpAnd, Left: node, Right: right})
+	}
+	return node, nil
+}
+
+var comparisons = []string{"<", "<=", ">", ">=", "==", "!="}
+
+// parseComparison parses a comparison, which gives 1 or 0. Comparisons
+// do not chain: 1 < x < 3 is an error rather than (1 < x) < 3.
+func (p *parser) parseComparison() (*ASTNode, error) {
+	node, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokOp || !slices.Contains(comparisons, t.text) {
+		return node, nil
+	}
+	p.next()
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if n := p.peek(); n.kind == tokOp && slices.Contains(comparisons, n.text) {
+		return nil, fmt.Errorf("comparisons cannot be chained at position %d, join them with &&", n.pos)
+	}
+	return p.operation(&ASTNode{Operator: t.text, Left: node, Right: right}, t.text, t.pos)
+}
+
+func (p *parser) parseSum() (*ASTNode, error) {
+	node, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("+") || p.is("-") {
+		op := p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		node, err = p.operation(&ASTNode{
+			IsLeaf:   false,
+			Operator: op.text,
+			Left:     node,
+			Right:    right,
+		}, op.text, op.pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func (p *parser) parseTerm() (*ASTNode, error) {
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("*") || p.is("/") {
+		op := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		node, err = p.operation(&ASTNode{
+			IsLeaf:   false,
+			Operator: op.text,
+			Left:     node,
+			Right:    right,
+		}, op.text, op.pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// parseUnary parses a unary minus, plus or '!', which bind looser than
+// '^' and tighter than '*' and '/': -2^2 is -(2^2), 2*-3 is 2*(-3), --5
+// is 5. A plus is dropped. A minus before a literal is folded into it;
+// otherwise it becomes an OpNeg node.
+func (p *parser) parseUnary() (*ASTNode, error) {
+	t := p.peek()
+	if p.is("+") {
+		p.next()
+		return p.parseUnary()
+	}
+	if !p.is("-") && !p.is("!") {
+		return p.parsePower()
+	}
+	p.next()
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if t.text == "!" {
+		return p.node(&ASTNode{Operator: OpNot, Left: operand}), nil
+	}
+	if operand.IsLeaf && operand.Name == "" {
+		return &ASTNode{IsLeaf: true, Value: -operand.Value, Imag: -operand.Imag, Dim: operand.Dim}, nil
+	}
+	return p.operation(&ASTNode{Operator: OpNeg, Left: operand}, t.text, t.pos)
+}
+
+// parsePower parses '^', which is right-associative: 2^3^2 is 2^(3^2).
+// The exponent may have a sign: 2^-1.
+func (p *parser) parsePower() (*ASTNode, error) {
+	node, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is("^") {
+		return node, nil
+	}
+	t := p.next()
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return p.operation(&ASTNode{Operator: "^", Left: node, Right: right}, t.text, t.pos)
+}
+
+func (p *parser) parseFactor() (*ASTNode, error) {
+	t := p.peek()
+	if p.is("(") {
+		p.next()
+		node, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.closing(t.pos); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	if p.is("[") {
+		return nil, fmt.Errorf("a list at position %d can only be an argument of an aggregate function such as sum", t.pos)
+	}
+	if t.kind == tokIdent {
+		p.next()
+		name := t.text
+		if p.is("(") && name == OpIf {
+			return p.conditional(t.pos)
+		}
+		if _, ok := aggregates[name]; ok && p.is("(") {
+			return p.aggregate(name, t.pos)
+		}
+		if slices.Contains(functions, name) && p.is("(") {
+			return p.function(name, t.pos)
+		}
+		if _, ok := p.lists[name]; ok {
+			return nil, fmt.Errorf("%s at position %d is a list, use it in an aggregate function such as sum(%s)", name, t.pos, name)
+		}
+		if p.is("(") {
+			return p.call(name, t.pos)
+		}
+		if node, ok := p.vars[name]; ok {
+			return node, nil
+		}
+		if name == "i" && p.defs.complex {
+			return p.node(&ASTNode{IsLeaf: true, Imag: 1}), nil
+		}
+		if def, ok := p.defs.defs[name]; ok {
+			if len(def.Params) > 0 {
+				return nil, fmt.Errorf("%s at position %d is a formula, call it as %s(...)", name, t.pos, name)
+			}
+			return p.constant(def)
+		}
+		return nil, fmt.Errorf("unknown variable %s at position %d", name, t.pos)
+	}
+	if t.kind != tokNumber {
+		return nil, fmt.Errorf("expected number at position %d", t.pos)
+	}
+	p.next()
+	if t.imag && !p.defs.complex {
+		return nil, fmt.Errorf("imaginary number %s at position %d needs complex mode", t.text, t.pos)
+	}
+	scale, dim := 1.0, Dimension{}
+	if p.unitName(p.pos) {
+		var err error
+		if scale, dim, err = p.unit(); err != nil {
+			return nil, err
+		}
+	}
+	v := t.value * scale
+	// the scale of a unit can push a number out of range either way
+	if math.IsInf(v, 0) || math.IsNaN(v) || (v == 0 && t.value != 0) {
+		return nil, fmt.Errorf("number %s at position %d is out of range", t.text, t.pos)
+	}
+	if t.imag {
+		return p.node(&ASTNode{IsLeaf: true, Imag: v, Dim: dim}), nil
+	}
+	return p.node(&ASTNode{
+		IsLeaf: true,
+		Value:  v,
+		Dim:    dim,
+	}), nil
+}
+
+// functions are the functions of one number. Over real numbers conj and
+// re return their argument, im is 0 and arg is 0 or pi.
+var functions = []string{"abs", "arg", "conj", "im", "re", "sqrt"}
+
+// function parses the argument of a function and makes it a unary node.
+func (p *parser) function(name string, start int) (*ASTNode, error) {
+	args, err := p.arguments(p.next().pos)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s at position %d takes 1 argument, got %d", name, start, len(args))
+	}
+	return p.operation(&ASTNode{Operator: name, Left: args[0]}, name, start)
+}
+
+// conditional parses the arguments of if(cond, a, b).
+func (p *parser) conditional(start int) (*ASTNode, error) {
+	args, err := p.arguments(p.next().pos)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 3 {
+		return nil, fmt.Errorf("if at position %d takes 3 arguments, got %d", start, len(args))
+	}
+	return p.operation(&ASTNode{Operator: OpIf, Cond: args[0], Left: args[1], Right: args[2]}, OpIf, start)
+}
+
+// arguments parses comma-separated expressions up to the ')' closing
+// the '(' at open, which is already consumed.
+func (p *parser) arguments(open int) ([]*ASTNode, error) {
+	var args []*ASTNode
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.closing(open); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// aggregates are the functions of lists. Each becomes a balanced tree
+// of binary operations over the items, so that agents compute every
+// level of it in parallel instead of one long chain.
+var aggregates = map[string]struct {
+	op    string  // the reducing operation
+	empty float64 // the value for no items
+	// nonEmpty rejects no items instead
+	nonEmpty bool
+}{
+	"sum":    {op: "+", empty: 0},
+	"prod":   {op: "*", empty: 1},
+	"min":    {op: "min", nonEmpty: true},
+	"max":    {op: "max", nonEmpty: true},
+	"avg":    {op: "+", nonEmpty: true},
+	"count":  {},
+	"median": {nonEmpty: true},
+	"stddev": {nonEmpty: true},
+}
+
+// Builtins are the names of the functions of expressions, which saved
+// definitions cannot take.
+func Builtins() []string {
+	names := append([]string{OpIf}, functions...)
+	for name := range aggregates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// aggregate parses the arguments of an aggregate function, items and
+// lists of items, and builds its reduction tree.
+func (p *parser) aggregate(name string, start int) (*ASTNode, error) {
+	open := p.next().pos
+	var items []*ASTNode
+	for !p.is(")") {
+		t := p.peek()
+		switch after := p.ahead().text; {
+		case p.is("["):
+			list, err := p.list()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, list...)
+		case t.kind == tokIdent && p.lists[t.text] != nil && (after == "," || after == ")"):
+			p.next()
+			items = append(items, p.lists[t.text]...)
+		default:
+			item, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		if !p.is(",") {
+			break
+		}
+		p.next()
+		if p.is(")") {
+			return nil, p.unexpected()
+		}
+	}
+	if err := p.closing(open); err != nil {
+		return nil, err
+	}
+	a, n := aggregates[name], len(items)
+	switch {
+	case name == "count":
+		return p.node(&ASTNode{IsLeaf: true, Value: float64(n)}), nil
+	case n == 0 && a.nonEmpty:
+		return nil, fmt.Errorf("%s at position %d needs at least one item", name, start)
+	case n == 0:
+		return p.node(&ASTNode{IsLeaf: true, Value: a.empty}), nil
+	}
+	switch name {
+	case "avg":
+		sum, err := p.reduce("+", items, name, start)
+		if err != nil {
+			return nil, err
+		}
+		return p.mean(sum, n, name, start)
+	case "stddev":
+		return p.stddev(items, start)
+	case "median":
+		return p.median(items, start)
+	}
+	return p.reduce(a.op, items, name, start)
+}
+
+// reduce builds the balanced tree of op over items.
+func (p *parser) reduce(op string, items []*ASTNode, name string, start int) (*ASTNode, error) {
+	for len(items) > 1 {
+		var level []*ASTNode
+		for i := 0; i+1 < len(items); i += 2 {
+			node, err := p.operation(&ASTNode{Operator: op, Left: items[i], Right: items[i+1]}, name, start)
+			if err != nil {
+				return nil, err
+			}
+			level = append(level, node)
+		}
+		if len(items)%2 == 1 {
+			level = append(level, items[len(items)-1])
+		}
+		items = level
+	}
+	return items[0], nil
+}
+
+// mean divides sum by the number of items n.
+func (p *parser) mean(sum *ASTNode, n int, name string, start int) (*ASTNode, error) {
+	count := p.node(&ASTNode{IsLeaf: true, Value: float64(n)})
+	return p.operation(&ASTNode{Operator: "/", Left: sum, Right: count}, name, start)
+}
+
+// stddev is the population standard deviation sqrt(avg(x^2) - avg(x)^2):
+// the sums of the items and of their squares are two balanced trees
+// computed side by side. Rounding can make the difference slightly
+// negative for equal items, so it is clamped at 0.
+func (p *parser) stddev(items []*ASTNode, start int) (*ASTNode, error) {
+	const name = "stddev"
+	if p.defs.complex {
+		return nil, fmt.Errorf("%s at position %d needs real numbers, it is not defined in complex mode", name, start)
+	}
+	squares := make([]*ASTNode, len(items))
+	for i, x := range items {
+		sq, err := p.operation(&ASTNode{Operator: "*", Left: x, Right: x}, name, start)
+		if err != nil {
+			return nil, err
+		}
+		squares[i] = sq
+	}
+	var means [2]*ASTNode
+	for i, terms := range [][]*ASTNode{items, squares} {
+		sum, err := p.reduce("+", terms, name, start)
+		if err != nil {
+			return nil, err
+		}
+		if means[i], err = p.mean(sum, len(items), name, start); err != nil {
+			return nil, err
+		}
+	}
+	meanSq, err := p.operation(&ASTNode{Operator: "*", Left: means[0], Right: means[0]}, name, start)
+	if err != nil {
+		return nil, err
+	}
+	variance, err := p.operation(&ASTNode{Operator: "-", Left: means[1], Right: meanSq}, name, start)
+	if err != nil {
+		return nil, err
+	}
+	zero := p.node(&ASTNode{IsLeaf: true, Dim: variance.Dim})
+	variance, err = p.operation(&ASTNode{Operator: "max", Left: variance, Right: zero}, name, start)
+	if err != nil {
+		return nil, err
+	}
+	return p.operation(&ASTNode{Operator: "sqrt", Left: variance}, name, start)
+}
+
+// median selects the middle item, or the mean of the two middle ones,
+// with Batcher's odd-even merge sort: a network of min and max
+// comparators whose every stage runs in parallel. Only the comparators
+// the middle wires depend on become nodes of the tree.
+func (p *parser) median(items []*ASTNode, start int) (*ASTNode, error) {
+	const name = "median"
+	n := len(items)
+	for _, x := range items[1:] {
+		if err := (&ASTNode{Operator: "min", Left: items[0], Right: x}).checkUnits(name, start); err != nil && !p.defs.skipUnits {
+			return nil, err
+		}
+	}
+	wires := slices.Clone(items)
+	for size := 1; size < n; size *= 2 {
+		for k := size; k >= 1; k /= 2 {
+			for j := k % size; j+k < n; j += 2 * k {
+				for i := j; i < j+k && i+k < n; i++ {
+					if i/(2*size) == (i+k)/(2*size) {
+						lo, hi := wires[i], wires[i+k]
+						wires[i] = &ASTNode{Operator: "min", Left: lo, Right: hi, Dim: lo.Dim}
+						wires[i+k] = &ASTNode{Operator: "max", Left: lo, Right: hi, Dim: lo.Dim}
+					}
+				}
+			}
+		}
+	}
+	// count the comparators that are used; the items are counted already
+	seen := map[*ASTNode]bool{}
+	for _, x := range items {
+		seen[x] = true
+	}
+	var use func(n *ASTNode)
+	use = func(n *ASTNode) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		p.node(n)
+		use(n.Left)
+		use(n.Right)
+	}
+	use(wires[n/2])
+	if n%2 == 1 {
+		return wires[n/2], nil
+	}
+	use(wires[n/2-1])
+	sum, err := p.operation(&ASTNode{Operator: "+", Left: wires[n/2-1], Right: wires[n/2]}, name, start)
+	if err != nil {
+		return nil, err
+	}
+	return p.mean(sum, 2, name, start)
+}
+
+// list parses a list literal "[a, b, ...]", which may be empty.
+func (p *parser) list() ([]*ASTNode, error) {
+	open := p.next().pos
+	var items []*ASTNode
+	if p.is("]") {
+		p.next()
+		return items, nil
+	}
+	for {
+		item, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	if !p.is("]") {
+		return nil, fmt.Errorf("missing closing bracket for [ at position %d", open)
+	}
+	p.next()
+	return items, nil
+}
+
+// call parses the arguments of a formula call and expands the formula.
+func (p *parser) call(name string, start int) (*ASTNode, error) {
+	def, ok := p.defs.defs[name]
+	if !ok || len(def.Params) == 0 {
+		return nil, fmt.Errorf("unknown formula %s at position %d", name, start)
+	}
+	args, err := p.arguments(p.next().pos)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(def.Params) {
+		return nil, fmt.Errorf("%s at position %d takes %d arguments, got %d", name, start, len(def.Params), len(args))
+	}
+	return p.expand(def, args)
+}
+
+// constant expands a constant once per expression.
+func (p *parser) constant(def *Definition) (*ASTNode, error) {
+	if node, ok := p.defs.consts[def.Name]; ok {
+		return node, nil
+	}
+	node, err := p.expand(def, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.defs.consts[def.Name] = node
+	return node, nil
+}
+
+// expand parses the body of a definition with args as its parameters.
+// The body sees its parameters and other definitions only.
+func (p *parser) expand(def *Definition, args []*ASTNode) (*ASTNode, error) {
+	e := p.defs
+	if slices.Contains(e.stack, def.Name) {
+		return nil, fmt.Errorf("definitions refer to each other: %s -> %s", strings.Join(e.stack, " -> "), def.Name)
+	}
+	if e.exceeded() {
+		return nil, fmt.Errorf("expression has more than %d nodes", e.maxNodes)
+	}
+	e.stack = append(e.stack, def.Name)
+	defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+	e.used[def.Name] = def
+
+	tokens, ok := e.tokens[def.Name]
+	if !ok {
+		var err error
+		if tokens, err = tokenize(def.Body); err != nil {
+			return nil, fmt.Errorf("in %s: %w", def.Name, err)
+		}
+		e.tokens[def.Name] = tokens
+	}
+	body := &parser{tokens: tokens, vars: make(map[string]*ASTNode, len(args)), defs: e}
+	for i, arg := range args {
+		body.vars[def.Params[i]] = arg
+	}
+	node, err := body.parseExpression()
+	if err == nil && body.peek().kind != tokEOF {
+		err = body.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("in %s: %w", def.Name, err)
+	}
+	if node.Name == "" && !slices.Contains(args, node) {
+		node.Name = def.Name
+	}
+	return node, nil
+}
+
+// CountNodes returns the number of distinct nodes in the tree rooted at
+// node. A node shared by several uses of a variable, or by the
+// comparators of median, counts once: it becomes a single task.
+func CountNodes(node *ASTNode) int {
+	seen := map[*ASTNode]bool{}
+	var count func(n *ASTNode)
+	count = func(n *ASTNode) {
+		if n == nil || seen[n] {
+			return
+		}
+		seen[n] = true
+		count(n.Cond)
+		count(n.Left)
+		count(n.Right)
+	}
+	count(node)
+	return len(seen)
+}
+
+// EvalAST computes the tree locally; shared nodes are computed once and
+// the operands a conditional does not take are not computed at all.
+func EvalAST(node *ASTNode) (float64, error) {
+	v, err := evalAST(node, false)
+	return real(v), err
+}
+
+// EvalComplexAST is EvalAST in complex mode.
+func EvalComplexAST(node *ASTNode) (complex128, error) {
+	return evalAST(node, true)
+}
+
+func evalAST(node *ASTNode, complexMode bool) (complex128, error) {
+	values := map[*ASTNode]complex128{}
+	var eval func(n *ASTNode) (complex128, error)
+	eval = func(n *ASTNode) (complex128, error) {
+		if n.IsLeaf {
+			return n.value(), nil
+		}
+		if v, ok := values[n]; ok {
+			return v, nil
+		}
+		var v complex128
+		if n.Conditional() {
+			cond, err := eval(n.Condition())
+			if err != nil {
+				return 0, err
+			}
+			var taken complex128
+			if next := n.Decide(cond); next != nil {
+				if taken, err = eval(next); err != nil {
+					return 0, err
+				}
+			}
+			v = n.Decided(cond, taken)
+		} else {
+			left, err := eval(n.Left)
+			if err != nil {
+				return 0, err
+			}
+			var right complex128
+			if n.Right != nil {
+				if right, err = eval(n.Right); err != nil {
+					return 0, err
+				}
+			}
+			if n.Operator == "/" && right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			if v, err = compute(n.Operator, left, right, complexMode); err != nil {
+				return 0, err
+			}
+		}
+		values[n] = v
+		return v, nil
+	}
+	return eval(node)
+}
+
+// value returns the number of a leaf.
+func (n *ASTNode) value() complex128 { return complex(n.Value, n.Imag) }
+
+// compute applies an operation the way an agent does: over complex
+// numbers in complex mode, otherwise over the real parts.
+func compute(op string, a, b complex128, complexMode bool) (complex128, error) {
+	if complexMode {
+		return calculation.ComputeComplex(op, a, b)
+	}
+	v, err := calculation.Compute(op, real(a), real(b))
+	return complex(v, 0), err
+}
+
+// FormatComplex renders a complex number as a+bi, e.g. 3+4i, 0-1i or
+// 2+0i, which strconv.ParseComplex reads back exactly.
+func FormatComplex(v complex128) string {
+	re := strconv.FormatFloat(real(v)+0, 'g', -1, 64)
+	im := strconv.FormatFloat(imag(v)+0, 'g', -1, 64)
+	if !strings.HasPrefix(im, "-") && !strings.HasPrefix(im, "+") {
+		im = "+" + im
+	}
+	return re + im + "i"
+}
+
+// finite reports whether v is neither infinite nor NaN.
+func finite(v complex128) bool { return !cmplx.IsInf(v) && !cmplx.IsNaN(v) }
@@ -0,0 +1,149 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateWindow is a per-user sliding-window request counter. The zero
+// value is ready to use.
+type rateWindow struct {
+	mu   sync.Mutex
+	hits map[int][]time.Time
+}
+
+// prune drops hits older than window and returns what is left.
+// The caller must hold rw.mu.
+func (rw *rateWindow) prune(uid int, window time.Duration, now time.Time) []time.Time {
+	hits := rw.hits[uid]
+	i := 0
+	for i < len(hits) && now.Sub(hits[i]) >= window {
+		i++
+	}
+	hits = hits[i:]
+	if len(hits) == 0 {
+		delete(rw.hits, uid)
+	} else {
+		rw.hits[uid] = hits
+	}
+	return hits
+}
+
+// allow records a hit for uid unless limit hits already happened within
+// window; in that case it reports how long until the oldest one expires.
+func (rw *rateWindow) allow(uid, limit int, window time.Duration, now time.Time) (bool, time.Duration) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.hits == nil {
+		rw.hits = make(map[int][]time.Time)
+	}
+	hits := rw.prune(uid, window, now)
+	if len(hits) >= limit {
+		return false, hits[0].Add(window).Sub(now)
+	}
+	rw.hits[uid] = append(hits, now)
+	return true, 0
+}
+
+func (rw *rateWindow) count(uid int, window time.Duration, now time.Time) int {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.hits == nil {
+		return 0
+	}
+	return len(rw.prune(uid, window, now))
+}
+
+func retryAfter(w http.ResponseWriter, d time.Duration) {
+	secs := int((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+func (o *Orchestrator) pendingCount(uid int) (int, error) {
+	var n int
+	err := o.DB.Get(&n, "SELECT COUNT(*) FROM expressions WHERE user_id=? AND status='pending'", uid)
+	return n, err
+}
+
+// allowRequest enforces RateLimitPerMinute for uid.
+func (o *Orchestrator) allowRequest(uid int) error {
+	ok, wait := o.rate.allow(uid, o.Config.RateLimitPerMinute, time.Minute, time.Now())
+	if !ok {
+		return &LimitError{CodeRateLimited, "rate limit exceeded", wait}
+	}
+	return nil
+}
+
+// userLocks holds a mutex per user. The zero value is ready to use.
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[int]*userLock
+}
+
+type userLock struct {
+	sync.Mutex
+	users int // holders and waiters; the lock is dropped at 0
+}
+
+// lock locks the mutex of uid and returns the function unlocking it.
+func (ul *userLocks) lock(uid int) func() {
+	ul.mu.Lock()
+	if ul.locks == nil {
+		ul.locks = make(map[int]*userLock)
+	}
+	l := ul.locks[uid]
+	if l == nil {
+		l = &userLock{}
+		ul.locks[uid] = l
+	}
+	l.users++
+	ul.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		ul.mu.Lock()
+		if l.users--; l.users == 0 {
+			delete(ul.locks, uid)
+		}
+		ul.mu.Unlock()
+	}
+}
+
+// checkPending enforces MaxPendingExpressions for uid, who is about to
+// add extra pending expressions. The caller must hold the pending lock
+// of uid until they are stored, so that concurrent submissions cannot
+// both pass the check.
+func (o *Orchestrator) checkPending(uid, extra int) error {
+	n, err := o.pendingCount(uid)
+	if err != nil {
+		return err
+	}
+	if n+extra > o.Config.MaxPendingExpressions {
+		return &LimitError{CodeTooManyPending, "too many pending expressions", time.Second}
+	}
+	return nil
+}
+
+// UsageHandler — GET /api/v1/me/usage
+func (o *Orchestrator) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	pending, err := o.pendingCount(uid)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	resp := UsageResp{
+		RequestsPerMinute:   UsageCounter{o.rate.count(uid, time.Minute, time.Now()), o.Config.RateLimitPerMinute},
+		PendingExpressions:  UsageCounter{pending, o.Config.MaxPendingExpressions},
+		MaxASTNodes:         o.Config.MaxASTNodes,
+		MaxExpressionLength: o.Config.MaxExpressionLength,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,306 @@
+package application
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// numberNodes assigns pre-order IDs to the operator nodes of the tree,
+// starting with 0 at the root, and returns the nodes in that order.
+// Leaves are not numbered: they never become tasks. A node shared by
+// several uses of a variable is numbered once, at its first use.
+func numberNodes(root *ASTNode) []*ASTNode {
+	var nodes []*ASTNode
+	seen := map[*ASTNode]bool{}
+	var walk func(n *ASTNode)
+	walk = func(n *ASTNode) {
+		if n == nil || n.IsLeaf || seen[n] {
+			return
+		}
+		seen[n] = true
+		n.ID = len(nodes)
+		nodes = append(nodes, n)
+		walk(n.Cond)
+		walk(n.Left)
+		walk(n.Right)
+	}
+	walk(root)
+	return nodes
+}
+
+// needed returns the nodes of a numbered tree that must become tasks so
+// far, in pre-order: every operation, except that a conditional needs
+// only its condition until that is known, and then the operand it
+// takes. The operands it does not take are never computed.
+func needed(root *ASTNode, tasks map[int]*taskRow) []*ASTNode {
+	var nodes []*ASTNode
+	seen := map[*ASTNode]bool{}
+	var walk func(n *ASTNode)
+	walk = func(n *ASTNode) {
+		if n == nil || n.IsLeaf || seen[n] {
+			return
+		}
+		seen[n] = true
+		if !n.Conditional() {
+			nodes = append(nodes, n)
+			walk(n.Left)
+			walk(n.Right)
+			return
+		}
+		walk(n.Condition())
+		if cond, ok := operand(n.Condition(), tasks); ok {
+			walk(n.Decide(cond))
+		}
+	}
+	walk(root)
+	return nodes
+}
+
+type taskRow struct {
+	ID            string        `db:"id"`
+	Node          sql.NullInt64 `db:"node"`
+	Arg1          float64       `db:"arg1"`
+	Arg2          float64       `db:"arg2"`
+	Operation     string        `db:"operation"`
+	OperationTime int           `db:"operation_time"`
+	InProgress    bool          `db:"in_progress"`
+	Done          bool          `db:"done"`
+	Result        *float64      `db:"result"`
+	ResultImag    float64       `db:"result_imag"`
+	AgentID       *string       `db:"agent_id"`
+	QueuedAt      *Millis       `db:"queued_at"`
+	StartedAt     *Millis       `db:"started_at"`
+	FinishedAt    *Millis       `db:"finished_at"`
+}
+
+// exprTasks returns the tasks of an expression keyed by AST node.
+func (o *Orchestrator) exprTasks(db sqlx.Queryer, exprID int64) (map[int]*taskRow, error) {
+	var rows []*taskRow
+	err := sqlx.Select(db, &rows, `
+        SELECT id, node, arg1, arg2, operation, operation_time, in_progress, done,
+               result, result_imag, agent_id, queued_at, started_at, finished_at
+          FROM tasks
+         WHERE expr_id = ? AND node IS NOT NULL`, exprID)
+	if err != nil {
+		return nil, err
+	}
+	tasks := make(map[int]*taskRow, len(rows))
+	for _, t := range rows {
+		tasks[int(t.Node.Int64)] = t
+	}
+	return tasks, nil
+}
+
+// operand returns the value of n if it is already known: either n is a
+// literal, its task is done, or it is a conditional whose condition and
+// taken operand are known. The missing Right of a unary node is 0.
+// Conditionals shared by several uses are decided once.
+func operand(n *ASTNode, tasks map[int]*taskRow) (complex128, bool) {
+	type known struct {
+		v  complex128
+		ok bool
+	}
+	memo := map[*ASTNode]known{}
+	var value func(n *ASTNode) (complex128, bool)
+	value = func(n *ASTNode) (complex128, bool) {
+		if n == nil {
+			return 0, true
+		}
+		if n.IsLeaf {
+			return n.value(), true
+		}
+		if !n.Conditional() {
+			if t, ok := tasks[n.ID]; ok && t.Done && t.Result != nil {
+				return complex(*t.Result, t.ResultImag), true
+			}
+			return 0, false
+		}
+		if k, ok := memo[n]; ok {
+			return k.v, k.ok
+		}
+		var k known
+		if cond, ok := value(n.Condition()); ok {
+			var taken complex128
+			ok = true
+			if next := n.Decide(cond); next != nil {
+				taken, ok = value(next)
+			}
+			if ok {
+				k = known{n.Decided(cond, taken), true}
+			}
+		}
+		memo[n] = k
+		return k.v, k.ok
+	}
+	return value(n)
+}
+
+func (o *Orchestrator) operationTime(op string) int {
+	switch op {
+	case "+":
+		return o.Config.TimeAddition
+	case "-":
+		return o.Config.TimeSubtraction
+	case "*":
+		return o.Config.TimeMultiplications
+	case "/":
+		return o.Config.TimeDivisions
+	case "^", "sqrt":
+		return o.Config.TimeExponentiations
+	}
+	// unary operators, the other functions, comparisons, min and max are
+	// cheap and take no extra time
+	return 0
+}
+
+// scheduleTasksDB queues a task for every needed operator node of ast
+// whose operands are known and which has no task yet. It is called once on
+// submission and again after every result, so the tree is computed
+// bottom-up with independent branches running in parallel. Each task
+// keeps the trace context of its scheduling span, so the agent that
+// takes it continues the same trace.
+func (o *Orchestrator) scheduleTasksDB(ctx context.Context, db sqlx.Ext, exprID int64, ast *ASTNode) error {
+	numberNodes(ast)
+	tasks, err := o.exprTasks(db, exprID)
+	if err != nil {
+		return err
+	}
+	now := NowMillis()
+	for _, n := range needed(ast, tasks) {
+		if _, ok := tasks[n.ID]; ok {
+			continue
+		}
+		a, ok1 := operand(n.Left, tasks)
+		b, ok2 := operand(n.Right, tasks)
+		if !ok1 || !ok2 {
+			continue
+		}
+		id := fmt.Sprintf("%d-%d", exprID, n.ID)
+		sctx, span := tracer().Start(ctx, "schedule task", trace.WithAttributes(
+			attribute.String("task.id", id),
+			attribute.String("task.operation", n.Operator),
+		))
+		// siblings finishing at once may both get here; the unique
+		// (expr_id, node) pair keeps one of them
+		_, err := db.Exec(
+			`INSERT OR IGNORE INTO tasks
+             (id, expr_id, node, arg1, arg1_imag, arg2, arg2_imag, operation, operation_time, queued_at, trace_parent)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, exprID, n.ID, real(a), imag(a), real(b), imag(b), n.Operator, o.operationTime(n.Operator), now, nullString(traceParent(sctx)),
+		)
+		span.End()
+		if err != nil {
+			return err
+		}
+		slog.DebugContext(ctx, "task queued", "task_id", id, "operation", n.Operator)
+	}
+	return nil
+}
+
+// finishIfDone stores the result of the root task as the result of the
+// expression once it is available, and queues its webhook if it has one.
+func (o *Orchestrator) finishIfDone(exprID int64, ast *ASTNode, complexMode bool) (bool, error) {
+	numberNodes(ast)
+	tx, err := o.DB.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	tasks, err := o.exprTasks(tx, exprID)
+	if err != nil {
+		return false, err
+	}
+	result, ok := operand(ast, tasks)
+	if !ok {
+		return false, nil
+	}
+	now := NowMillis()
+	r, c := valueFields(result, complexMode)
+	res, err := tx.Exec(
+		"UPDATE expressions SET status = ?, result = ?, complex_result = ?, updated_at = ?, finished_at = ? WHERE id = ? AND status = ?",
+		"done", r, c, now, now, exprID, "pending",
+	)
+	if err != nil {
+		return true, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		if err := enqueueWebhook(tx, exprID); err != nil {
+			return true, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// valueFields returns how a value is stored and shown: as a number
+// while it is real, and as a+bi in complex mode, like the result and
+// complex_result columns of an expression.
+func valueFields(v complex128, complexMode bool) (*float64, *string) {
+	var r *float64
+	if imag(v) == 0 {
+		re := real(v)
+		r = &re
+	}
+	if !complexMode {
+		return r, nil
+	}
+	c := FormatComplex(v)
+	return r, &c
+}
+
+// advance moves an expression forward after one of its tasks is done:
+// it either stores the final result or queues the nodes that became ready.
+func (o *Orchestrator) advance(ctx context.Context, exprID int64) {
+	var e struct {
+		Expr        string  `db:"expr"`
+		Variables   *string `db:"variables"`
+		Definitions *string `db:"definitions"`
+		Complex     bool    `db:"complex"`
+		Status      string  `db:"status"`
+	}
+	if err := o.DB.Get(&e, "SELECT expr, variables, definitions, complex, status FROM expressions WHERE id = ?", exprID); err != nil {
+		slog.ErrorContext(ctx, "expression of a finished task not found", "error", err)
+		return
+	}
+	// cancelled while the agent was computing
+	if e.Status != "pending" {
+		return
+	}
+	ast, err := parseStored(e.Expr, e.Variables, e.Definitions, e.Complex)
+	if err != nil {
+		slog.ErrorContext(ctx, "cannot parse stored expression", "error", err)
+		return
+	}
+	done, err := o.finishIfDone(exprID, ast, e.Complex)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to update expression", "error", err)
+		return
+	}
+	if done {
+		slog.InfoContext(ctx, "expression done")
+		return
+	}
+	if err := o.scheduleTasksDB(ctx, o.DB, exprID, ast); err != nil {
+		slog.ErrorContext(ctx, "failed to schedule tasks", "error", err)
+	}
+}
+
+// agentIDKey is the gRPC metadata key agents identify themselves with.
+const agentIDKey = "agent-id"
+
+func agentID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(agentIDKey); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
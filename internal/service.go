@@ -0,0 +1,171 @@
+package application
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Errors of the operations shared by the HTTP and gRPC APIs; each front
+// end maps them to its own status codes.
+var (
+	ErrUserExists   = errors.New("user exists")
+	ErrInvalidCreds = errors.New("invalid creds")
+	ErrNotFound     = errors.New("not found")
+	ErrNotPending   = errors.New("expression is not pending")
+)
+
+// InvalidInputError rejects a malformed request field.
+type InvalidInputError struct{ Err error }
+
+func (e *InvalidInputError) Error() string { return e.Err.Error() }
+func (e *InvalidInputError) Unwrap() error { return e.Err }
+
+// InvalidExpressionError rejects an expression that cannot be computed.
+type InvalidExpressionError struct{ Err error }
+
+func (e *InvalidExpressionError) Error() string { return e.Err.Error() }
+func (e *InvalidExpressionError) Unwrap() error { return e.Err }
+
+// LimitError reports an exhausted quota; the request may succeed again
+// after RetryAfter. Code is the ErrorBody code of the quota.
+type LimitError struct {
+	Code       string
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string { return e.Reason }
+
+func (o *Orchestrator) Register(login, password string) error {
+	if err := ValidateCredentials(login, password); err != nil {
+		return &InvalidInputError{err}
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := o.DB.Exec("INSERT INTO users(login,password_hash) VALUES(?,?)", login, hash); err != nil {
+		return ErrUserExists
+	}
+	return nil
+}
+
+// Login checks the credentials and returns a fresh token.
+func (o *Orchestrator) Login(login, password string) (string, error) {
+	var u account
+	err := o.DB.Get(&u, "SELECT id,password_hash,failed_attempts,locked_until,token_version FROM users WHERE login=?", login)
+	if err != nil {
+		return "", ErrInvalidCreds
+	}
+	if wait := u.lockedFor(time.Now()); wait > 0 {
+		return "", &LimitError{CodeAccountLocked, "account temporarily locked", wait}
+	}
+	if err := CheckPassword(u.PasswordHash, password); err != nil {
+		o.recordFailedLogin(u.ID)
+		return "", ErrInvalidCreds
+	}
+	o.resetFailedLogins(u.ID)
+	return CreateToken(u.ID, u.TokenVersion)
+}
+
+// Calculate validates an expression against the user's quotas, stores
+// it with its variables and the definitions it uses and queues its
+// first tasks. An empty callback means no webhook. complexMode computes
+// it over complex numbers.
+func (o *Orchestrator) Calculate(ctx context.Context, uid int, expr string, vars map[string]float64, callback string, complexMode bool) (int64, error) {
+	ctx, span := tracer().Start(ctx, "Calculate")
+	defer span.End()
+	if err := o.allowRequest(uid); err != nil {
+		return 0, err
+	}
+	if callback != "" {
+		if err := o.checkCallbackURL(ctx, callback); err != nil {
+			return 0, &InvalidInputError{err}
+		}
+	}
+	if err := o.validateVariables(vars); err != nil {
+		return 0, &InvalidInputError{err}
+	}
+	defs, err := definitions(o.DB, uid)
+	if err != nil {
+		return 0, err
+	}
+	p, err := o.prepareExpression(expr, vars, defs, complexMode)
+	if err != nil {
+		return 0, &InvalidExpressionError{err}
+	}
+	if !p.immediate() {
+		unlock := o.pending.lock(uid)
+		defer unlock()
+		if err := o.checkPending(uid, 1); err != nil {
+			return 0, err
+		}
+	}
+	id, err := o.insertExpression(ctx, o.DB, uid, p, callback)
+	if err != nil {
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int64("expression.id", id))
+	slog.InfoContext(ctx, "expression created", "expr_id", id, "nodes", CountNodes(p.ast))
+	return id, nil
+}
+
+func (o *Orchestrator) GetExpression(uid int, id int64) (*Expression, error) {
+	var e Expression
+	err := o.DB.Get(&e, `
+        SELECT id, expr, status, complex, result, complex_result, unit, created_at, updated_at, finished_at
+          FROM expressions
+         WHERE user_id = ? AND id = ?`, uid, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// CancelExpression stops a pending expression: its queued tasks are
+// dropped and results of tasks agents are still computing are ignored.
+// A webhook, if any, reports the cancelled status.
+func (o *Orchestrator) CancelExpression(ctx context.Context, uid int, id int64) (*Expression, error) {
+	tx, err := o.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var status string
+	err = tx.Get(&status, "SELECT status FROM expressions WHERE user_id = ? AND id = ?", uid, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "pending" {
+		return nil, ErrNotPending
+	}
+	now := NowMillis()
+	if _, err := tx.Exec(
+		"UPDATE expressions SET status = ?, updated_at = ?, finished_at = ? WHERE id = ?",
+		"cancelled", now, now, id,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM tasks WHERE expr_id = ? AND in_progress = 0 AND done = 0", id); err != nil {
+		return nil, err
+	}
+	if err := enqueueWebhook(tx, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "expression cancelled", "expr_id", id)
+	return o.GetExpression(uid, id)
+}
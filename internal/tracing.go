@@ -0,0 +1,133 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+const tracerName = "github.com/lollmark/digital_calc"
+
+// traceParentKey is the W3C header and gRPC metadata key the trace
+// context travels in; tasks keep the same value in trace_parent.
+const traceParentKey = "traceparent"
+
+// propagator is fixed rather than global so the context stored with
+// tasks keeps its format whatever the process configured.
+var propagator = propagation.TraceContext{}
+
+// tracer is looked up on every use so a provider installed later, e.g.
+// by a test, takes effect.
+func tracer() trace.Tracer { return otel.Tracer(tracerName) }
+
+// SetupTracing installs the exporter selected by OTEL_TRACES_EXPORTER:
+// "otlp" sends spans over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT,
+// "stdout" prints them, and "none" or unset disables tracing. The
+// returned function flushes the remaining spans.
+func SetupTracing(ctx context.Context, service string) (func(context.Context) error, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch kind := os.Getenv("OTEL_TRACES_EXPORTER"); kind {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exp, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return tp.Shutdown, nil
+}
+
+// traceParent returns the traceparent of the span in ctx, or "" if
+// there is none.
+func traceParent(ctx context.Context) string {
+	c := propagation.MapCarrier{}
+	propagator.Inject(ctx, c)
+	return c[traceParentKey]
+}
+
+// withTraceParent makes tp the remote parent of the spans started from
+// the returned context.
+func withTraceParent(ctx context.Context, tp string) context.Context {
+	if tp == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{traceParentKey: tp})
+}
+
+// incomingTraceParent reads the traceparent a gRPC client sent.
+func incomingTraceParent(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(traceParentKey); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// nullString keeps empty strings, like a missing trace context or unit,
+// out of the db.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.status = code
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// traceHTTP starts a server span for every request of a route, continuing
+// the trace of the caller if it sent a traceparent header.
+func traceHTTP(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+			))
+		defer span.End()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+		if sw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package application
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Dimension is the power of every SI base unit in a quantity, in the
+// order of baseUnits; the zero Dimension is a plain number. Quantities
+// are computed in base units, so 1 km is the number 1000 of dimension m.
+type Dimension [7]int
+
+// baseUnits are the SI base units, in the order a Dimension is written.
+var baseUnits = [7]string{"kg", "m", "s", "A", "K", "mol", "cd"}
+
+// units are the units a number may be written with: their size in base
+// units and their dimension.
+var units = map[string]struct {
+	scale float64
+	dim   Dimension
+}{
+	"kg":  {1, Dimension{1}},
+	"g":   {1e-3, Dimension{1}},
+	"mg":  {1e-6, Dimension{1}},
+	"km":  {1e3, Dimension{0, 1}},
+	"m":   {1, Dimension{0, 1}},
+	"cm":  {1e-2, Dimension{0, 1}},
+	"mm":  {1e-3, Dimension{0, 1}},
+	"h":   {3600, Dimension{0, 0, 1}},
+	"min": {60, Dimension{0, 0, 1}},
+	"s":   {1, Dimension{0, 0, 1}},
+	"ms":  {1e-3, Dimension{0, 0, 1}},
+	"A":   {1, Dimension{0, 0, 0, 1}},
+	"K":   {1, Dimension{0, 0, 0, 0, 1}},
+	"mol": {1, Dimension{0, 0, 0, 0, 0, 1}},
+	"cd":  {1, Dimension{0, 0, 0, 0, 0, 0, 1}},
+	"L":   {1e-3, Dimension{0, 3}},
+	"mL":  {1e-6, Dimension{0, 3}},
+	"Hz":  {1, Dimension{0, 0, -1}},
+	"N":   {1, Dimension{1, 1, -2}},
+	"Pa":  {1, Dimension{1, -1, -2}},
+	"kPa": {1e3, Dimension{1, -1, -2}},
+	"J":   {1, Dimension{1, 2, -2}},
+	"kJ":  {1e3, Dimension{1, 2, -2}},
+	"W":   {1, Dimension{1, 2, -3}},
+	"kW":  {1e3, Dimension{1, 2, -3}},
+	"C":   {1, Dimension{0, 0, 1, 1}},
+	"V":   {1, Dimension{1, 2, -3, -1}},
+}
+
+func (d Dimension) add(e Dimension, times int) Dimension {
+	for i := range d {
+		d[i] += e[i] * times
+	}
+	return d
+}
+
+// pow returns d raised to r, or false if some base unit would get a
+// fractional power, like m^0.5.
+func (d Dimension) pow(r float64) (Dimension, bool) {
+	for i := range d {
+		p := float64(d[i]) * r
+		if p != math.Trunc(p) || math.Abs(p) > math.MaxInt32 {
+			return Dimension{}, false
+		}
+		d[i] = int(p)
+	}
+	return d, true
+}
+
+// String writes d in base units the way a number may be written with
+// them, e.g. kg*m/s^2 or s^-1; it is empty for a plain number.
+func (d Dimension) String() string {
+	var num, den []string
+	for i, p := range d {
+		switch {
+		case p == 1:
+			num = append(num, baseUnits[i])
+		case p > 1:
+			num = append(num, baseUnits[i]+"^"+strconv.Itoa(p))
+		case p == -1:
+			den = append(den, baseUnits[i])
+		case p < -1:
+			den = append(den, baseUnits[i]+"^"+strconv.Itoa(-p))
+		}
+	}
+	if len(num) == 0 {
+		for i := range den {
+			if u, p, ok := strings.Cut(den[i], "^"); ok {
+				den[i] = u + "^-" + p
+			} else {
+				den[i] += "^-1"
+			}
+		}
+		return strings.Join(den, "*")
+	}
+	return strings.Join(append([]string{strings.Join(num, "*")}, den...), "/")
+}
+
+// describe names d in errors.
+func (d Dimension) describe() string {
+	if d == (Dimension{}) {
+		return "a plain number"
+	}
+	return d.String()
+}
+
+// unit parses the unit written after a number: names of units joined by
+// * and /, each with an optional whole power, as in kg*m/s^2 or m^-1.
+// After the first name, * and / continue the unit only if a unit name
+// follows that no variable or definition hides and that is not called,
+// so 5 m / 2 s is (5 m) / (2 s). It returns the size of the unit in
+// base units and its dimension.
+func (p *parser) unit() (float64, Dimension, error) {
+	scale, dim, sign := 1.0, Dimension{}, 1
+	start := p.peek().pos
+	for {
+		t := p.next()
+		u, ok := units[t.text]
+		if !ok {
+			return 0, Dimension{}, fmt.Errorf("unknown unit %s at position %d", t.text, t.pos)
+		}
+		power, err := p.unitPower()
+		if err != nil {
+			return 0, Dimension{}, err
+		}
+		scale *= math.Pow(u.scale, float64(sign*power))
+		// a large power of a prefixed unit, like km^200, overflows
+		if scale == 0 || math.IsInf(scale, 0) {
+			return 0, Dimension{}, fmt.Errorf("unit at position %d is out of range", start)
+		}
+		dim = dim.add(u.dim, sign*power)
+		if !(p.is("*") || p.is("/")) || !p.unitAt(p.pos+1) {
+			return scale, dim, nil
+		}
+		sign = 1
+		if p.next().text == "/" {
+			sign = -1
+		}
+	}
+}
+
+// maxUnitPower is the largest power a unit name may have.
+const maxUnitPower = 1000
+
+// unitPower parses the power after a unit name, 1 if there is none. A ^
+// followed by anything but a whole number, maybe negative, is left to
+// parsePower: 5 m^x is (5 m)^x.
+func (p *parser) unitPower() (int, error) {
+	if !p.is("^") {
+		return 1, nil
+	}
+	at := p.pos + 1
+	if p.tokens[at].kind == tokOp && p.tokens[at].text == "-" {
+		at++
+	}
+	t := p.tokens[at]
+	if t.kind != tokNumber {
+		return 1, nil
+	}
+	if t.imag || t.value != math.Trunc(t.value) {
+		return 0, fmt.Errorf("power %s of a unit at position %d must be a whole number", t.text, t.pos)
+	}
+	if t.value > maxUnitPower {
+		return 0, fmt.Errorf("power %s of a unit at position %d is out of range, the limit is %d", t.text, t.pos, maxUnitPower)
+	}
+	power := int(t.value)
+	if at > p.pos+1 {
+		power = -power
+	}
+	p.pos = at + 1
+	return power, nil
+}
+
+// unitAt reports whether the token at i is a unit name that continues a
+// unit.
+func (p *parser) unitAt(i int) bool {
+	_, ok := units[p.tokens[i].text]
+	return ok && p.unitName(i)
+}
+
+// unitName reports whether the identifier at i may name a unit: one
+// that no variable, list or definition takes, that is not the imaginary
+// unit i and that is not called, so in 5 min(1, 2) min is not minutes.
+func (p *parser) unitName(i int) bool {
+	t := p.tokens[i]
+	if t.kind != tokIdent || t.text == "i" {
+		return false
+	}
+	_, isVar := p.vars[t.text]
+	_, isList := p.lists[t.text]
+	_, isDef := p.defs.defs[t.text]
+	next := p.tokens[i+1]
+	return !isVar && !isList && !isDef && !(next.kind == tokOp && next.text == "(")
+}
+
+// checkUnits works out the dimension of a new operation node from its
+// operands, or rejects operands that do not fit it: m + s, 2^(1 m) or
+// sqrt(1 m). what and pos name the operator in errors.
+func (n *ASTNode) checkUnits(what string, pos int) error {
+	l, r := n.Left.Dim, Dimension{}
+	if n.Right != nil {
+		r = n.Right.Dim
+	}
+	switch n.Operator {
+	case "+", "-", "min", "max", OpIf, "<", "<=", ">", ">=", "==", "!=":
+		if l != r {
+			return fmt.Errorf("%s at position %d needs operands of the same dimension, got %s and %s", what, pos, l.describe(), r.describe())
+		}
+		if slices.Contains(comparisons, n.Operator) {
+			return nil
+		}
+		n.Dim = l
+	case "*":
+		n.Dim = l.add(r, 1)
+	case "/":
+		n.Dim = l.add(r, -1)
+	case "^", "sqrt":
+		if r != (Dimension{}) {
+			return fmt.Errorf("exponent at position %d must be a plain number, got %s", pos, r.describe())
+		}
+		if l == (Dimension{}) {
+			return nil
+		}
+		e := 0.5
+		if n.Operator == "^" {
+			if !n.Right.IsLeaf || n.Right.Imag != 0 {
+				return fmt.Errorf("%s at position %d raises %s to a power that is not a constant", what, pos, l)
+			}
+			e = n.Right.Value
+		}
+		dim, ok := l.pow(e)
+		if !ok {
+			return fmt.Errorf("%s at position %d gives a fractional power of %s", what, pos, l)
+		}
+		n.Dim = dim
+	case OpNeg, "abs", "conj", "re", "im":
+		n.Dim = l
+	}
+	// the others, like ! and arg, give plain numbers
+	return nil
+}
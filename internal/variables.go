@@ -0,0 +1,61 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+)
+
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateVariables checks the variables of a calculate request; there
+// may be no more of them than AST nodes allowed in an expression.
+func (o *Orchestrator) validateVariables(vars map[string]float64) error {
+	if len(vars) > o.Config.MaxASTNodes {
+		return fmt.Errorf("more than %d variables", o.Config.MaxASTNodes)
+	}
+	for name, v := range vars {
+		if !variableName.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("variable %s is not a finite number", name)
+		}
+	}
+	return nil
+}
+
+// encodeVariables returns the variables as stored with an expression,
+// nil if there are none.
+func encodeVariables(vars map[string]float64) (*string, error) {
+	if len(vars) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// parseStored parses a stored expression with its stored variables and
+// the versions of the definitions it was submitted with, in the mode it
+// was submitted in.
+func parseStored(expr string, vars, defs *string, complexMode bool) (*ASTNode, error) {
+	var m map[string]float64
+	if vars != nil {
+		if err := json.Unmarshal([]byte(*vars), &m); err != nil {
+			return nil, err
+		}
+	}
+	var d map[string]*Definition
+	if defs != nil {
+		if err := json.Unmarshal([]byte(*defs), &d); err != nil {
+			return nil, err
+		}
+	}
+	ast, _, err := ParseASTInScope(expr, Scope{Vars: m, Defs: d, Complex: complexMode})
+	return ast, err
+}
@@ -0,0 +1,241 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	webhookTimeout     = 10 * time.Second
+	webhookBaseBackoff = time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookPollEvery   = time.Second
+	webhookBatch       = 20
+)
+
+var (
+	// webhookClient checks every address it dials, so a callback host
+	// that resolves differently at delivery, or a redirect, cannot
+	// reach an internal address either.
+	webhookClient        = newWebhookClient(false)
+	webhookClientPrivate = newWebhookClient(true)
+)
+
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+				return fmt.Errorf("callback address %s is not allowed", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{Timeout: webhookTimeout, Transport: &http.Transport{DialContext: dialer.DialContext}}
+}
+
+// internalIP reports whether ip is a loopback, private, link-local or
+// unspecified address, which callbacks must not reach.
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// WebhookPayload is the body POSTed to an expression's callback URL.
+type WebhookPayload struct {
+	ID            int64    `json:"id"`
+	Status        string   `json:"status"`
+	Result        *float64 `json:"result,omitempty"`
+	ComplexResult *string  `json:"complex_result,omitempty"`
+	Unit          *string  `json:"unit,omitempty"`
+}
+
+// ValidateCallbackURL accepts absolute http and https URLs.
+func ValidateCallbackURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("callback_url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// checkCallbackURL validates s with ValidateCallbackURL and, unless
+// Config.WebhookAllowPrivate is set, resolves its host and rejects it
+// if any of its addresses is internal.
+func (o *Orchestrator) checkCallbackURL(ctx context.Context, s string) error {
+	if err := ValidateCallbackURL(s); err != nil {
+		return err
+	}
+	if o.Config.WebhookAllowPrivate {
+		return nil
+	}
+	host := s
+	if u, err := url.Parse(s); err == nil {
+		host = u.Hostname()
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("callback_url host %s cannot be resolved", host)
+	}
+	for _, a := range addrs {
+		if internalIP(a.IP) {
+			return errors.New("callback_url must not point to a loopback, private or link-local address")
+		}
+	}
+	return nil
+}
+
+// SignWebhook returns the X-Signature-256 header value for body:
+// "sha256=" and the hex HMAC-SHA256 of the body under secret.
+func SignWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// enqueueWebhook records a delivery for a finished expression if it has
+// a callback URL. It runs in the transaction that finishes the
+// expression, so a restart cannot lose the notification.
+func enqueueWebhook(db sqlx.Ext, exprID int64) error {
+	var e struct {
+		Status        string   `db:"status"`
+		Result        *float64 `db:"result"`
+		ComplexResult *string  `db:"complex_result"`
+		Unit          *string  `db:"unit"`
+		CallbackURL   *string  `db:"callback_url"`
+	}
+	if err := sqlx.Get(db, &e, "SELECT status, result, complex_result, unit, callback_url FROM expressions WHERE id = ?", exprID); err != nil {
+		return err
+	}
+	if e.CallbackURL == nil {
+		return nil
+	}
+	payload, err := json.Marshal(WebhookPayload{ID: exprID, Status: e.Status, Result: e.Result, ComplexResult: e.ComplexResult, Unit: e.Unit})
+	if err != nil {
+		return err
+	}
+	now := NowMillis()
+	_, err = db.Exec(
+		`INSERT INTO webhook_deliveries(expr_id, url, payload, next_attempt_at, created_at)
+         VALUES (?, ?, ?, ?, ?)`,
+		exprID, *e.CallbackURL, string(payload), now, now,
+	)
+	return err
+}
+
+// webhookBackoff is the delay after the given number of failed attempts.
+func webhookBackoff(attempts int) time.Duration {
+	d := webhookBaseBackoff
+	for i := 1; i < attempts && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, webhookMaxBackoff)
+}
+
+type webhookDelivery struct {
+	ID       int64  `db:"id"`
+	ExprID   int64  `db:"expr_id"`
+	URL      string `db:"url"`
+	Payload  string `db:"payload"`
+	Attempts int    `db:"attempts"`
+}
+
+func (o *Orchestrator) sendWebhook(ctx context.Context, d webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Signature-256", SignWebhook([]byte(o.Config.WebhookSecret), []byte(d.Payload)))
+	client := webhookClient
+	if o.Config.WebhookAllowPrivate {
+		client = webhookClientPrivate
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return nil
+}
+
+// DeliverWebhooks makes one attempt at every delivery that is due and
+// returns how many succeeded. Failed ones are retried with exponential
+// backoff until Config.WebhookMaxAttempts is reached.
+func (o *Orchestrator) DeliverWebhooks(ctx context.Context) (int, error) {
+	var due []webhookDelivery
+	err := o.DB.Select(&due, `
+        SELECT id, expr_id, url, payload, attempts
+          FROM webhook_deliveries
+         WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?
+         ORDER BY next_attempt_at
+         LIMIT ?`, NowMillis(), webhookBatch)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, d := range due {
+		err := o.sendWebhook(ctx, d)
+		now := NowMillis()
+		attempts := d.Attempts + 1
+		switch {
+		case err == nil:
+			sent++
+			_, err = o.DB.Exec(
+				"UPDATE webhook_deliveries SET attempts = ?, delivered_at = ?, last_error = NULL WHERE id = ?",
+				attempts, now, d.ID)
+		case attempts >= o.Config.WebhookMaxAttempts:
+			slog.WarnContext(ctx, "webhook failed for good", "webhook_id", d.ID, "expr_id", d.ExprID, "url", d.URL, "attempts", attempts, "error", err)
+			_, err = o.DB.Exec(
+				"UPDATE webhook_deliveries SET attempts = ?, failed_at = ?, last_error = ? WHERE id = ?",
+				attempts, now, err.Error(), d.ID)
+		default:
+			next := now + Millis(webhookBackoff(attempts).Milliseconds())
+			_, err = o.DB.Exec(
+				"UPDATE webhook_deliveries SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?",
+				attempts, next, err.Error(), d.ID)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// RunWebhooks delivers webhooks until ctx is done.
+func (o *Orchestrator) RunWebhooks(ctx context.Context) {
+	t := time.NewTicker(webhookPollEvery)
+	defer t.Stop()
+	for {
+		if _, err := o.DeliverWebhooks(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to deliver webhooks", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -1,27 +1,139 @@
-package calculation
-
-import (
-	"fmt"
-)
-
-func Calc(expression string) (float64, error) {
-	return 0, fmt.Errorf("not implemented")
-}
-
-func Compute(operation string, a, b float64) (float64, error) {
-	switch operation {
-	case "+":
-		return a + b, nil
-	case "-":
-		return a - b, nil
-	case "*":
-		return a * b, nil
-	case "/":
-		if b == 0 {
-			return 0, ErrDivisionByZero
-		}
-		return a / b, nil
-	default:
-		return 0, fmt.Errorf("invalid operator: %s", operation)
-	}
-}
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+func Calc(expression string) (float64, error) {
+	return 0, fmt.Errorf("not implemented")
+}
+
+// Compute applies an operation of an expression tree. The unary "neg",
+// "!" and functions ignore b. Comparisons and "!" give 1 for true and 0
+// for false.
+func Compute(operation string, a, b float64) (float64, error) {
+	switch operation {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return a / b, nil
+	case "^":
+		v := math.Pow(a, b)
+		if math.IsNaN(v) {
+			return 0, ErrNotReal
+		}
+		return v, nil
+	case "neg":
+		return -a, nil
+	case "sqrt":
+		if a < 0 {
+			return 0, ErrNotReal
+		}
+		return math.Sqrt(a), nil
+	case "abs":
+		return math.Abs(a), nil
+	case "conj", "re":
+		return a, nil
+	case "im":
+		return 0, nil
+	case "arg":
+		if a < 0 {
+			return math.Pi, nil
+		}
+		return 0, nil
+	case "!":
+		return boolean(a == 0), nil
+	case "min":
+		return math.Min(a, b), nil
+	case "max":
+		return math.Max(a, b), nil
+	case "<":
+		return boolean(a < b), nil
+	case "<=":
+		return boolean(a <= b), nil
+	case ">":
+		return boolean(a > b), nil
+	case ">=":
+		return boolean(a >= b), nil
+	case "==":
+		return boolean(a == b), nil
+	case "!=":
+		return boolean(a != b), nil
+	default:
+		return 0, fmt.Errorf("invalid operator: %s", operation)
+	}
+}
+
+// ComputeComplex is Compute over complex numbers, so that the square
+// root or a fractional power of a negative number is not an error.
+// Operations on real operands give the same results as Compute; only
+// "==" and "!=" compare operands that are not real.
+func ComputeComplex(operation string, a, b complex128) (complex128, error) {
+	// no negative zeros: the square root of -4-0i would be -2i
+	a, b = a+0, b+0
+	switch operation {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return a / b, nil
+	case "^":
+		// cmplx.Pow goes through logarithms and would make 2^10 inexact
+		if imag(a) == 0 && imag(b) == 0 {
+			if v := math.Pow(real(a), real(b)); !math.IsNaN(v) {
+				return complex(v, 0), nil
+			}
+		}
+		return cmplx.Pow(a, b), nil
+	case "neg":
+		return -a, nil
+	case "sqrt":
+		return cmplx.Sqrt(a), nil
+	case "abs":
+		return complex(cmplx.Abs(a), 0), nil
+	case "conj":
+		return cmplx.Conj(a), nil
+	case "arg":
+		return complex(cmplx.Phase(a), 0), nil
+	case "re":
+		return complex(real(a), 0), nil
+	case "im":
+		return complex(imag(a), 0), nil
+	case "!":
+		return complex(boolean(a == 0), 0), nil
+	case "==":
+		return complex(boolean(a == b), 0), nil
+	case "!=":
+		return complex(boolean(a != b), 0), nil
+	case "<", "<=", ">", ">=", "min", "max":
+		if imag(a) != 0 || imag(b) != 0 {
+			return 0, ErrNotOrdered
+		}
+		v, err := Compute(operation, real(a), real(b))
+		return complex(v, 0), err
+	default:
+		return 0, fmt.Errorf("invalid operator: %s", operation)
+	}
+}
+
+func boolean(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -1,8 +1,10 @@
-package calculation
-
-import "errors"
-
-var (
-	ErrDivisionByZero  = errors.New("division by zero")
-	ErrInvalidOperator = errors.New("invalid operator")
-)
+package calculation
+
+import "errors"
+
+var (
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrInvalidOperator = errors.New("invalid operator")
+	ErrNotReal         = errors.New("result is not a real number")
+	ErrNotOrdered      = errors.New("complex numbers cannot be ordered")
+)
@@ -0,0 +1,390 @@
+// Package client talks to the calculator orchestrator over its HTTP API
+// and, for watching expressions, its gRPC API.
+//
+//	c := client.New("http://localhost:8080")
+//	if _, err := c.Login(ctx, "alice", "secret123"); err != nil { ... }
+//	id, err := c.Calculate(ctx, "(2+3)*4")
+//	e, err := c.Wait(ctx, id, 0)
+//
+// Requests that are safe to repeat are retried on 5xx answers and network
+// errors, an expired token is replaced by logging in again, and API
+// errors are *Error values matching ErrNotFound and the other sentinel
+// errors with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// DefaultPollInterval is how often Wait and Watch look for changes when
+// no interval is given.
+const DefaultPollInterval = 500 * time.Millisecond
+
+// Retries of New; the pause before a retry doubles up to maxBackoff.
+const (
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 200 * time.Millisecond
+	maxBackoff        = 5 * time.Second
+)
+
+// Client is safe for concurrent use.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// GRPC, if set, is a connection to the orchestrator's gRPC port:
+	// Watch and Wait then stream changes instead of polling.
+	GRPC grpc.ClientConnInterface
+	// MaxRetries is how many times a request is repeated after a 5xx
+	// answer or a network error, Backoff the pause before the first
+	// repeat. Only requests that are safe to repeat are retried.
+	MaxRetries int
+	Backoff    time.Duration
+
+	mu    sync.Mutex
+	token string
+	creds *credentials
+}
+
+// New returns a client of the orchestrator at baseURL, e.g.
+// "http://localhost:8080".
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: DefaultMaxRetries,
+		Backoff:    DefaultBackoff,
+	}
+}
+
+// Token returns the token requests are sent with.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// SetToken sets the token requests are sent with, e.g. one saved from
+// an earlier Login.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+func (c *Client) Register(ctx context.Context, login, password string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/api/v1/register", in: credentials{login, password}})
+}
+
+// Login gets a token and uses it for the following requests. The
+// credentials are kept to log in again when the token expires.
+func (c *Client) Login(ctx context.Context, login, password string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	creds := credentials{login, password}
+	r := request{method: http.MethodPost, path: "/api/v1/login", in: creds, out: &resp, repeatable: true}
+	if err := c.retry(ctx, r, ""); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.token, c.creds = resp.Token, &creds
+	c.mu.Unlock()
+	return resp.Token, nil
+}
+
+// Calculate submits an expression and returns its id. Retries carry
+// the same Idempotency-Key, so the expression is created only once.
+func (c *Client) Calculate(ctx context.Context, expression string) (int64, error) {
+	return c.CalculateWithVariables(ctx, expression, nil)
+}
+
+// CalculateWithVariables submits an expression together with the values
+// of the variables it uses, e.g. "x*(y+1)" with {"x": 2, "y": 3}.
+func (c *Client) CalculateWithVariables(ctx context.Context, expression string, vars map[string]float64) (int64, error) {
+	return c.calculate(ctx, expression, vars, false)
+}
+
+// CalculateComplex submits an expression in complex mode, e.g.
+// "sqrt(-4) + (1+2i)*x"; its result is in Expression.ComplexResult.
+func (c *Client) CalculateComplex(ctx context.Context, expression string, vars map[string]float64) (int64, error) {
+	return c.calculate(ctx, expression, vars, true)
+}
+
+func (c *Client) calculate(ctx context.Context, expression string, vars map[string]float64, complexMode bool) (int64, error) {
+	var resp struct {
+		ID int64 `json:"id"`
+	}
+	in := struct {
+		Expression string             `json:"expression"`
+		Variables  map[string]float64 `json:"variables,omitempty"`
+		Complex    bool               `json:"complex,omitempty"`
+	}{expression, vars, complexMode}
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return 0, err
+	}
+	r := request{method: http.MethodPost, path: "/api/v1/calculate", in: in, out: &resp, key: key, repeatable: true}
+	if err := c.do(ctx, r); err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+func (c *Client) Get(ctx context.Context, id int64) (*Expression, error) {
+	d, err := c.Detail(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &d.Expression, nil
+}
+
+// Detail returns an expression with the state of every node.
+func (c *Client) Detail(ctx context.Context, id int64) (*Detail, error) {
+	var d Detail
+	r := request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/expressions/%d/details", id), out: &d}
+	if err := c.do(ctx, r); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (c *Client) List(ctx context.Context, opts ListOptions) (*ExpressionList, error) {
+	q := url.Values{}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		q.Set("cursor", opts.Cursor)
+	}
+	if len(opts.Status) > 0 {
+		q.Set("status", strings.Join(opts.Status, ","))
+	}
+	if !opts.CreatedAfter.IsZero() {
+		q.Set("created_after", opts.CreatedAfter.Format(time.RFC3339))
+	}
+	if !opts.CreatedBefore.IsZero() {
+		q.Set("created_before", opts.CreatedBefore.Format(time.RFC3339))
+	}
+	if opts.Query != "" {
+		q.Set("q", opts.Query)
+	}
+	if opts.Sort != "" {
+		q.Set("sort", opts.Sort)
+	}
+	path := "/api/v1/expressions"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var list ExpressionList
+	if err := c.do(ctx, request{method: http.MethodGet, path: path, out: &list}); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Cancel stops a pending expression.
+func (c *Client) Cancel(ctx context.Context, id int64) (*Expression, error) {
+	var e Expression
+	r := request{method: http.MethodPost, path: fmt.Sprintf("/api/v1/expressions/%d/cancel", id), out: &e}
+	if err := c.do(ctx, r); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Watch calls fn with an expression and then on every change of it,
+// until it is finished or ctx is done. Changes are streamed over gRPC
+// if the client has a GRPC connection, otherwise the expression is
+// polled every interval.
+func (c *Client) Watch(ctx context.Context, id int64, interval time.Duration, fn func(*Expression)) (*Expression, error) {
+	if c.GRPC != nil {
+		return c.watchStream(ctx, id, fn)
+	}
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var last *Expression
+	for {
+		e, err := c.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if last == nil || e.Status != last.Status || !e.UpdatedAt.Equal(last.UpdatedAt) {
+			if fn != nil {
+				fn(e)
+			}
+			last = e
+		}
+		if e.Finished() {
+			return e, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Wait returns the expression once it is finished.
+func (c *Client) Wait(ctx context.Context, id int64, interval time.Duration) (*Expression, error) {
+	return c.Watch(ctx, id, interval, nil)
+}
+
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type request struct {
+	method, path string
+	in, out      interface{}
+	// key is sent as Idempotency-Key
+	key string
+	// repeatable requests are retried after 5xx answers and network
+	// errors; GET requests always are
+	repeatable bool
+}
+
+// do sends a request with the token, logging in again with the saved
+// credentials if the server no longer accepts it.
+func (c *Client) do(ctx context.Context, r request) error {
+	return c.authorized(ctx, func(token string) error {
+		return c.retry(ctx, r, token)
+	})
+}
+
+func (c *Client) authorized(ctx context.Context, call func(token string) error) error {
+	token := c.Token()
+	err := call(token)
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusUnauthorized {
+		return err
+	}
+	c.mu.Lock()
+	creds, current := c.creds, c.token
+	c.mu.Unlock()
+	if creds == nil {
+		return err
+	}
+	// another request may have logged in already
+	if current == token {
+		if _, err := c.Login(ctx, creds.Login, creds.Password); err != nil {
+			return err
+		}
+	}
+	return call(c.Token())
+}
+
+// retry sends a request until it succeeds, fails for good or runs out
+// of retries, pausing between attempts.
+func (c *Client) retry(ctx context.Context, r request, token string) error {
+	var body []byte
+	if r.in != nil {
+		var err error
+		if body, err = json.Marshal(r.in); err != nil {
+			return err
+		}
+	}
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, r, body, token)
+		if err == nil || attempt >= c.MaxRetries || !(r.repeatable || r.method == http.MethodGet) || !temporary(ctx, err) {
+			return err
+		}
+		pause := backoff
+		var e *Error
+		if errors.As(err, &e) && e.RetryAfter > pause {
+			pause = e.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(pause):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// temporary reports whether a failed request may succeed if repeated.
+func temporary(ctx context.Context, err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode >= 500
+	}
+	return ctx.Err() == nil
+}
+
+// send sends a JSON request and decodes a JSON answer into r.out, if
+// given.
+func (c *Client) send(ctx context.Context, r request, body []byte, token string) error {
+	req, err := http.NewRequestWithContext(ctx, r.method, c.BaseURL+r.path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if r.key != "" {
+		req.Header.Set("Idempotency-Key", r.key)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+	if r.out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(r.out)
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func decodeError(resp *http.Response) error {
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	e := &Error{StatusCode: resp.StatusCode}
+	if json.NewDecoder(resp.Body).Decode(&body) == nil {
+		e.Code, e.Message = body.Error.Code, body.Error.Message
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	return e
+}
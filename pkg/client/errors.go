@@ -0,0 +1,55 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors an *Error matches with errors.Is, by its HTTP status:
+//
+//	if errors.Is(err, client.ErrNotFound) { ... }
+var (
+	ErrBadRequest        = errors.New("bad request")
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrNotFound          = errors.New("not found")
+	ErrConflict          = errors.New("conflict")
+	ErrInvalidExpression = errors.New("invalid expression")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrServer            = errors.New("server error")
+)
+
+// Error is an error answer of the API.
+type Error struct {
+	StatusCode int
+	// Code is the machine-readable code, e.g. "invalid_expression".
+	Code    string
+	Message string
+	// RetryAfter is set for rate limits and locked accounts.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusRequestEntityTooLarge
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrInvalidExpression:
+		return e.StatusCode == http.StatusUnprocessableEntity && e.Code == "invalid_expression"
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/lollmark/digital_calc/proto/calc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// watchStream is Watch over CalcAPI.WatchExpression, which sends the
+// expression and then every change of it.
+func (c *Client) watchStream(ctx context.Context, id int64, fn func(*Expression)) (*Expression, error) {
+	var last *Expression
+	err := c.authorized(ctx, func(token string) error {
+		ctx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		stream, err := calc.NewCalcAPIClient(c.GRPC).WatchExpression(ctx, &calc.ExpressionReq{Id: id})
+		if err != nil {
+			return fromStatus(err)
+		}
+		for {
+			m, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fromStatus(err)
+			}
+			last = fromProto(m)
+			if fn != nil {
+				fn(last)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if last == nil || !last.Finished() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return last, nil
+}
+
+func fromProto(m *calc.Expression) *Expression {
+	e := &Expression{
+		ID:            m.Id,
+		Expression:    m.Expression,
+		Status:        m.Status,
+		Complex:       m.Complex,
+		Result:        m.Result,
+		ComplexResult: m.ComplexResult,
+		Unit:          m.GetUnit(),
+		CreatedAt:     m.CreatedAt.AsTime(),
+		UpdatedAt:     m.UpdatedAt.AsTime(),
+	}
+	if m.FinishedAt != nil {
+		t := m.FinishedAt.AsTime()
+		e.FinishedAt = &t
+	}
+	return e
+}
+
+// grpcErrors maps gRPC codes to the HTTP answers of the same errors.
+var grpcErrors = map[codes.Code]Error{
+	codes.InvalidArgument:    {StatusCode: http.StatusBadRequest, Code: "bad_request"},
+	codes.Unauthenticated:    {StatusCode: http.StatusUnauthorized, Code: "invalid_token"},
+	codes.NotFound:           {StatusCode: http.StatusNotFound, Code: "not_found"},
+	codes.AlreadyExists:      {StatusCode: http.StatusConflict, Code: "user_exists"},
+	codes.FailedPrecondition: {StatusCode: http.StatusConflict, Code: "not_pending"},
+	codes.ResourceExhausted:  {StatusCode: http.StatusTooManyRequests, Code: "rate_limited"},
+	codes.Internal:           {StatusCode: http.StatusInternalServerError, Code: "internal_error"},
+	codes.Unavailable:        {StatusCode: http.StatusServiceUnavailable, Code: "unavailable"},
+}
+
+// fromStatus turns a gRPC error into an *Error, so that errors.Is
+// works the same for both APIs.
+func fromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	e, ok := grpcErrors[st.Code()]
+	if !ok {
+		return err
+	}
+	e.Message = st.Message()
+	return &e
+}
@@ -0,0 +1,90 @@
+package client
+
+import "time"
+
+// Expression statuses.
+const (
+	StatusPending   = "pending"
+	StatusDone      = "done"
+	StatusCancelled = "cancelled"
+)
+
+// Expression is a submitted expression and, once done, its result.
+// Result is set while the result is real; in complex mode ComplexResult
+// holds it as a+bi, which strconv.ParseComplex reads. A result with a
+// dimension is in SI base units, named by Unit, e.g. "kg*m/s^2".
+type Expression struct {
+	ID            int64      `json:"id"`
+	Expression    string     `json:"expression"`
+	Status        string     `json:"status"`
+	Complex       bool       `json:"complex,omitempty"`
+	Result        *float64   `json:"result,omitempty"`
+	ComplexResult *string    `json:"complex_result,omitempty"`
+	Unit          string     `json:"unit,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the expression will not change any more.
+func (e *Expression) Finished() bool { return e.Status != StatusPending }
+
+// Node states of a Detail tree.
+const (
+	NodeWaiting = "waiting"
+	NodeQueued  = "queued"
+	NodeRunning = "running"
+	NodeDone    = "done"
+	NodeSkipped = "skipped"
+)
+
+// Node is a node of the expression tree. Literals have only a Value;
+// operators also carry the task that computes them once it is queued.
+// Conditionals have no task; an "if" has a Condition and its branches
+// in Left and Right. Values are set like the result of an Expression.
+type Node struct {
+	State        string     `json:"state"`
+	Value        *float64   `json:"value,omitempty"`
+	ComplexValue *string    `json:"complex_value,omitempty"`
+	Unit         string     `json:"unit,omitempty"`
+	Variable     string     `json:"variable,omitempty"`
+	Operator     string     `json:"operator,omitempty"`
+	TaskID       string     `json:"task_id,omitempty"`
+	AgentID      string     `json:"agent_id,omitempty"`
+	QueuedAt     *time.Time `json:"queued_at,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Condition    *Node      `json:"condition,omitempty"`
+	Left         *Node      `json:"left,omitempty"`
+	Right        *Node      `json:"right,omitempty"`
+}
+
+type Timings struct {
+	WallClockMs    int64 `json:"wall_clock_ms"`
+	CriticalPathMs int64 `json:"critical_path_ms"`
+}
+
+// Detail is an expression with its tree of tasks.
+type Detail struct {
+	Expression Expression `json:"expression"`
+	Tree       *Node      `json:"tree"`
+	Timings    Timings    `json:"timings"`
+}
+
+// ListOptions filter and page List. Zero fields are left to the server
+// defaults: 50 expressions, newest first.
+type ListOptions struct {
+	Limit         int
+	Cursor        string
+	Status        []string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Query         string
+	Sort          string
+}
+
+// ExpressionList is one page of List; NextCursor is empty on the last.
+type ExpressionList struct {
+	Expressions []Expression `json:"expressions"`
+	NextCursor  string       `json:"next_cursor,omitempty"`
+}
@@ -1,313 +1,350 @@
-// Code generated by protoc-gen-go. DO NOT EDIT.
-// versions:
-// 	protoc-gen-go v1.29.1
-// 	protoc        v6.31.0--rc2
-// source: proto/calc.proto
-
-package calc
-
-import (
-	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
-	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
-	reflect "reflect"
-	sync "sync"
-)
-
-const (
-	// Verify that this generated code is sufficiently up-to-date.
-	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
-	// Verify that runtime/protoimpl is sufficiently up-to-date.
-	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
-)
-
-type Empty struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
-}
-
-func (x *Empty) Reset() {
-	*x = Empty{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_calc_proto_msgTypes[0]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
-}
-
-func (x *Empty) String() string {
-	return protoimpl.X.MessageStringOf(x)
-}
-
-func (*Empty) ProtoMessage() {}
-
-func (x *Empty) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_calc_proto_msgTypes[0]
-	if protoimpl.UnsafeEnabled && x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
-	}
-	return mi.MessageOf(x)
-}
-
-// Deprecated: Use Empty.ProtoReflect.Descriptor instead.
-func (*Empty) Descriptor() ([]byte, []int) {
-	return file_proto_calc_proto_rawDescGZIP(), []int{0}
-}
-
-type TaskResp struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
-
-	Id            string  `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Arg1          float64 `protobuf:"fixed64,2,opt,name=arg1,proto3" json:"arg1,omitempty"`
-	Arg2          float64 `protobuf:"fixed64,3,opt,name=arg2,proto3" json:"arg2,omitempty"`
-	Operation     string  `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`
-	OperationTime int32   `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"`
-}
-
-func (x *TaskResp) Reset() {
-	*x = TaskResp{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_calc_proto_msgTypes[1]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
-}
-
-func (x *TaskResp) String() string {
-	return protoimpl.X.MessageStringOf(x)
-}
-
-func (*TaskResp) ProtoMessage() {}
-
-func (x *TaskResp) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_calc_proto_msgTypes[1]
-	if protoimpl.UnsafeEnabled && x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
-	}
-	return mi.MessageOf(x)
-}
-
-// Deprecated: Use TaskResp.ProtoReflect.Descriptor instead.
-func (*TaskResp) Descriptor() ([]byte, []int) {
-	return file_proto_calc_proto_rawDescGZIP(), []int{1}
-}
-
-func (x *TaskResp) GetId() string {
-	if x != nil {
-		return x.Id
-	}
-	return ""
-}
-
-func (x *TaskResp) GetArg1() float64 {
-	if x != nil {
-		return x.Arg1
-	}
-	return 0
-}
-
-func (x *TaskResp) GetArg2() float64 {
-	if x != nil {
-		return x.Arg2
-	}
-	return 0
-}
-
-func (x *TaskResp) GetOperation() string {
-	if x != nil {
-		return x.Operation
-	}
-	return ""
-}
-
-func (x *TaskResp) GetOperationTime() int32 {
-	if x != nil {
-		return x.OperationTime
-	}
-	return 0
-}
-
-type ResultReq struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
-
-	Id     string  `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Result float64 `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
-}
-
-func (x *ResultReq) Reset() {
-	*x = ResultReq{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_calc_proto_msgTypes[2]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
-}
-
-func (x *ResultReq) String() string {
-	return protoimpl.X.MessageStringOf(x)
-}
-
-func (*ResultReq) ProtoMessage() {}
-
-func (x *ResultReq) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_calc_proto_msgTypes[2]
-	if protoimpl.UnsafeEnabled && x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
-	}
-	return mi.MessageOf(x)
-}
-
-// Deprecated: Use ResultReq.ProtoReflect.Descriptor instead.
-func (*ResultReq) Descriptor() ([]byte, []int) {
-	return file_proto_calc_proto_rawDescGZIP(), []int{2}
-}
-
-func (x *ResultReq) GetId() string {
-	if x != nil {
-		return x.Id
-	}
-	return ""
-}
-
-func (x *ResultReq) GetResult() float64 {
-	if x != nil {
-		return x.Result
-	}
-	return 0
-}
-
-var File_proto_calc_proto protoreflect.FileDescriptor
-
-var file_proto_calc_proto_rawDesc = []byte{
-	0x0a, 0x10, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x61, 0x6c, 0x63, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x12, 0x04, 0x63, 0x61, 0x6c, 0x63, 0x22, 0x07, 0x0a, 0x05, 0x45, 0x6d, 0x70, 0x74,
-	0x79, 0x22, 0x87, 0x01, 0x0a, 0x08, 0x54, 0x61, 0x73, 0x6b, 0x52, 0x65, 0x73, 0x70, 0x12, 0x0e,
-	0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12,
-	0x0a, 0x04, 0x61, 0x72, 0x67, 0x31, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x04, 0x61, 0x72,
-	0x67, 0x31, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x72, 0x67, 0x32, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01,
-	0x52, 0x04, 0x61, 0x72, 0x67, 0x32, 0x12, 0x1c, 0x0a, 0x09, 0x6f, 0x70, 0x65, 0x72, 0x61, 0x74,
-	0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6f, 0x70, 0x65, 0x72, 0x61,
-	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x25, 0x0a, 0x0e, 0x6f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f,
-	0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0d, 0x6f, 0x70,
-	0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x22, 0x33, 0x0a, 0x09, 0x52,
-	0x65, 0x73, 0x75, 0x6c, 0x74, 0x52, 0x65, 0x71, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x73, 0x75,
-	0x6c, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74,
-	0x32, 0x5e, 0x0a, 0x04, 0x43, 0x61, 0x6c, 0x63, 0x12, 0x28, 0x0a, 0x07, 0x47, 0x65, 0x74, 0x54,
-	0x61, 0x73, 0x6b, 0x12, 0x0b, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79,
-	0x1a, 0x0e, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x2e, 0x54, 0x61, 0x73, 0x6b, 0x52, 0x65, 0x73, 0x70,
-	0x22, 0x00, 0x12, 0x2c, 0x0a, 0x0a, 0x50, 0x6f, 0x73, 0x74, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74,
-	0x12, 0x0f, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x2e, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x52, 0x65,
-	0x71, 0x1a, 0x0b, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00,
-	0x42, 0x11, 0x5a, 0x0f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x61, 0x6c, 0x63, 0x3b, 0x63,
-	0x61, 0x6c, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
-}
-
-var (
-	file_proto_calc_proto_rawDescOnce sync.Once
-	file_proto_calc_proto_rawDescData = file_proto_calc_proto_rawDesc
-)
-
-func file_proto_calc_proto_rawDescGZIP() []byte {
-	file_proto_calc_proto_rawDescOnce.Do(func() {
-		file_proto_calc_proto_rawDescData = protoimpl.X.CompressGZIP(file_proto_calc_proto_rawDescData)
-	})
-	return file_proto_calc_proto_rawDescData
-}
-
-var file_proto_calc_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
-var file_proto_calc_proto_goTypes = []interface{}{
-	(*Empty)(nil),     // 0: calc.Empty
-	(*TaskResp)(nil),  // 1: calc.TaskResp
-	(*ResultReq)(nil), // 2: calc.ResultReq
-}
-var file_proto_calc_proto_depIdxs = []int32{
-	0, // 0: calc.Calc.GetTask:input_type -> calc.Empty
-	2, // 1: calc.Calc.PostResult:input_type -> calc.ResultReq
-	1, // 2: calc.Calc.GetTask:output_type -> calc.TaskResp
-	0, // 3: calc.Calc.PostResult:output_type -> calc.Empty
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
-}
-
-func init() { file_proto_calc_proto_init() }
-func file_proto_calc_proto_init() {
-	if File_proto_calc_proto != nil {
-		return
-	}
-	if !protoimpl.UnsafeEnabled {
-		file_proto_calc_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Empty); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_proto_calc_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*TaskResp); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_proto_calc_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ResultReq); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-	}
-	type x struct{}
-	out := protoimpl.TypeBuilder{
-		File: protoimpl.DescBuilder{
-			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
-			RawDescriptor: file_proto_calc_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   3,
-			NumExtensions: 0,
-			NumServices:   1,
-		},
-		GoTypes:           file_proto_calc_proto_goTypes,
-		DependencyIndexes: file_proto_calc_proto_depIdxs,
-		MessageInfos:      file_proto_calc_proto_msgTypes,
-	}.Build()
-	File_proto_calc_proto = out.File
-	file_proto_calc_proto_rawDesc = nil
-	file_proto_calc_proto_goTypes = nil
-	file_proto_calc_proto_depIdxs = nil
-}
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v6.31.0--rc2
+// source: proto/calc.proto
+
+package calc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Empty struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Empty) Reset() {
+	*x = Empty{}
+	mi := &file_proto_calc_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Empty) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Empty) ProtoMessage() {}
+
+func (x *Empty) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Empty.ProtoReflect.Descriptor instead.
+func (*Empty) Descriptor() ([]byte, []int) {
+	return file_proto_calc_proto_rawDescGZIP(), []int{0}
+}
+
+type TaskResp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Arg1          float64                `protobuf:"fixed64,2,opt,name=arg1,proto3" json:"arg1,omitempty"`
+	Arg2          float64                `protobuf:"fixed64,3,opt,name=arg2,proto3" json:"arg2,omitempty"`
+	Operation     string                 `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`
+	OperationTime int32                  `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"`
+	// imaginary parts of the arguments, set for expressions in complex mode
+	Arg1Imag float64 `protobuf:"fixed64,6,opt,name=arg1_imag,json=arg1Imag,proto3" json:"arg1_imag,omitempty"`
+	Arg2Imag float64 `protobuf:"fixed64,7,opt,name=arg2_imag,json=arg2Imag,proto3" json:"arg2_imag,omitempty"`
+	// complex is set when the task is computed over complex numbers
+	Complex       bool `protobuf:"varint,8,opt,name=complex,proto3" json:"complex,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskResp) Reset() {
+	*x = TaskResp{}
+	mi := &file_proto_calc_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskResp) ProtoMessage() {}
+
+func (x *TaskResp) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskResp.ProtoReflect.Descriptor instead.
+func (*TaskResp) Descriptor() ([]byte, []int) {
+	return file_proto_calc_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TaskResp) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *TaskResp) GetArg1() float64 {
+	if x != nil {
+		return x.Arg1
+	}
+	return 0
+}
+
+func (x *TaskResp) GetArg2() float64 {
+	if x != nil {
+		return x.Arg2
+	}
+	return 0
+}
+
+func (x *TaskResp) GetOperation() string {
+	if x != nil {
+		return x.Operation
+	}
+	return ""
+}
+
+func (x *TaskResp) GetOperationTime() int32 {
+	if x != nil {
+		return x.OperationTime
+	}
+	return 0
+}
+
+func (x *TaskResp) GetArg1Imag() float64 {
+	if x != nil {
+		return x.Arg1Imag
+	}
+	return 0
+}
+
+func (x *TaskResp) GetArg2Imag() float64 {
+	if x != nil {
+		return x.Arg2Imag
+	}
+	return 0
+}
+
+func (x *TaskResp) GetComplex() bool {
+	if x != nil {
+		return x.Complex
+	}
+	return false
+}
+
+type ResultReq struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Result        float64                `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
+	ResultImag    float64                `protobuf:"fixed64,3,opt,name=result_imag,json=resultImag,proto3" json:"result_imag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResultReq) Reset() {
+	*x = ResultReq{}
+	mi := &file_proto_calc_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResultReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResultReq) ProtoMessage() {}
+
+func (x *ResultReq) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResultReq.ProtoReflect.Descriptor instead.
+func (*ResultReq) Descriptor() ([]byte, []int) {
+	return file_proto_calc_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ResultReq) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ResultReq) GetResult() float64 {
+	if x != nil {
+		return x.Result
+	}
+	return 0
+}
+
+func (x *ResultReq) GetResultImag() float64 {
+	if x != nil {
+		return x.ResultImag
+	}
+	return 0
+}
+
+type ReleaseReq struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseReq) Reset() {
+	*x = ReleaseReq{}
+	mi := &file_proto_calc_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseReq) ProtoMessage() {}
+
+func (x *ReleaseReq) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseReq.ProtoReflect.Descriptor instead.
+func (*ReleaseReq) Descriptor() ([]byte, []int) {
+	return file_proto_calc_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ReleaseReq) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+var File_proto_calc_proto protoreflect.FileDescriptor
+
+const file_proto_calc_proto_rawDesc = "" +
+	"\n" +
+	"\x10proto/calc.proto\x12\x04calc\"\a\n" +
+	"\x05Empty\"\xdb\x01\n" +
+	"\bTaskResp\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04arg1\x18\x02 \x01(\x01R\x04arg1\x12\x12\n" +
+	"\x04arg2\x18\x03 \x01(\x01R\x04arg2\x12\x1c\n" +
+	"\toperation\x18\x04 \x01(\tR\toperation\x12%\n" +
+	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\x12\x1b\n" +
+	"\targ1_imag\x18\x06 \x01(\x01R\barg1Imag\x12\x1b\n" +
+	"\targ2_imag\x18\a \x01(\x01R\barg2Imag\x12\x18\n" +
+	"\acomplex\x18\b \x01(\bR\acomplex\"T\n" +
+	"\tResultReq\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06result\x18\x02 \x01(\x01R\x06result\x12\x1f\n" +
+	"\vresult_imag\x18\x03 \x01(\x01R\n" +
+	"resultImag\"\x1c\n" +
+	"\n" +
+	"ReleaseReq\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id2\x8e\x01\n" +
+	"\x04Calc\x12(\n" +
+	"\aGetTask\x12\v.calc.Empty\x1a\x0e.calc.TaskResp\"\x00\x12,\n" +
+	"\n" +
+	"PostResult\x12\x0f.calc.ResultReq\x1a\v.calc.Empty\"\x00\x12.\n" +
+	"\vReleaseTask\x12\x10.calc.ReleaseReq\x1a\v.calc.Empty\"\x00B\x11Z\x0fproto/calc;calcb\x06proto3"
+
+var (
+	file_proto_calc_proto_rawDescOnce sync.Once
+	file_proto_calc_proto_rawDescData []byte
+)
+
+func file_proto_calc_proto_rawDescGZIP() []byte {
+	file_proto_calc_proto_rawDescOnce.Do(func() {
+		file_proto_calc_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_calc_proto_rawDesc), len(file_proto_calc_proto_rawDesc)))
+	})
+	return file_proto_calc_proto_rawDescData
+}
+
+var file_proto_calc_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_proto_calc_proto_goTypes = []any{
+	(*Empty)(nil),      // 0: calc.Empty
+	(*TaskResp)(nil),   // 1: calc.TaskResp
+	(*ResultReq)(nil),  // 2: calc.ResultReq
+	(*ReleaseReq)(nil), // 3: calc.ReleaseReq
+}
+var file_proto_calc_proto_depIdxs = []int32{
+	0, // 0: calc.Calc.GetTask:input_type -> calc.Empty
+	2, // 1: calc.Calc.PostResult:input_type -> calc.ResultReq
+	3, // 2: calc.Calc.ReleaseTask:input_type -> calc.ReleaseReq
+	1, // 3: calc.Calc.GetTask:output_type -> calc.TaskResp
+	0, // 4: calc.Calc.PostResult:output_type -> calc.Empty
+	0, // 5: calc.Calc.ReleaseTask:output_type -> calc.Empty
+	3, // [3:6] is the sub-list for method output_type
+	0, // [0:3] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_proto_calc_proto_init() }
+func file_proto_calc_proto_init() {
+	if File_proto_calc_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_calc_proto_rawDesc), len(file_proto_calc_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_calc_proto_goTypes,
+		DependencyIndexes: file_proto_calc_proto_depIdxs,
+		MessageInfos:      file_proto_calc_proto_msgTypes,
+	}.Build()
+	File_proto_calc_proto = out.File
+	file_proto_calc_proto_goTypes = nil
+	file_proto_calc_proto_depIdxs = nil
+}
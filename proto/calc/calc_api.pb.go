@@ -0,0 +1,687 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v6.31.0--rc2
+// source: proto/calc_api.proto
+
+package calc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Credentials struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Login         string                 `protobuf:"bytes,1,opt,name=login,proto3" json:"login,omitempty"`
+	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Credentials) Reset() {
+	*x = Credentials{}
+	mi := &file_proto_calc_api_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Credentials) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Credentials) ProtoMessage() {}
+
+func (x *Credentials) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_api_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Credentials.ProtoReflect.Descriptor instead.
+func (*Credentials) Descriptor() ([]byte, []int) {
+	return file_proto_calc_api_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Credentials) GetLogin() string {
+	if x != nil {
+		return x.Login
+	}
+	return ""
+}
+
+func (x *Credentials) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+type LoginResp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoginResp) Reset() {
+	*x = LoginResp{}
+	mi := &file_proto_calc_api_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoginResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoginResp) ProtoMessage() {}
+
+func (x *LoginResp) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_api_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoginResp.ProtoReflect.Descriptor instead.
+func (*LoginResp) Descriptor() ([]byte, []int) {
+	return file_proto_calc_api_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LoginResp) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type CalculateReq struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Expression  string                 `protobuf:"bytes,1,opt,name=expression,proto3" json:"expression,omitempty"`
+	CallbackUrl string                 `protobuf:"bytes,2,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
+	// values of the variables the expression uses
+	Variables map[string]float64 `protobuf:"bytes,3,rep,name=variables,proto3" json:"variables,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"`
+	// complex computes the expression over complex numbers
+	Complex       bool `protobuf:"varint,4,opt,name=complex,proto3" json:"complex,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CalculateReq) Reset() {
+	*x = CalculateReq{}
+	mi := &file_proto_calc_api_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CalculateReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CalculateReq) ProtoMessage() {}
+
+func (x *CalculateReq) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_api_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CalculateReq.ProtoReflect.Descriptor instead.
+func (*CalculateReq) Descriptor() ([]byte, []int) {
+	return file_proto_calc_api_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CalculateReq) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+func (x *CalculateReq) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
+func (x *CalculateReq) GetVariables() map[string]float64 {
+	if x != nil {
+		return x.Variables
+	}
+	return nil
+}
+
+func (x *CalculateReq) GetComplex() bool {
+	if x != nil {
+		return x.Complex
+	}
+	return false
+}
+
+type CalculateResp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CalculateResp) Reset() {
+	*x = CalculateResp{}
+	mi := &file_proto_calc_api_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CalculateResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CalculateResp) ProtoMessage() {}
+
+func (x *CalculateResp) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_api_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CalculateResp.ProtoReflect.Descriptor instead.
+func (*CalculateResp) Descriptor() ([]byte, []int) {
+	return file_proto_calc_api_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CalculateResp) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type ExpressionReq struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExpressionReq) Reset() {
+	*x = ExpressionReq{}
+	mi := &file_proto_calc_api_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExpressionReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExpressionReq) ProtoMessage() {}
+
+func (x *ExpressionReq) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_api_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExpressionReq.ProtoReflect.Descriptor instead.
+func (*ExpressionReq) Descriptor() ([]byte, []int) {
+	return file_proto_calc_api_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ExpressionReq) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type Expression struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Id         int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Expression string                 `protobuf:"bytes,2,opt,name=expression,proto3" json:"expression,omitempty"`
+	Status     string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Result     *float64               `protobuf:"fixed64,4,opt,name=result,proto3,oneof" json:"result,omitempty"`
+	CreatedAt  *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt  *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	FinishedAt *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	Complex    bool                   `protobuf:"varint,8,opt,name=complex,proto3" json:"complex,omitempty"`
+	// the result of an expression in complex mode as a+bi; result is set
+	// too while it is real
+	ComplexResult *string `protobuf:"bytes,9,opt,name=complex_result,json=complexResult,proto3,oneof" json:"complex_result,omitempty"`
+	// the SI base units of the result, e.g. kg*m/s^2, if it has any
+	Unit          *string `protobuf:"bytes,10,opt,name=unit,proto3,oneof" json:"unit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Expression) Reset() {
+	*x = Expression{}
+	mi := &file_proto_calc_api_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Expression) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Expression) ProtoMessage() {}
+
+func (x *Expression) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_api_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Expression.ProtoReflect.Descriptor instead.
+func (*Expression) Descriptor() ([]byte, []int) {
+	return file_proto_calc_api_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Expression) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Expression) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+func (x *Expression) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Expression) GetResult() float64 {
+	if x != nil && x.Result != nil {
+		return *x.Result
+	}
+	return 0
+}
+
+func (x *Expression) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Expression) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *Expression) GetFinishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return nil
+}
+
+func (x *Expression) GetComplex() bool {
+	if x != nil {
+		return x.Complex
+	}
+	return false
+}
+
+func (x *Expression) GetComplexResult() string {
+	if x != nil && x.ComplexResult != nil {
+		return *x.ComplexResult
+	}
+	return ""
+}
+
+func (x *Expression) GetUnit() string {
+	if x != nil && x.Unit != nil {
+		return *x.Unit
+	}
+	return ""
+}
+
+type ListExpressionsReq struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Limit         int32                  `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Cursor        string                 `protobuf:"bytes,2,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	Status        []string               `protobuf:"bytes,3,rep,name=status,proto3" json:"status,omitempty"`
+	CreatedAfter  *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created_after,json=createdAfter,proto3" json:"created_after,omitempty"`
+	CreatedBefore *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_before,json=createdBefore,proto3" json:"created_before,omitempty"`
+	Q             string                 `protobuf:"bytes,6,opt,name=q,proto3" json:"q,omitempty"`
+	Sort          string                 `protobuf:"bytes,7,opt,name=sort,proto3" json:"sort,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListExpressionsReq) Reset() {
+	*x = ListExpressionsReq{}
+	mi := &file_proto_calc_api_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListExpressionsReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListExpressionsReq) ProtoMessage() {}
+
+func (x *ListExpressionsReq) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_api_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListExpressionsReq.ProtoReflect.Descriptor instead.
+func (*ListExpressionsReq) Descriptor() ([]byte, []int) {
+	return file_proto_calc_api_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListExpressionsReq) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListExpressionsReq) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+func (x *ListExpressionsReq) GetStatus() []string {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+func (x *ListExpressionsReq) GetCreatedAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAfter
+	}
+	return nil
+}
+
+func (x *ListExpressionsReq) GetCreatedBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedBefore
+	}
+	return nil
+}
+
+func (x *ListExpressionsReq) GetQ() string {
+	if x != nil {
+		return x.Q
+	}
+	return ""
+}
+
+func (x *ListExpressionsReq) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+type ListExpressionsResp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Expressions   []*Expression          `protobuf:"bytes,1,rep,name=expressions,proto3" json:"expressions,omitempty"`
+	NextCursor    string                 `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListExpressionsResp) Reset() {
+	*x = ListExpressionsResp{}
+	mi := &file_proto_calc_api_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListExpressionsResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListExpressionsResp) ProtoMessage() {}
+
+func (x *ListExpressionsResp) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_api_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListExpressionsResp.ProtoReflect.Descriptor instead.
+func (*ListExpressionsResp) Descriptor() ([]byte, []int) {
+	return file_proto_calc_api_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListExpressionsResp) GetExpressions() []*Expression {
+	if x != nil {
+		return x.Expressions
+	}
+	return nil
+}
+
+func (x *ListExpressionsResp) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+var File_proto_calc_api_proto protoreflect.FileDescriptor
+
+const file_proto_calc_api_proto_rawDesc = "" +
+	"\n" +
+	"\x14proto/calc_api.proto\x12\x04calc\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x10proto/calc.proto\"?\n" +
+	"\vCredentials\x12\x14\n" +
+	"\x05login\x18\x01 \x01(\tR\x05login\x12\x1a\n" +
+	"\bpassword\x18\x02 \x01(\tR\bpassword\"!\n" +
+	"\tLoginResp\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\"\xea\x01\n" +
+	"\fCalculateReq\x12\x1e\n" +
+	"\n" +
+	"expression\x18\x01 \x01(\tR\n" +
+	"expression\x12!\n" +
+	"\fcallback_url\x18\x02 \x01(\tR\vcallbackUrl\x12?\n" +
+	"\tvariables\x18\x03 \x03(\v2!.calc.CalculateReq.VariablesEntryR\tvariables\x12\x18\n" +
+	"\acomplex\x18\x04 \x01(\bR\acomplex\x1a<\n" +
+	"\x0eVariablesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x01\"\x1f\n" +
+	"\rCalculateResp\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"\x1f\n" +
+	"\rExpressionReq\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"\xaa\x03\n" +
+	"\n" +
+	"Expression\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1e\n" +
+	"\n" +
+	"expression\x18\x02 \x01(\tR\n" +
+	"expression\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x1b\n" +
+	"\x06result\x18\x04 \x01(\x01H\x00R\x06result\x88\x01\x01\x129\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12;\n" +
+	"\vfinished_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"finishedAt\x12\x18\n" +
+	"\acomplex\x18\b \x01(\bR\acomplex\x12*\n" +
+	"\x0ecomplex_result\x18\t \x01(\tH\x01R\rcomplexResult\x88\x01\x01\x12\x17\n" +
+	"\x04unit\x18\n" +
+	" \x01(\tH\x02R\x04unit\x88\x01\x01B\t\n" +
+	"\a_resultB\x11\n" +
+	"\x0f_complex_resultB\a\n" +
+	"\x05_unit\"\x80\x02\n" +
+	"\x12ListExpressionsReq\x12\x14\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06cursor\x18\x02 \x01(\tR\x06cursor\x12\x16\n" +
+	"\x06status\x18\x03 \x03(\tR\x06status\x12?\n" +
+	"\rcreated_after\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\fcreatedAfter\x12A\n" +
+	"\x0ecreated_before\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\rcreatedBefore\x12\f\n" +
+	"\x01q\x18\x06 \x01(\tR\x01q\x12\x12\n" +
+	"\x04sort\x18\a \x01(\tR\x04sort\"j\n" +
+	"\x13ListExpressionsResp\x122\n" +
+	"\vexpressions\x18\x01 \x03(\v2\x10.calc.ExpressionR\vexpressions\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor2\x9d\x03\n" +
+	"\aCalcAPI\x12,\n" +
+	"\bRegister\x12\x11.calc.Credentials\x1a\v.calc.Empty\"\x00\x12-\n" +
+	"\x05Login\x12\x11.calc.Credentials\x1a\x0f.calc.LoginResp\"\x00\x126\n" +
+	"\tCalculate\x12\x12.calc.CalculateReq\x1a\x13.calc.CalculateResp\"\x00\x128\n" +
+	"\rGetExpression\x12\x13.calc.ExpressionReq\x1a\x10.calc.Expression\"\x00\x12H\n" +
+	"\x0fListExpressions\x12\x18.calc.ListExpressionsReq\x1a\x19.calc.ListExpressionsResp\"\x00\x12;\n" +
+	"\x10CancelExpression\x12\x13.calc.ExpressionReq\x1a\x10.calc.Expression\"\x00\x12<\n" +
+	"\x0fWatchExpression\x12\x13.calc.ExpressionReq\x1a\x10.calc.Expression\"\x000\x01B\x11Z\x0fproto/calc;calcb\x06proto3"
+
+var (
+	file_proto_calc_api_proto_rawDescOnce sync.Once
+	file_proto_calc_api_proto_rawDescData []byte
+)
+
+func file_proto_calc_api_proto_rawDescGZIP() []byte {
+	file_proto_calc_api_proto_rawDescOnce.Do(func() {
+		file_proto_calc_api_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_calc_api_proto_rawDesc), len(file_proto_calc_api_proto_rawDesc)))
+	})
+	return file_proto_calc_api_proto_rawDescData
+}
+
+var file_proto_calc_api_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_proto_calc_api_proto_goTypes = []any{
+	(*Credentials)(nil),           // 0: calc.Credentials
+	(*LoginResp)(nil),             // 1: calc.LoginResp
+	(*CalculateReq)(nil),          // 2: calc.CalculateReq
+	(*CalculateResp)(nil),         // 3: calc.CalculateResp
+	(*ExpressionReq)(nil),         // 4: calc.ExpressionReq
+	(*Expression)(nil),            // 5: calc.Expression
+	(*ListExpressionsReq)(nil),    // 6: calc.ListExpressionsReq
+	(*ListExpressionsResp)(nil),   // 7: calc.ListExpressionsResp
+	nil,                           // 8: calc.CalculateReq.VariablesEntry
+	(*timestamppb.Timestamp)(nil), // 9: google.protobuf.Timestamp
+	(*Empty)(nil),                 // 10: calc.Empty
+}
+var file_proto_calc_api_proto_depIdxs = []int32{
+	8,  // 0: calc.CalculateReq.variables:type_name -> calc.CalculateReq.VariablesEntry
+	9,  // 1: calc.Expression.created_at:type_name -> google.protobuf.Timestamp
+	9,  // 2: calc.Expression.updated_at:type_name -> google.protobuf.Timestamp
+	9,  // 3: calc.Expression.finished_at:type_name -> google.protobuf.Timestamp
+	9,  // 4: calc.ListExpressionsReq.created_after:type_name -> google.protobuf.Timestamp
+	9,  // 5: calc.ListExpressionsReq.created_before:type_name -> google.protobuf.Timestamp
+	5,  // 6: calc.ListExpressionsResp.expressions:type_name -> calc.Expression
+	0,  // 7: calc.CalcAPI.Register:input_type -> calc.Credentials
+	0,  // 8: calc.CalcAPI.Login:input_type -> calc.Credentials
+	2,  // 9: calc.CalcAPI.Calculate:input_type -> calc.CalculateReq
+	4,  // 10: calc.CalcAPI.GetExpression:input_type -> calc.ExpressionReq
+	6,  // 11: calc.CalcAPI.ListExpressions:input_type -> calc.ListExpressionsReq
+	4,  // 12: calc.CalcAPI.CancelExpression:input_type -> calc.ExpressionReq
+	4,  // 13: calc.CalcAPI.WatchExpression:input_type -> calc.ExpressionReq
+	10, // 14: calc.CalcAPI.Register:output_type -> calc.Empty
+	1,  // 15: calc.CalcAPI.Login:output_type -> calc.LoginResp
+	3,  // 16: calc.CalcAPI.Calculate:output_type -> calc.CalculateResp
+	5,  // 17: calc.CalcAPI.GetExpression:output_type -> calc.Expression
+	7,  // 18: calc.CalcAPI.ListExpressions:output_type -> calc.ListExpressionsResp
+	5,  // 19: calc.CalcAPI.CancelExpression:output_type -> calc.Expression
+	5,  // 20: calc.CalcAPI.WatchExpression:output_type -> calc.Expression
+	14, // [14:21] is the sub-list for method output_type
+	7,  // [7:14] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_proto_calc_api_proto_init() }
+func file_proto_calc_api_proto_init() {
+	if File_proto_calc_api_proto != nil {
+		return
+	}
+	file_proto_calc_proto_init()
+	file_proto_calc_api_proto_msgTypes[5].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_calc_api_proto_rawDesc), len(file_proto_calc_api_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_calc_api_proto_goTypes,
+		DependencyIndexes: file_proto_calc_api_proto_depIdxs,
+		MessageInfos:      file_proto_calc_api_proto_msgTypes,
+	}.Build()
+	File_proto_calc_api_proto = out.File
+	file_proto_calc_api_proto_goTypes = nil
+	file_proto_calc_api_proto_depIdxs = nil
+}
@@ -0,0 +1,369 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v6.31.0--rc2
+// source: proto/calc_api.proto
+
+package calc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	CalcAPI_Register_FullMethodName         = "/calc.CalcAPI/Register"
+	CalcAPI_Login_FullMethodName            = "/calc.CalcAPI/Login"
+	CalcAPI_Calculate_FullMethodName        = "/calc.CalcAPI/Calculate"
+	CalcAPI_GetExpression_FullMethodName    = "/calc.CalcAPI/GetExpression"
+	CalcAPI_ListExpressions_FullMethodName  = "/calc.CalcAPI/ListExpressions"
+	CalcAPI_CancelExpression_FullMethodName = "/calc.CalcAPI/CancelExpression"
+	CalcAPI_WatchExpression_FullMethodName  = "/calc.CalcAPI/WatchExpression"
+)
+
+// CalcAPIClient is the client API for CalcAPI service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// CalcAPI is the client API: the same operations as the HTTP API under
+// /api/v1. Every method except Register and Login needs the
+// "authorization: Bearer <token>" metadata.
+type CalcAPIClient interface {
+	Register(ctx context.Context, in *Credentials, opts ...grpc.CallOption) (*Empty, error)
+	Login(ctx context.Context, in *Credentials, opts ...grpc.CallOption) (*LoginResp, error)
+	Calculate(ctx context.Context, in *CalculateReq, opts ...grpc.CallOption) (*CalculateResp, error)
+	GetExpression(ctx context.Context, in *ExpressionReq, opts ...grpc.CallOption) (*Expression, error)
+	ListExpressions(ctx context.Context, in *ListExpressionsReq, opts ...grpc.CallOption) (*ListExpressionsResp, error)
+	// CancelExpression stops a pending expression; FAILED_PRECONDITION if
+	// it is no longer pending.
+	CancelExpression(ctx context.Context, in *ExpressionReq, opts ...grpc.CallOption) (*Expression, error)
+	// WatchExpression sends the expression now and on every status change
+	// until it is done.
+	WatchExpression(ctx context.Context, in *ExpressionReq, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Expression], error)
+}
+
+type calcAPIClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCalcAPIClient(cc grpc.ClientConnInterface) CalcAPIClient {
+	return &calcAPIClient{cc}
+}
+
+func (c *calcAPIClient) Register(ctx context.Context, in *Credentials, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, CalcAPI_Register_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calcAPIClient) Login(ctx context.Context, in *Credentials, opts ...grpc.CallOption) (*LoginResp, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LoginResp)
+	err := c.cc.Invoke(ctx, CalcAPI_Login_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calcAPIClient) Calculate(ctx context.Context, in *CalculateReq, opts ...grpc.CallOption) (*CalculateResp, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CalculateResp)
+	err := c.cc.Invoke(ctx, CalcAPI_Calculate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calcAPIClient) GetExpression(ctx context.Context, in *ExpressionReq, opts ...grpc.CallOption) (*Expression, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Expression)
+	err := c.cc.Invoke(ctx, CalcAPI_GetExpression_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calcAPIClient) ListExpressions(ctx context.Context, in *ListExpressionsReq, opts ...grpc.CallOption) (*ListExpressionsResp, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListExpressionsResp)
+	err := c.cc.Invoke(ctx, CalcAPI_ListExpressions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calcAPIClient) CancelExpression(ctx context.Context, in *ExpressionReq, opts ...grpc.CallOption) (*Expression, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Expression)
+	err := c.cc.Invoke(ctx, CalcAPI_CancelExpression_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calcAPIClient) WatchExpression(ctx context.Context, in *ExpressionReq, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Expression], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CalcAPI_ServiceDesc.Streams[0], CalcAPI_WatchExpression_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExpressionReq, Expression]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CalcAPI_WatchExpressionClient = grpc.ServerStreamingClient[Expression]
+
+// CalcAPIServer is the server API for CalcAPI service.
+// All implementations must embed UnimplementedCalcAPIServer
+// for forward compatibility.
+//
+// CalcAPI is the client API: the same operations as the HTTP API under
+// /api/v1. Every method except Register and Login needs the
+// "authorization: Bearer <token>" metadata.
+type CalcAPIServer interface {
+	Register(context.Context, *Credentials) (*Empty, error)
+	Login(context.Context, *Credentials) (*LoginResp, error)
+	Calculate(context.Context, *CalculateReq) (*CalculateResp, error)
+	GetExpression(context.Context, *ExpressionReq) (*Expression, error)
+	ListExpressions(context.Context, *ListExpressionsReq) (*ListExpressionsResp, error)
+	// CancelExpression stops a pending expression; FAILED_PRECONDITION if
+	// it is no longer pending.
+	CancelExpression(context.Context, *ExpressionReq) (*Expression, error)
+	// WatchExpression sends the expression now and on every status change
+	// until it is done.
+	WatchExpression(*ExpressionReq, grpc.ServerStreamingServer[Expression]) error
+	mustEmbedUnimplementedCalcAPIServer()
+}
+
+// UnimplementedCalcAPIServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCalcAPIServer struct{}
+
+func (UnimplementedCalcAPIServer) Register(context.Context, *Credentials) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedCalcAPIServer) Login(context.Context, *Credentials) (*LoginResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Login not implemented")
+}
+func (UnimplementedCalcAPIServer) Calculate(context.Context, *CalculateReq) (*CalculateResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Calculate not implemented")
+}
+func (UnimplementedCalcAPIServer) GetExpression(context.Context, *ExpressionReq) (*Expression, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetExpression not implemented")
+}
+func (UnimplementedCalcAPIServer) ListExpressions(context.Context, *ListExpressionsReq) (*ListExpressionsResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListExpressions not implemented")
+}
+func (UnimplementedCalcAPIServer) CancelExpression(context.Context, *ExpressionReq) (*Expression, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelExpression not implemented")
+}
+func (UnimplementedCalcAPIServer) WatchExpression(*ExpressionReq, grpc.ServerStreamingServer[Expression]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchExpression not implemented")
+}
+func (UnimplementedCalcAPIServer) mustEmbedUnimplementedCalcAPIServer() {}
+func (UnimplementedCalcAPIServer) testEmbeddedByValue()                 {}
+
+// UnsafeCalcAPIServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CalcAPIServer will
+// result in compilation errors.
+type UnsafeCalcAPIServer interface {
+	mustEmbedUnimplementedCalcAPIServer()
+}
+
+func RegisterCalcAPIServer(s grpc.ServiceRegistrar, srv CalcAPIServer) {
+	// If the following call pancis, it indicates UnimplementedCalcAPIServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&CalcAPI_ServiceDesc, srv)
+}
+
+func _CalcAPI_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Credentials)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcAPIServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalcAPI_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcAPIServer).Register(ctx, req.(*Credentials))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalcAPI_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Credentials)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcAPIServer).Login(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalcAPI_Login_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcAPIServer).Login(ctx, req.(*Credentials))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalcAPI_Calculate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CalculateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcAPIServer).Calculate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalcAPI_Calculate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcAPIServer).Calculate(ctx, req.(*CalculateReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalcAPI_GetExpression_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpressionReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcAPIServer).GetExpression(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalcAPI_GetExpression_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcAPIServer).GetExpression(ctx, req.(*ExpressionReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalcAPI_ListExpressions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListExpressionsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcAPIServer).ListExpressions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalcAPI_ListExpressions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcAPIServer).ListExpressions(ctx, req.(*ListExpressionsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalcAPI_CancelExpression_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpressionReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcAPIServer).CancelExpression(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalcAPI_CancelExpression_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcAPIServer).CancelExpression(ctx, req.(*ExpressionReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalcAPI_WatchExpression_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExpressionReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CalcAPIServer).WatchExpression(m, &grpc.GenericServerStream[ExpressionReq, Expression]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CalcAPI_WatchExpressionServer = grpc.ServerStreamingServer[Expression]
+
+// CalcAPI_ServiceDesc is the grpc.ServiceDesc for CalcAPI service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CalcAPI_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "calc.CalcAPI",
+	HandlerType: (*CalcAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _CalcAPI_Register_Handler,
+		},
+		{
+			MethodName: "Login",
+			Handler:    _CalcAPI_Login_Handler,
+		},
+		{
+			MethodName: "Calculate",
+			Handler:    _CalcAPI_Calculate_Handler,
+		},
+		{
+			MethodName: "GetExpression",
+			Handler:    _CalcAPI_GetExpression_Handler,
+		},
+		{
+			MethodName: "ListExpressions",
+			Handler:    _CalcAPI_ListExpressions_Handler,
+		},
+		{
+			MethodName: "CancelExpression",
+			Handler:    _CalcAPI_CancelExpression_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchExpression",
+			Handler:       _CalcAPI_WatchExpression_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/calc_api.proto",
+}
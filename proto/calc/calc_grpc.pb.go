@@ -1,159 +1,201 @@
-// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
-// versions:
-// - protoc-gen-go-grpc v1.5.1
-// - protoc             v6.31.0--rc2
-// source: proto/calc.proto
-
-package calc
-
-import (
-	context "context"
-	grpc "google.golang.org/grpc"
-	codes "google.golang.org/grpc/codes"
-	status "google.golang.org/grpc/status"
-)
-
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-// Requires gRPC-Go v1.64.0 or later.
-const _ = grpc.SupportPackageIsVersion9
-
-const (
-	Calc_GetTask_FullMethodName    = "/calc.Calc/GetTask"
-	Calc_PostResult_FullMethodName = "/calc.Calc/PostResult"
-)
-
-// CalcClient is the client API for Calc service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
-type CalcClient interface {
-	GetTask(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*TaskResp, error)
-	PostResult(ctx context.Context, in *ResultReq, opts ...grpc.CallOption) (*Empty, error)
-}
-
-type calcClient struct {
-	cc grpc.ClientConnInterface
-}
-
-func NewCalcClient(cc grpc.ClientConnInterface) CalcClient {
-	return &calcClient{cc}
-}
-
-func (c *calcClient) GetTask(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*TaskResp, error) {
-	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	out := new(TaskResp)
-	err := c.cc.Invoke(ctx, Calc_GetTask_FullMethodName, in, out, cOpts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *calcClient) PostResult(ctx context.Context, in *ResultReq, opts ...grpc.CallOption) (*Empty, error) {
-	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	out := new(Empty)
-	err := c.cc.Invoke(ctx, Calc_PostResult_FullMethodName, in, out, cOpts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-// CalcServer is the server API for Calc service.
-// All implementations must embed UnimplementedCalcServer
-// for forward compatibility.
-type CalcServer interface {
-	GetTask(context.Context, *Empty) (*TaskResp, error)
-	PostResult(context.Context, *ResultReq) (*Empty, error)
-	mustEmbedUnimplementedCalcServer()
-}
-
-// UnimplementedCalcServer must be embedded to have
-// forward compatible implementations.
-//
-// NOTE: this should be embedded by value instead of pointer to avoid a nil
-// pointer dereference when methods are called.
-type UnimplementedCalcServer struct{}
-
-func (UnimplementedCalcServer) GetTask(context.Context, *Empty) (*TaskResp, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetTask not implemented")
-}
-func (UnimplementedCalcServer) PostResult(context.Context, *ResultReq) (*Empty, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method PostResult not implemented")
-}
-func (UnimplementedCalcServer) mustEmbedUnimplementedCalcServer() {}
-func (UnimplementedCalcServer) testEmbeddedByValue()              {}
-
-// UnsafeCalcServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to CalcServer will
-// result in compilation errors.
-type UnsafeCalcServer interface {
-	mustEmbedUnimplementedCalcServer()
-}
-
-func RegisterCalcServer(s grpc.ServiceRegistrar, srv CalcServer) {
-	// If the following call pancis, it indicates UnimplementedCalcServer was
-	// embedded by pointer and is nil.  This will cause panics if an
-	// unimplemented method is ever invoked, so we test this at initialization
-	// time to prevent it from happening at runtime later due to I/O.
-	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
-		t.testEmbeddedByValue()
-	}
-	s.RegisterService(&Calc_ServiceDesc, srv)
-}
-
-func _Calc_GetTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Empty)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(CalcServer).GetTask(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: Calc_GetTask_FullMethodName,
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(CalcServer).GetTask(ctx, req.(*Empty))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _Calc_PostResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ResultReq)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(CalcServer).PostResult(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: Calc_PostResult_FullMethodName,
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(CalcServer).PostResult(ctx, req.(*ResultReq))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-// Calc_ServiceDesc is the grpc.ServiceDesc for Calc service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var Calc_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "calc.Calc",
-	HandlerType: (*CalcServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "GetTask",
-			Handler:    _Calc_GetTask_Handler,
-		},
-		{
-			MethodName: "PostResult",
-			Handler:    _Calc_PostResult_Handler,
-		},
-	},
-	Streams:  []grpc.StreamDesc{},
-	Metadata: "proto/calc.proto",
-}
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v6.31.0--rc2
+// source: proto/calc.proto
+
+package calc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Calc_GetTask_FullMethodName     = "/calc.Calc/GetTask"
+	Calc_PostResult_FullMethodName  = "/calc.Calc/PostResult"
+	Calc_ReleaseTask_FullMethodName = "/calc.Calc/ReleaseTask"
+)
+
+// CalcClient is the client API for Calc service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CalcClient interface {
+	GetTask(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*TaskResp, error)
+	PostResult(ctx context.Context, in *ResultReq, opts ...grpc.CallOption) (*Empty, error)
+	// ReleaseTask puts a task the agent took back in the queue, e.g. when
+	// the agent shuts down before finishing it.
+	ReleaseTask(ctx context.Context, in *ReleaseReq, opts ...grpc.CallOption) (*Empty, error)
+}
+
+type calcClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCalcClient(cc grpc.ClientConnInterface) CalcClient {
+	return &calcClient{cc}
+}
+
+func (c *calcClient) GetTask(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*TaskResp, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TaskResp)
+	err := c.cc.Invoke(ctx, Calc_GetTask_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calcClient) PostResult(ctx context.Context, in *ResultReq, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Calc_PostResult_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calcClient) ReleaseTask(ctx context.Context, in *ReleaseReq, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Calc_ReleaseTask_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CalcServer is the server API for Calc service.
+// All implementations must embed UnimplementedCalcServer
+// for forward compatibility.
+type CalcServer interface {
+	GetTask(context.Context, *Empty) (*TaskResp, error)
+	PostResult(context.Context, *ResultReq) (*Empty, error)
+	// ReleaseTask puts a task the agent took back in the queue, e.g. when
+	// the agent shuts down before finishing it.
+	ReleaseTask(context.Context, *ReleaseReq) (*Empty, error)
+	mustEmbedUnimplementedCalcServer()
+}
+
+// UnimplementedCalcServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCalcServer struct{}
+
+func (UnimplementedCalcServer) GetTask(context.Context, *Empty) (*TaskResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTask not implemented")
+}
+func (UnimplementedCalcServer) PostResult(context.Context, *ResultReq) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PostResult not implemented")
+}
+func (UnimplementedCalcServer) ReleaseTask(context.Context, *ReleaseReq) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseTask not implemented")
+}
+func (UnimplementedCalcServer) mustEmbedUnimplementedCalcServer() {}
+func (UnimplementedCalcServer) testEmbeddedByValue()              {}
+
+// UnsafeCalcServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CalcServer will
+// result in compilation errors.
+type UnsafeCalcServer interface {
+	mustEmbedUnimplementedCalcServer()
+}
+
+func RegisterCalcServer(s grpc.ServiceRegistrar, srv CalcServer) {
+	// If the following call pancis, it indicates UnimplementedCalcServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Calc_ServiceDesc, srv)
+}
+
+func _Calc_GetTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcServer).GetTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calc_GetTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcServer).GetTask(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calc_PostResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResultReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcServer).PostResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calc_PostResult_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcServer).PostResult(ctx, req.(*ResultReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calc_ReleaseTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalcServer).ReleaseTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calc_ReleaseTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalcServer).ReleaseTask(ctx, req.(*ReleaseReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Calc_ServiceDesc is the grpc.ServiceDesc for Calc service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Calc_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "calc.Calc",
+	HandlerType: (*CalcServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetTask",
+			Handler:    _Calc_GetTask_Handler,
+		},
+		{
+			MethodName: "PostResult",
+			Handler:    _Calc_PostResult_Handler,
+		},
+		{
+			MethodName: "ReleaseTask",
+			Handler:    _Calc_ReleaseTask_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/calc.proto",
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func doJSON(t *testing.T, h http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func registerAndLogin(t *testing.T, h http.Handler, login, password string) string {
+	t.Helper()
+	creds := map[string]string{"login": login, "password": password}
+	if rec := doJSON(t, h, "POST", "/api/v1/register", "", creds); rec.Code != http.StatusOK {
+		t.Fatalf("register: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := doJSON(t, h, "POST", "/api/v1/login", "", creds)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct{ Token string }
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Token
+}
+
+func TestValidateCredentials(t *testing.T) {
+	tests := []struct {
+		login, password string
+		want            error
+	}{
+		{"alice", "secret123", nil},
+		{"", "secret123", application.ErrLoginLength},
+		{"al", "secret123", application.ErrLoginLength},
+		{"alice bob", "secret123", application.ErrLoginCharset},
+		{"alice", "", application.ErrPasswordLength},
+		{"alice", "short1", application.ErrPasswordLength},
+		{"alice", "onlyletters", application.ErrPasswordWeak},
+		{"alice", "12345678", application.ErrPasswordWeak},
+		{"alice123", "alice123", application.ErrPasswordIsLogin},
+	}
+	for _, tc := range tests {
+		if got := application.ValidateCredentials(tc.login, tc.password); got != tc.want {
+			t.Errorf("ValidateCredentials(%q, %q) = %v; want %v", tc.login, tc.password, got, tc.want)
+		}
+	}
+}
+
+func TestRegister_RejectsInvalidCredentials(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+
+	rec := doJSON(t, h, "POST", "/api/v1/register", "", map[string]string{"login": "", "password": ""})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	var n int
+	orch.DB.Get(&n, "SELECT COUNT(*) FROM users")
+	if n != 0 {
+		t.Errorf("expected no users, got %d", n)
+	}
+}
+
+func TestLogin_Lockout(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.MaxLoginAttempts = 3
+	orch.Config.LockoutDuration = time.Minute
+	h := orch.Routes()
+	registerAndLogin(t, h, "alice", "secret123")
+
+	wrong := map[string]string{"login": "alice", "password": "wrong1234"}
+	for i := 0; i < 3; i++ {
+		if rec := doJSON(t, h, "POST", "/api/v1/login", "", wrong); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, rec.Code)
+		}
+	}
+	right := map[string]string{"login": "alice", "password": "secret123"}
+	rec := doJSON(t, h, "POST", "/api/v1/login", "", right)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 while locked, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+
+	orch.DB.MustExec("UPDATE users SET locked_until = ? WHERE login = 'alice'", time.Now().Add(-time.Second).Unix())
+	if rec := doJSON(t, h, "POST", "/api/v1/login", "", right); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 after lock expiry, got %d", rec.Code)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	rec := doJSON(t, h, "POST", "/api/v1/password", tok, map[string]string{"old_password": "nope12345", "new_password": "another123"})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong old password: expected 401, got %d", rec.Code)
+	}
+	rec = doJSON(t, h, "POST", "/api/v1/password", tok, map[string]string{"old_password": "secret123", "new_password": "weak"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("weak new password: expected 400, got %d", rec.Code)
+	}
+	rec = doJSON(t, h, "POST", "/api/v1/password", tok, map[string]string{"old_password": "secret123", "new_password": "another123"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := doJSON(t, h, "POST", "/api/v1/login", "", map[string]string{"login": "alice", "password": "secret123"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("old password: expected 401, got %d", rec.Code)
+	}
+	if rec := doJSON(t, h, "POST", "/api/v1/login", "", map[string]string{"login": "alice", "password": "another123"}); rec.Code != http.StatusOK {
+		t.Errorf("new password: expected 200, got %d", rec.Code)
+	}
+
+	// tokens issued before the change are revoked
+	if rec := doJSON(t, h, "GET", "/api/v1/expressions", tok, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("old token: expected 401, got %d", rec.Code)
+	}
+	var resp struct{ Token string }
+	json.NewDecoder(doJSON(t, h, "POST", "/api/v1/login", "", map[string]string{"login": "alice", "password": "another123"}).Body).Decode(&resp)
+	if rec := doJSON(t, h, "GET", "/api/v1/expressions", resp.Token, nil); rec.Code != http.StatusOK {
+		t.Errorf("new token: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	if rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+2*3"}); rec.Code != http.StatusCreated {
+		t.Fatalf("calculate: expected 201, got %d", rec.Code)
+	}
+	if rec := doJSON(t, h, "DELETE", "/api/v1/account", tok, map[string]string{"password": "bad12345"}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: expected 401, got %d", rec.Code)
+	}
+	if rec := doJSON(t, h, "DELETE", "/api/v1/account", tok, map[string]string{"password": "secret123"}); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	// the token of a deleted user is no longer accepted
+	if rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+2"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("calculate after delete: expected 401, got %d", rec.Code)
+	}
+	if rec := doJSON(t, h, "GET", "/api/v1/expressions", tok, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("list after delete: expected 401, got %d", rec.Code)
+	}
+
+	for _, table := range []string{"users", "expressions", "tasks"} {
+		var n int
+		orch.DB.Get(&n, "SELECT COUNT(*) FROM "+table)
+		if n != 0 {
+			t.Errorf("expected %s to be empty, got %d rows", table, n)
+		}
+	}
+}
@@ -1,99 +1,211 @@
-package tests
-
-import (
-	"context"
-	"net"
-	"os"
-	"testing"
-	"time"
-
-	"github.com/lollmark/digital_calc/internal"
-	"github.com/lollmark/digital_calc/proto/calc"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
-)
-
-type fakeServer struct {
-	calc.UnimplementedCalcServer
-	taskCalled       bool
-	postResultCalled bool
-	task             *calc.TaskResp
-	resultReq        *calc.ResultReq
-}
-
-func (f *fakeServer) GetTask(ctx context.Context, _ *calc.Empty) (*calc.TaskResp, error) {
-	f.taskCalled = true
-	if f.task == nil {
-		return nil, status.Error(codes.NotFound, "no task")
-	}
-	return f.task, nil
-}
-
-func (f *fakeServer) PostResult(ctx context.Context, in *calc.ResultReq) (*calc.Empty, error) {
-	f.postResultCalled = true
-	f.resultReq = in
-	return &calc.Empty{}, nil
-}
-
-func TestAgent_WorkerFlow(t *testing.T) {
-	lis, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("failed to listen: %v", err)
-	}
-	grpcServer := grpc.NewServer()
-	fake := &fakeServer{
-		task: &calc.TaskResp{
-			Id:            "task1",
-			Arg1:          2,
-			Arg2:          3,
-			Operation:     "*",
-			OperationTime: 10, 
-		},
-	}
-	calc.RegisterCalcServer(grpcServer, fake)
-	go grpcServer.Serve(lis)
-	defer grpcServer.Stop()
-
-	existing := setEnv("ORCHESTRATOR_URL", "http://"+lis.Addr().String())
-	defer restoreEnv("ORCHESTRATOR_URL", existing)
-
-	agent := application.NewAgent()
-	agent.ComputingPower = 1
-
-	done := make(chan struct{})
-	go func() {
-		agent.Worker(0)
-		close(done)
-	}()
-
-	time.Sleep(50 * time.Millisecond)
-
-	if !fake.taskCalled {
-		t.Error("expected GetTask to be called")
-	}
-	if !fake.postResultCalled {
-		t.Error("expected PostResult to be called")
-	}
-
-	if fake.resultReq.Id != "task1" {
-		t.Errorf("expected result id 'task1', got %s", fake.resultReq.Id)
-	}
-	if fake.resultReq.Result != 6 {
-		t.Errorf("expected result 6, got %f", fake.resultReq.Result)
-	}
-}
-
-func setEnv(key, val string) string {
-	old := os.Getenv(key)
-	os.Setenv(key, val)
-	return old
-}
-
-func restoreEnv(key, val string) {
-	if val == "" {
-		os.Unsetenv(key)
-	} else {
-		os.Setenv(key, val)
-	}
-}
+package tests
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lollmark/digital_calc/internal"
+	"github.com/lollmark/digital_calc/proto/calc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeServer struct {
+	calc.UnimplementedCalcServer
+	taskCalled       bool
+	postResultCalled bool
+	task             *calc.TaskResp
+	resultReq        *calc.ResultReq
+}
+
+func (f *fakeServer) GetTask(ctx context.Context, _ *calc.Empty) (*calc.TaskResp, error) {
+	f.taskCalled = true
+	if f.task == nil {
+		return nil, status.Error(codes.NotFound, "no task")
+	}
+	return f.task, nil
+}
+
+func (f *fakeServer) PostResult(ctx context.Context, in *calc.ResultReq) (*calc.Empty, error) {
+	f.postResultCalled = true
+	f.resultReq = in
+	return &calc.Empty{}, nil
+}
+
+func TestAgent_WorkerFlow(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	fake := &fakeServer{
+		task: &calc.TaskResp{
+			Id:            "task1",
+			Arg1:          2,
+			Arg2:          3,
+			Operation:     "*",
+			OperationTime: 10, 
+		},
+	}
+	calc.RegisterCalcServer(grpcServer, fake)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	existing := setEnv("ORCHESTRATOR_URL", "http://"+lis.Addr().String())
+	defer restoreEnv("ORCHESTRATOR_URL", existing)
+
+	agent := application.NewAgent()
+	agent.ComputingPower = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		agent.Worker(ctx, 0)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if !fake.taskCalled {
+		t.Error("expected GetTask to be called")
+	}
+	if !fake.postResultCalled {
+		t.Error("expected PostResult to be called")
+	}
+
+	if fake.resultReq.Id != "task1" {
+		t.Errorf("expected result id 'task1', got %s", fake.resultReq.Id)
+	}
+	if fake.resultReq.Result != 6 {
+		t.Errorf("expected result 6, got %f", fake.resultReq.Result)
+	}
+}
+
+// startCalcServer serves the agent service of orch and points new agents
+// at it.
+func startCalcServer(t *testing.T, orch *application.Orchestrator) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	calc.RegisterCalcServer(srv, orch)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	t.Setenv("ORCHESTRATOR_URL", "http://"+lis.Addr().String())
+}
+
+// runAgentUntilTaskTaken starts an agent with one worker and returns
+// once it holds a task; cancel stops it and Run's end closes done.
+func runAgentUntilTaskTaken(t *testing.T, orch *application.Orchestrator, agent *application.Agent) (cancel func(), done chan struct{}) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done = make(chan struct{})
+	go func() {
+		agent.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var n int
+		if err := orch.DB.Get(&n, "SELECT COUNT(*) FROM tasks WHERE in_progress = 1"); err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 {
+			return cancel, done
+		}
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatal("the agent took no task")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAgent_FinishesTaskOnShutdown(t *testing.T) {
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "300")
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	startCalcServer(t, orch)
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "2*3"})
+
+	agent := application.NewAgent()
+	agent.ComputingPower = 1
+	agent.ShutdownTimeout = 5 * time.Second
+	cancel, done := runAgentUntilTaskTaken(t, orch, agent)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the agent did not stop")
+	}
+
+	var e struct {
+		Status string   `db:"status"`
+		Result *float64 `db:"result"`
+	}
+	if err := orch.DB.Get(&e, "SELECT status, result FROM expressions"); err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != "done" || e.Result == nil || *e.Result != 6 {
+		t.Errorf("expected the task in flight to be finished, got %s %v", e.Status, e.Result)
+	}
+}
+
+func TestAgent_ReleasesTaskOnShutdown(t *testing.T) {
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "10000")
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	startCalcServer(t, orch)
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "2*3"})
+
+	agent := application.NewAgent()
+	agent.ComputingPower = 1
+	agent.ShutdownTimeout = 50 * time.Millisecond
+	cancel, done := runAgentUntilTaskTaken(t, orch, agent)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the agent did not stop")
+	}
+
+	var task struct {
+		InProgress bool    `db:"in_progress"`
+		Done       bool    `db:"done"`
+		AgentID    *string `db:"agent_id"`
+	}
+	if err := orch.DB.Get(&task, "SELECT in_progress, done, agent_id FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if task.InProgress || task.Done || task.AgentID != nil {
+		t.Errorf("expected the task back in the queue, got %+v", task)
+	}
+	if n := runTasks(t, orch, "agent-2"); n != 1 {
+		t.Errorf("expected another agent to take the released task, got %d tasks", n)
+	}
+}
+
+func setEnv(key, val string) string {
+	old := os.Getenv(key)
+	os.Setenv(key, val)
+	return old
+}
+
+func restoreEnv(key, val string) {
+	if val == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, val)
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) application.ErrorInfo {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected a JSON error, got %q: %s", ct, rec.Body)
+	}
+	var body application.ErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Error
+}
+
+func postRaw(h http.Handler, path, tok, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if tok != "" {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIError_Envelope(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+
+	rec := doJSON(t, h, "POST", "/api/v1/calculate", "", map[string]string{"expression": "1+1"})
+	if e := decodeError(t, rec); rec.Code != http.StatusUnauthorized || e.Code != application.CodeMissingToken {
+		t.Errorf("expected 401 missing_token, got %d %+v", rec.Code, e)
+	}
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	rec = doJSON(t, h, "POST", "/api/v1/register", "", map[string]string{"login": "alice", "password": "secret123"})
+	if e := decodeError(t, rec); rec.Code != http.StatusConflict || e.Code != application.CodeUserExists || e.Message == "" {
+		t.Errorf("expected 409 user_exists, got %d %+v", rec.Code, e)
+	}
+	rec = doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1/0"})
+	if e := decodeError(t, rec); rec.Code != http.StatusUnprocessableEntity || e.Code != application.CodeInvalidExpression {
+		t.Errorf("expected 422 invalid_expression, got %d %+v", rec.Code, e)
+	}
+	rec = doJSON(t, h, "GET", "/api/v1/nope", tok, nil)
+	if e := decodeError(t, rec); rec.Code != http.StatusNotFound || e.Code != application.CodeNotFound {
+		t.Errorf("expected 404 not_found, got %d %+v", rec.Code, e)
+	}
+}
+
+func TestAPIError_MethodNotAllowed(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+
+	for _, c := range []struct{ method, path, allow string }{
+		{"GET", "/api/v1/calculate", "POST"},
+		{"GET", "/api/v1/login", "POST"},
+		{"POST", "/api/v1/expressions", "GET"},
+		{"DELETE", "/api/v1/expressions/1", "GET"},
+		{"GET", "/api/v1/expressions/1/cancel", "POST"},
+		{"POST", "/api/v1/account", "DELETE"},
+	} {
+		rec := doJSON(t, h, c.method, c.path, "", nil)
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: expected 405, got %d", c.method, c.path, rec.Code)
+			continue
+		}
+		if got := rec.Header().Get("Allow"); got != c.allow {
+			t.Errorf("%s %s: expected Allow %q, got %q", c.method, c.path, c.allow, got)
+		}
+		if e := decodeError(t, rec); e.Code != application.CodeMethodNotAllowed {
+			t.Errorf("%s %s: unexpected error %+v", c.method, c.path, e)
+		}
+	}
+}
+
+func TestAPIError_StrictDecoding(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.MaxBodyBytes = 256
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	for name, body := range map[string]string{
+		"empty":         ``,
+		"malformed":     `{"expression":`,
+		"unknown field": `{"expression":"1+1","precision":2}`,
+		"wrong type":    `{"expression":5}`,
+		"trailing data": `{"expression":"1+1"} {"expression":"2+2"}`,
+	} {
+		rec := postRaw(h, "/api/v1/calculate", tok, body)
+		if e := decodeError(t, rec); rec.Code != http.StatusBadRequest || e.Code != application.CodeInvalidJSON {
+			t.Errorf("%s: expected 400 invalid_json, got %d %+v", name, rec.Code, e)
+		}
+	}
+	rec := postRaw(h, "/api/v1/login", "", `{"login":"alice","password":"secret123","remember":true}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("login with an unknown field: expected 400, got %d", rec.Code)
+	}
+
+	big := `{"expression":"` + strings.Repeat("1+", 200) + `1"}`
+	rec = postRaw(h, "/api/v1/calculate", tok, big)
+	if e := decodeError(t, rec); rec.Code != http.StatusRequestEntityTooLarge || e.Code != application.CodeBodyTooLarge {
+		t.Errorf("expected 413 body_too_large, got %d %+v", rec.Code, e)
+	}
+	req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(big))
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Idempotency-Key", "k")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("with Idempotency-Key: expected 413, got %d", rec.Code)
+	}
+	var n int
+	orch.DB.Get(&n, "SELECT COUNT(*) FROM expressions")
+	if n != 0 {
+		t.Errorf("rejected requests must not store expressions, got %d", n)
+	}
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func TestBatch_SubmitAndPoll(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	rec := doJSON(t, h, "POST", "/api/v1/calculate/batch", tok, map[string]interface{}{
+		"items": []map[string]string{
+			{"client_id": "A1", "expression": "1+2"},
+			{"client_id": "A2", "expression": "(1+2"},
+			{"client_id": "A3", "expression": "42"},
+			{"expression": "2*(3+4)"},
+		},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp application.BatchResp
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Items) != 4 {
+		t.Fatalf("expected 4 items, got %d", len(resp.Items))
+	}
+	if it := resp.Items[1]; it.ClientID != "A2" || it.Error == nil || it.ID != nil {
+		t.Errorf("expected a parse error for A2, got %+v", it)
+	}
+	for _, i := range []int{0, 2, 3} {
+		if resp.Items[i].ID == nil {
+			t.Errorf("item %d: expected an id, got error %v", i, *resp.Items[i].Error)
+		}
+	}
+
+	path := fmt.Sprintf("/api/v1/batches/%d", resp.BatchID)
+	var b application.Batch
+	json.NewDecoder(doJSON(t, h, "GET", path, tok, nil).Body).Decode(&b)
+	if b.Total != 4 || b.Failed != 1 || b.Done != 1 || b.Pending != 2 {
+		t.Errorf("expected 4 total, 1 failed, 1 done, 2 pending; got %+v", b)
+	}
+
+	runTasks(t, orch, "agent")
+	b = application.Batch{}
+	json.NewDecoder(doJSON(t, h, "GET", path, tok, nil).Body).Decode(&b)
+	if b.Done != 3 || b.Pending != 0 {
+		t.Errorf("expected 3 done, got %+v", b)
+	}
+	if r := b.Items[3].Result; r == nil || *r != 14 {
+		t.Errorf("expected 14 for the last item, got %v", r)
+	}
+
+	other := registerAndLogin(t, h, "bob", "secret123")
+	if rec := doJSON(t, h, "GET", path, other, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("foreign batch: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestBatch_Limits(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.MaxBatchSize = 2
+	orch.Config.MaxPendingExpressions = 1
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	item := map[string]string{"expression": "1+1"}
+	tests := []struct {
+		items []map[string]string
+		want  int
+	}{
+		{nil, http.StatusBadRequest},
+		{[]map[string]string{item, item, item}, http.StatusBadRequest},
+		{[]map[string]string{item, item}, http.StatusTooManyRequests},
+	}
+	for _, tc := range tests {
+		rec := doJSON(t, h, "POST", "/api/v1/calculate/batch", tok, map[string]interface{}{"items": tc.items})
+		if rec.Code != tc.want {
+			t.Errorf("%d items: expected %d, got %d", len(tc.items), tc.want, rec.Code)
+		}
+	}
+	var n int
+	orch.DB.Get(&n, "SELECT COUNT(*) FROM expressions")
+	if n != 0 {
+		t.Errorf("rejected batches must not store expressions, got %d", n)
+	}
+}
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// calcctl builds cmd/calcctl and returns a function running it with its
+// own config directory, so that saved tokens do not leak between tests.
+func calcctl(t *testing.T, server string) func(stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "calcctl")
+	if out, err := exec.Command("go", "build", "-o", bin, "../cmd/calcctl").CombinedOutput(); err != nil {
+		t.Fatalf("build calcctl: %v\n%s", err, out)
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "CALCCTL_") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, "HOME="+dir, "XDG_CONFIG_HOME="+filepath.Join(dir, "config"), "CALCCTL_SERVER="+server)
+	return func(stdin string, args ...string) (string, string, int) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(bin, args...)
+		cmd.Env = env
+		cmd.Stdin = strings.NewReader(stdin)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		err := cmd.Run()
+		var exit *exec.ExitError
+		if err != nil && !errors.As(err, &exit) {
+			t.Fatal(err)
+		}
+		return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()
+	}
+}
+
+func TestCalcctl_Flow(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	srv := httptest.NewServer(orch.Routes())
+	defer srv.Close()
+	run := calcctl(t, srv.URL)
+
+	// flags may follow the positional argument
+	if out, errOut, code := run("", "register", "alice", "--password", "secret123"); code != 0 || out != "registered alice\n" {
+		t.Fatalf("register: code %d, %q, %q", code, out, errOut)
+	}
+	// without --password the password is the first line of stdin
+	if out, errOut, code := run("secret123\n", "login", "alice"); code != 0 || out != "logged in as alice\n" {
+		t.Fatalf("login: code %d, %q, %q", code, out, errOut)
+	}
+
+	out, errOut, code := run("", "-o", "json", "calc", "2*(3+4)")
+	var submitted struct{ ID int64 }
+	if code != 0 || json.Unmarshal([]byte(out), &submitted) != nil || submitted.ID != 1 {
+		t.Fatalf("calc: code %d, %q, %q", code, out, errOut)
+	}
+	runTasks(t, orch, "agent")
+
+	out, _, code = run("", "list")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if code != 0 || len(lines) != 2 || !strings.HasPrefix(lines[0], "ID  ") || !strings.Contains(lines[1], "done") || !strings.Contains(lines[1], "14") {
+		t.Errorf("list: code %d, %q", code, out)
+	}
+	out, _, code = run("", "show", "1", "--output", "json")
+	var d struct {
+		Expression struct {
+			Status string
+			Result *float64
+		}
+	}
+	if code != 0 || json.Unmarshal([]byte(out), &d) != nil || d.Expression.Status != "done" || d.Expression.Result == nil || *d.Expression.Result != 14 {
+		t.Errorf("show: code %d, %q", code, out)
+	}
+
+	// a literal is done at once, so --wait returns without an agent
+	if out, errOut, code := run("", "calc", "--wait", "5"); code != 0 || !strings.Contains(out, "done") || !strings.Contains(out, "  5  ") {
+		t.Errorf("calc --wait: code %d, %q, %q", code, out, errOut)
+	}
+	// everything after -- is positional, even if it looks like a flag
+	out, errOut, code = run("", "calc", "-o", "json", "--", "-1+2")
+	if code != 0 || json.Unmarshal([]byte(out), &submitted) != nil || submitted.ID != 3 {
+		t.Errorf("calc --: code %d, %q, %q", code, out, errOut)
+	}
+	if e, err := orch.GetExpression(1, 3); err != nil || e.Expr != "-1+2" {
+		t.Errorf("expected -1+2 to be submitted, got %+v, %v", e, err)
+	}
+}
+
+func TestCalcctl_ExitCodes(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	srv := httptest.NewServer(orch.Routes())
+	defer srv.Close()
+	run := calcctl(t, srv.URL)
+
+	tests := []struct {
+		args   []string
+		code   int
+		stderr string
+	}{
+		{nil, 2, "Usage: calcctl"},
+		{[]string{"frobnicate"}, 2, `unknown command "frobnicate"`},
+		{[]string{"--bogus", "list"}, 2, "flag provided but not defined"},
+		{[]string{"show"}, 2, "Usage: calcctl show [flags] <id>"},
+		{[]string{"show", "1", "2"}, 2, "Usage: calcctl show"},
+		{[]string{"calc", "-h"}, 2, "-wait"},
+		{[]string{"list", "-o", "yaml"}, 1, `unknown output format "yaml"`},
+		{[]string{"show", "abc"}, 1, `invalid expression id "abc"`},
+		{[]string{"list"}, 1, "not logged in to " + srv.URL},
+		{[]string{"login", "bob"}, 1, "no password given"},
+		{[]string{"login", "bob", "--password", "secret123"}, 1, "calcctl: "},
+	}
+	for _, tc := range tests {
+		_, errOut, code := run("", tc.args...)
+		if code != tc.code || !strings.Contains(errOut, tc.stderr) {
+			t.Errorf("%q: expected %d and %q, got %d and %q", tc.args, tc.code, tc.stderr, code, errOut)
+		}
+	}
+
+	run("", "register", "alice", "--password", "secret123")
+	run("", "login", "alice", "--password", "secret123")
+	if _, errOut, code := run("", "show", "999"); code != 1 || !strings.HasPrefix(errOut, "calcctl: ") {
+		t.Errorf("show 999: expected 1, got %d and %q", code, errOut)
+	}
+	if _, errOut, code := run("", "calc", "1/0"); code != 1 || !strings.HasPrefix(errOut, "calcctl: ") {
+		t.Errorf("calc 1/0: expected 1, got %d and %q", code, errOut)
+	}
+}
+
+func TestCalcctl_TokenPerServer(t *testing.T) {
+	orchA, teardownA := setupOrchestrator(t)
+	defer teardownA()
+	a := httptest.NewServer(orchA.Routes())
+	defer a.Close()
+	orchB, teardownB := setupOrchestrator(t)
+	defer teardownB()
+	b := httptest.NewServer(orchB.Routes())
+	defer b.Close()
+	run := calcctl(t, a.URL)
+
+	run("", "register", "alice", "--password", "secret123")
+	run("", "login", "alice", "--password", "secret123")
+	if _, errOut, code := run("", "list"); code != 0 {
+		t.Fatalf("list on A: code %d, %q", code, errOut)
+	}
+	if _, errOut, code := run("", "--server", b.URL, "list"); code != 1 || !strings.Contains(errOut, "not logged in to "+b.URL) {
+		t.Errorf("list on B before login: code %d, %q", code, errOut)
+	}
+
+	run("", "register", "bob", "--password", "secret123", "--server", b.URL)
+	if _, errOut, code := run("", "login", "bob", "--server", b.URL, "--password", "secret123"); code != 0 {
+		t.Fatalf("login on B: code %d, %q", code, errOut)
+	}
+	// logging in to B keeps the token saved for A
+	for _, server := range []string{a.URL, b.URL} {
+		if _, errOut, code := run("", "list", "--server", server); code != 0 {
+			t.Errorf("list on %s: code %d, %q", server, code, errOut)
+		}
+	}
+}
+
+func TestCalcctl_RemoteRepl(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	srv := httptest.NewServer(orch.Routes())
+	defer srv.Close()
+	run := calcctl(t, srv.URL)
+	run("", "register", "alice", "--password", "secret123")
+	run("", "login", "alice", "--password", "secret123")
+
+	// literals are done at once, so no agent is needed
+	out, errOut, code := run("5 m\nans * 2\n4\n:complex\n4i\nans\n", "repl", "--remote")
+	want := `5 m
+error: ans = 5 m cannot be sent to the orchestrator, which takes variables without units and imaginary parts
+4
+complex mode on
+0+4i
+error: ans = 0+4i cannot be sent to the orchestrator, which takes variables without units and imaginary parts
+`
+	if code != 0 || out != want {
+		t.Errorf("expected\n%s\ngot code %d\n%s%s", want, code, out, errOut)
+	}
+	if e, err := orch.GetExpression(1, 3); err != nil || !e.Complex {
+		t.Errorf("expected 4i to be submitted in complex mode, got %+v, %v", e, err)
+	}
+}
@@ -1,60 +1,127 @@
-package tests
-
-import (
-	"testing"
-
-	"github.com/lollmark/digital_calc/internal"
-	"github.com/lollmark/digital_calc/pkg/calculator"
-)
-
-func TestCompute(t *testing.T) {
-	tests := []struct {
-		op   string
-		a, b float64
-		want float64
-	}{
-		{"+", 1, 2, 3},
-		{"-", 5, 3, 2},
-		{"*", 2, 4, 8},
-		{"/", 9, 3, 3},
-	}
-	for _, tt := range tests {
-		got, err := calculation.Compute(tt.op, tt.a, tt.b)
-		if err != nil {
-			t.Errorf("Compute(%q, %v, %v) errored: %v", tt.op, tt.a, tt.b, err)
-		}
-		if got != tt.want {
-			t.Errorf("Compute(%q, %v, %v) = %v; want %v", tt.op, tt.a, tt.b, got, tt.want)
-		}
-	}
-}
-
-func TestParseAndEvalAST(t *testing.T) {
-	expr := "(2+3)*4-5/5"
-	ast, err := application.ParseAST(expr)
-	if err != nil {
-		t.Fatalf("ParseAST(%q) failed: %v", expr, err)
-	}
-	var eval func(n *application.ASTNode) float64
-	eval = func(n *application.ASTNode) float64 {
-		if n.IsLeaf {
-			return n.Value
-		}
-		l, r := eval(n.Left), eval(n.Right)
-		switch n.Operator {
-		case "+":
-			return l + r
-		case "-":
-			return l - r
-		case "*":
-			return l * r
-		case "/":
-			return l / r
-		}
-		t.Fatalf("unknown op %q", n.Operator)
-		return 0
-	}
-	if got := eval(ast); got != 5*4-1 {
-		t.Errorf("EvalAST(%q) = %v; want %v", expr, got, 5*4-1)
-	}
-}
+package tests
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+	"github.com/lollmark/digital_calc/pkg/calculator"
+)
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		op   string
+		a, b float64
+		want float64
+	}{
+		{"+", 1, 2, 3},
+		{"-", 5, 3, 2},
+		{"*", 2, 4, 8},
+		{"/", 9, 3, 3},
+		{"^", 2, 10, 1024},
+		{"^", 4, -0.5, 0.5},
+		{"neg", 7, 0, -7},
+		{"sqrt", 16, 0, 4},
+		{"abs", -2.5, 0, 2.5},
+		{"conj", -2, 0, -2},
+		{"arg", -1, 0, math.Pi},
+		{"arg", 3, 0, 0},
+		{"im", 3, 0, 0},
+	}
+	for _, tt := range tests {
+		got, err := calculation.Compute(tt.op, tt.a, tt.b)
+		if err != nil {
+			t.Errorf("Compute(%q, %v, %v) errored: %v", tt.op, tt.a, tt.b, err)
+		}
+		if got != tt.want {
+			t.Errorf("Compute(%q, %v, %v) = %v; want %v", tt.op, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseAndEvalAST(t *testing.T) {
+	expr := "(2+3)*4-5/5"
+	ast, err := application.ParseAST(expr)
+	if err != nil {
+		t.Fatalf("ParseAST(%q) failed: %v", expr, err)
+	}
+	var eval func(n *application.ASTNode) float64
+	eval = func(n *application.ASTNode) float64 {
+		if n.IsLeaf {
+			return n.Value
+		}
+		l, r := eval(n.Left), eval(n.Right)
+		switch n.Operator {
+		case "+":
+			return l + r
+		case "-":
+			return l - r
+		case "*":
+			return l * r
+		case "/":
+			return l / r
+		}
+		t.Fatalf("unknown op %q", n.Operator)
+		return 0
+	}
+	if got := eval(ast); got != 5*4-1 {
+		t.Errorf("EvalAST(%q) = %v; want %v", expr, got, 5*4-1)
+	}
+}
+
+func TestCompute_NotReal(t *testing.T) {
+	if _, err := calculation.Compute("^", -8, 1.0/3); err != calculation.ErrNotReal {
+		t.Errorf("expected ErrNotReal for a fractional power of a negative number, got %v", err)
+	}
+}
+
+func TestCompute_SqrtOfNegative(t *testing.T) {
+	if _, err := calculation.Compute("sqrt", -1, 0); err != calculation.ErrNotReal {
+		t.Errorf("expected ErrNotReal, got %v", err)
+	}
+}
+
+func TestComputeComplex(t *testing.T) {
+	tests := []struct {
+		op   string
+		a, b complex128
+		want complex128
+	}{
+		{"+", 1 + 2i, 3 - 1i, 4 + 1i},
+		{"*", 1 + 2i, 3 - 1i, 5 + 5i},
+		{"/", 5 + 5i, 1 + 2i, 3 - 1i},
+		{"^", 2, 10, 1024},
+		{"^", 1i, 2, -1},
+		{"sqrt", -4, 0, 2i},
+		{"sqrt", complex(-4, math.Copysign(0, -1)), 0, 2i},
+		{"abs", 3 + 4i, 0, 5},
+		{"conj", 3 + 4i, 0, 3 - 4i},
+		{"arg", -1, 0, math.Pi},
+		{"re", 3 + 4i, 0, 3},
+		{"im", 3 + 4i, 0, 4},
+		{"==", 1i, 1i, 1},
+		{"!=", 1i, 1, 1},
+		{"<", 1, 2, 1},
+		{"max", 1, 2, 2},
+		{"!", 1i, 0, 0},
+	}
+	for _, tt := range tests {
+		got, err := calculation.ComputeComplex(tt.op, tt.a, tt.b)
+		if err != nil {
+			t.Errorf("ComputeComplex(%q, %v, %v) errored: %v", tt.op, tt.a, tt.b, err)
+		}
+		if cmplx.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("ComputeComplex(%q, %v, %v) = %v; want %v", tt.op, tt.a, tt.b, got, tt.want)
+		}
+	}
+	if v, _ := calculation.ComputeComplex("^", -8, 1.0/3); cmplx.Abs(v-complex(1, math.Sqrt(3))) > 1e-12 {
+		t.Errorf("expected the principal cube root of -8, got %v", v)
+	}
+	if _, err := calculation.ComputeComplex("<", 1i, 2); err != calculation.ErrNotOrdered {
+		t.Errorf("expected ErrNotOrdered, got %v", err)
+	}
+	if _, err := calculation.ComputeComplex("/", 1i, 0); err != calculation.ErrDivisionByZero {
+		t.Errorf("expected ErrDivisionByZero, got %v", err)
+	}
+}
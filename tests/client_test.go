@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lollmark/digital_calc/pkg/client"
+)
+
+func newClient(t *testing.T, h http.Handler) *client.Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	c.Backoff = time.Millisecond
+	return c
+}
+
+func TestClient_Flow(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	c := newClient(t, orch.Routes())
+	ctx := context.Background()
+
+	if err := c.Register(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Register(ctx, "alice", "secret123"); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if _, err := c.Login(ctx, "alice", "wrong-password"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+	if _, err := c.Login(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.Calculate(ctx, "1/0")
+	var apiErr *client.Error
+	if !errors.Is(err, client.ErrInvalidExpression) || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected a 422 ErrInvalidExpression, got %v", err)
+	}
+	id, err := c.Calculate(ctx, "2*(3+4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := c.Get(ctx, id)
+	if err != nil || e.Status != client.StatusPending || e.Expression != "2*(3+4)" {
+		t.Fatalf("expected the pending expression, got %+v, %v", e, err)
+	}
+
+	runTasks(t, orch, "agent-1")
+	e, err = c.Wait(ctx, id, time.Millisecond)
+	if err != nil || e.Status != client.StatusDone || e.Result == nil || *e.Result != 14 || e.FinishedAt == nil {
+		t.Fatalf("expected 14, got %+v, %v", e, err)
+	}
+	list, err := c.List(ctx, client.ListOptions{Status: []string{client.StatusDone}})
+	if err != nil || len(list.Expressions) != 1 || list.Expressions[0].ID != id {
+		t.Errorf("expected the done expression, got %+v, %v", list, err)
+	}
+	if _, err := c.Cancel(ctx, id); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected ErrConflict cancelling a done expression, got %v", err)
+	}
+	if _, err := c.Get(ctx, 999); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_RefreshesToken(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	c := newClient(t, orch.Routes())
+	ctx := context.Background()
+
+	if _, err := c.List(ctx, client.ListOptions{}); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized before login, got %v", err)
+	}
+	if err := c.Register(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	c.SetToken("expired")
+	if _, err := c.Calculate(ctx, "1+1"); err != nil {
+		t.Fatalf("expected the client to log in again, got %v", err)
+	}
+	if c.Token() == "expired" {
+		t.Error("expected a new token")
+	}
+}
+
+func TestClient_RetriesServerErrors(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	routes := orch.Routes()
+	var failures atomic.Int32
+	// the first attempts reach the orchestrator, but their answers are
+	// lost, so retries must not create the expression twice
+	flaky := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/calculate" && failures.Add(-1) >= 0 {
+			routes.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		routes.ServeHTTP(w, r)
+	})
+	c := newClient(t, flaky)
+	ctx := context.Background()
+	if err := c.Register(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+
+	failures.Store(2)
+	if _, err := c.Calculate(ctx, "1+1"); err != nil {
+		t.Fatalf("expected the request to be retried, got %v", err)
+	}
+	list, err := c.List(ctx, client.ListOptions{})
+	if err != nil || len(list.Expressions) != 1 {
+		t.Fatalf("expected one expression, got %+v, %v", list, err)
+	}
+
+	c.MaxRetries = 1
+	failures.Store(2)
+	if _, err := c.Calculate(ctx, "2+2"); !errors.Is(err, client.ErrServer) {
+		t.Errorf("expected ErrServer after the retries, got %v", err)
+	}
+}
+
+func TestClient_WatchStreams(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	c := newClient(t, orch.Routes())
+	c.GRPC = dialAPIServer(t, orch)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := c.Register(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Wait(ctx, 999, 0); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	id, err := c.Calculate(ctx, "(1+2)*3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make(chan string, 10)
+	type result struct {
+		e   *client.Expression
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		e, err := c.Watch(ctx, id, 0, func(e *client.Expression) { statuses <- e.Status })
+		done <- result{e, err}
+	}()
+	if s := <-statuses; s != client.StatusPending {
+		t.Fatalf("expected pending first, got %s", s)
+	}
+	runTasks(t, orch, "agent-1")
+	res := <-done
+	if res.err != nil || res.e.Result == nil || *res.e.Result != 9 {
+		t.Fatalf("expected 9, got %+v, %v", res.e, res.err)
+	}
+	if s := <-statuses; s != client.StatusDone {
+		t.Errorf("expected done last, got %s", s)
+	}
+}
+
+func TestClient_WatchStreamsComplex(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	c := newClient(t, orch.Routes())
+	c.GRPC = dialAPIServer(t, orch)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := c.Register(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, "alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := c.CalculateComplex(ctx, "sqrt(-4) + 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *client.Expression, 1)
+	go func() {
+		e, err := c.Wait(ctx, id, 0)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- e
+	}()
+	runTasks(t, orch, "agent-1")
+	e := <-done
+	if e == nil || !e.Complex || e.Result != nil || e.ComplexResult == nil || *e.ComplexResult != "1+2i" {
+		t.Fatalf("expected 1+2i over the stream, got %+v", e)
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+	"math/cmplx"
+	"net/http"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func TestParseAST_Complex(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		vars map[string]float64
+		want complex128
+	}{
+		{expr: "3+4i", want: 3 + 4i},
+		{expr: "2.5e1i - 1_000i", want: -975i},
+		{expr: "i*i", want: -1},
+		{expr: "-2i * i", want: 2},
+		{expr: "sqrt(-4) + (1+2i)*(3-i)", want: 5 + 7i},
+		{expr: "abs(3+4i) + conj(1+i)", want: 6 - 1i},
+		{expr: "x = 2 + i; re(x) + im(x)*10", want: 12},
+		{expr: "i = 5; 2*i", want: 10},
+		{expr: "i^2", vars: map[string]float64{"i": 3}, want: 9},
+		{expr: "1i == i ? 1 : 0", want: 1},
+		{expr: "(-8)^(1/3)", want: complex(1, math.Sqrt(3))},
+	} {
+		ast, _, err := application.ParseASTInScope(tc.expr, application.Scope{Vars: tc.vars, Complex: true})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.expr, err)
+			continue
+		}
+		if v, err := application.EvalComplexAST(ast); err != nil || cmplx.Abs(v-tc.want) > 1e-12 {
+			t.Errorf("%q: expected %v, got %v, %v", tc.expr, tc.want, v, err)
+		}
+	}
+	// i is not a unit, the number and i must be joined: 4i or 4*i
+	if _, _, err := application.ParseASTInScope("3 + 4 i", application.Scope{Complex: true}); err == nil || err.Error() != "unexpected i at position 6" {
+		t.Errorf("expected 4 i to be rejected, got %v", err)
+	}
+}
+
+func TestFormatComplex(t *testing.T) {
+	for v, want := range map[complex128]string{
+		3 + 4i: "3+4i",
+		-1i:    "0-1i",
+		2:      "2+0i",
+		-0.5:   "-0.5+0i",
+		1e21i:  "0+1e+21i",
+		complex(math.Copysign(0, -1), math.Copysign(0, -1)): "0+0i",
+	} {
+		if got := application.FormatComplex(v); got != want {
+			t.Errorf("FormatComplex(%v) = %q; want %q", v, got, want)
+		}
+	}
+}
+
+func TestComplexMode(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	submit := func(body map[string]interface{}) *application.Expression {
+		t.Helper()
+		rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("%v: expected 201, got %d: %s", body, rec.Code, rec.Body)
+		}
+		var resp application.CalculateResp
+		json.NewDecoder(rec.Body).Decode(&resp)
+		runTasks(t, orch, "agent")
+		e, err := orch.GetExpression(1, resp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	e := submit(map[string]interface{}{"expression": "sqrt(-4) + (1+2i)*(3-i)", "complex": true})
+	if e.Status != "done" || e.Result != nil || e.ComplexResult == nil || *e.ComplexResult != "5+7i" || !e.Complex {
+		t.Errorf("expected 5+7i and no real result, got %+v", e)
+	}
+	var status struct{ Expression application.ExpressionStatus }
+	json.NewDecoder(doJSON(t, h, "GET", "/api/v1/expressions/1", tok, nil).Body).Decode(&status)
+	if status.Expression.ComplexResult == nil || *status.Expression.ComplexResult != "5+7i" {
+		t.Errorf("expected ComplexResult 5+7i, got %+v", status.Expression)
+	}
+	d, err := orch.ExpressionDetail(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Tree.ComplexValue == nil || *d.Tree.ComplexValue != "5+7i" || d.Tree.Value != nil {
+		t.Errorf("expected the root to be 5+7i, got %+v", d.Tree)
+	}
+	if sqrt := d.Tree.Left; sqrt.Operator != "sqrt" || *sqrt.ComplexValue != "0+2i" || *sqrt.Left.Value != -4 {
+		t.Errorf("expected sqrt(-4) = 2i, got %+v", sqrt)
+	}
+
+	// a real value keeps its number too
+	e = submit(map[string]interface{}{"expression": "(1+i)*(1-i)", "complex": true})
+	if e.Result == nil || *e.Result != 2 || e.ComplexResult == nil || *e.ComplexResult != "2+0i" {
+		t.Errorf("expected 2, got %+v", e)
+	}
+	// real mode is unchanged
+	e = submit(map[string]interface{}{"expression": "sqrt(16)"})
+	if e.Result == nil || *e.Result != 4 || e.ComplexResult != nil || e.Complex {
+		t.Errorf("expected 4 in real mode, got %+v", e)
+	}
+
+	for _, tc := range []struct {
+		body map[string]interface{}
+		msg  string
+	}{
+		{map[string]interface{}{"expression": "sqrt(-4)"}, "result is not a real number, submit it in complex mode"},
+		{map[string]interface{}{"expression": "(-8)^(1/3)"}, "result is not a real number, submit it in complex mode"},
+		{map[string]interface{}{"expression": "1+2i"}, "imaginary number 2i at position 2 needs complex mode"},
+		{map[string]interface{}{"expression": "i < 1", "complex": true}, "complex numbers cannot be ordered"},
+		{map[string]interface{}{"expression": "stddev([1, i])", "complex": true}, "stddev at position 0 needs real numbers, it is not defined in complex mode"},
+		{map[string]interface{}{"expression": "1/(i*i+1)", "complex": true}, "invalid expression or result out of range"},
+	} {
+		rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, tc.body)
+		if got := decodeError(t, rec); rec.Code != http.StatusUnprocessableEntity || got.Message != tc.msg {
+			t.Errorf("%v: expected 422 %q, got %d %+v", tc.body, tc.msg, rec.Code, got)
+		}
+	}
+}
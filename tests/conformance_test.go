@@ -0,0 +1,390 @@
+package tests
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+	"github.com/lollmark/digital_calc/pkg/calculator"
+	"github.com/lollmark/digital_calc/proto/calc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// conformance lists tricky inputs with the tree they must parse to,
+// written with every operation in parentheses and unary minus as (-x),
+// or the error they must give.
+var conformance = []struct {
+	expr, tree string
+	value      float64
+	err        string
+}{
+	// unary minus
+	{expr: "-5", tree: "-5", value: -5},
+	{expr: "--5", tree: "5", value: 5},
+	{expr: "- - -5", tree: "-5", value: -5},
+	{expr: "-(2+3)", tree: "(-(2 + 3))", value: -5},
+	{expr: "2*-(1+1)", tree: "(2 * (-(1 + 1)))", value: -4},
+	{expr: "2--3", tree: "(2 - -3)", value: 5},
+	{expr: "2- -3", tree: "(2 - -3)", value: 5},
+	{expr: "-(-(1+1))", tree: "(-(-(1 + 1)))", value: 2},
+	{expr: "(1+2)*-3", tree: "((1 + 2) * -3)", value: -9},
+	{expr: "-(1+2)/-3", tree: "((-(1 + 2)) / -3)", value: 1},
+	{expr: "a = 2; -a", tree: "(-a)", value: -2},
+	// power and its precedence against unary minus, '*' and '/'
+	{expr: "2^3", tree: "(2 ^ 3)", value: 8},
+	{expr: "2^3^2", tree: "(2 ^ (3 ^ 2))", value: 512},
+	{expr: "-2^2", tree: "(-(2 ^ 2))", value: -4},
+	{expr: "(-2)^2", tree: "(-2 ^ 2)", value: 4},
+	{expr: "2^-1", tree: "(2 ^ -1)", value: 0.5},
+	{expr: "2^-(1+1)", tree: "(2 ^ (-(1 + 1)))", value: 0.25},
+	{expr: "2*3^2", tree: "(2 * (3 ^ 2))", value: 18},
+	{expr: "-2*3^2", tree: "(-2 * (3 ^ 2))", value: -18},
+	{expr: "2^2*3", tree: "((2 ^ 2) * 3)", value: 12},
+	{expr: "8/2^2", tree: "(8 / (2 ^ 2))", value: 2},
+	{expr: "1-2^2", tree: "(1 - (2 ^ 2))", value: -3},
+	{expr: "4^0.5", tree: "(4 ^ 0.5)", value: 2},
+	// associativity of the binary operators
+	{expr: "1-2-3", tree: "((1 - 2) - 3)", value: -4},
+	{expr: "8/4/2", tree: "((8 / 4) / 2)", value: 1},
+	{expr: "2*3/4*5", tree: "(((2 * 3) / 4) * 5)", value: 7.5},
+	// unary plus binds like unary minus and is dropped
+	{expr: "+1", tree: "1", value: 1},
+	{expr: "+-1", tree: "-1", value: -1},
+	{expr: "(+2)*3", tree: "(2 * 3)", value: 6},
+	{expr: "a = +1; a", tree: "a", value: 1},
+	{expr: "1++2", tree: "(1 + 2)", value: 3},
+	{expr: "2*+3", tree: "(2 * 3)", value: 6},
+	{expr: "-+5", tree: "-5", value: -5},
+	{expr: "2^+1", tree: "(2 ^ 1)", value: 2},
+	{expr: "++1", tree: "1", value: 1},
+	{expr: "+(1+2)", tree: "(1 + 2)", value: 3},
+	{expr: "-+(1+2)", tree: "(-(1 + 2))", value: -3},
+	{expr: "1+", err: "expected number at position 2"},
+	{expr: "2*+", err: "expected number at position 3"},
+	// comparisons give 1 or 0 and bind looser than arithmetic
+	{expr: "1+1 == 2", tree: "((1 + 1) == 2)", value: 1},
+	{expr: "2*3 < 5", tree: "((2 * 3) < 5)", value: 0},
+	{expr: "3 <= 3", tree: "(3 <= 3)", value: 1},
+	{expr: "-1 > -2", tree: "(-1 > -2)", value: 1},
+	{expr: "2 >= 3", tree: "(2 >= 3)", value: 0},
+	{expr: "0.1+0.2 != 0.3", tree: "((0.1 + 0.2) != 0.3)", value: 1},
+	{expr: "(1 < 2) + (3 < 4)", tree: "((1 < 2) + (3 < 4))", value: 2},
+	{expr: "1 < 2 < 3", err: "comparisons cannot be chained at position 6, join them with &&"},
+	// logic: && binds tighter than ||, ! like a unary minus
+	{expr: "1 < 2 && 3 < 4", tree: "((1 < 2) && (3 < 4))", value: 1},
+	{expr: "2 && 3", tree: "(2 && 3)", value: 1},
+	{expr: "0 || 1 && 0", tree: "(0 || (1 && 0))", value: 0},
+	{expr: "1 || 1/0", tree: "(1 || (1 / 0))", value: 1},
+	{expr: "0 && 1/0", tree: "(0 && (1 / 0))", value: 0},
+	{expr: "!0", tree: "(!0)", value: 1},
+	{expr: "!!5", tree: "(!(!5))", value: 1},
+	{expr: "!2 == 0", tree: "((!2) == 0)", value: 1},
+	{expr: "-!0", tree: "(-(!0))", value: -1},
+	{expr: "1 & 2", err: "unexpected character '&' at position 2"},
+	{expr: "!", err: "expected number at position 1"},
+	// conditionals bind loosest and nest to the right
+	{expr: "1 < 2 ? 10 : 20", tree: "((1 < 2) ? 10 : 20)", value: 10},
+	{expr: "0 ? 1 : 0 ? 2 : 3", tree: "(0 ? 1 : (0 ? 2 : 3))", value: 3},
+	{expr: "1 ? 2 ? 3 : 4 : 5", tree: "(1 ? (2 ? 3 : 4) : 5)", value: 3},
+	{expr: "2 * (0 ? 1 : 2)", tree: "(2 * (0 ? 1 : 2))", value: 4},
+	{expr: "if(2 > 1, 7, 1/0)", tree: "((2 > 1) ? 7 : (1 / 0))", value: 7},
+	{expr: "if(0, 1, if(1, 2, 3)) + 1", tree: "((0 ? 1 : (1 ? 2 : 3)) + 1)", value: 3},
+	{expr: "x = 5; x > 3 ? x*2 : x", tree: "((x > 3) ? (x * 2) : x)", value: 10},
+	{expr: "1 ? 2", err: "expected : at position 5"},
+	{expr: "if(1, 2)", err: "if at position 0 takes 3 arguments, got 2"},
+	{expr: "1 ? 2 : ", err: "expected number at position 8"},
+	// aggregates are balanced trees over their items and lists
+	{expr: "sum([1, 2, 3, 4])", tree: "((1 + 2) + (3 + 4))", value: 10},
+	{expr: "sum(1, 2, 3)", tree: "((1 + 2) + 3)", value: 6},
+	{expr: "sum([1, 2], 3, [4, 5])", tree: "(((1 + 2) + (3 + 4)) + 5)", value: 15},
+	{expr: "prod([2, 3, 4])", tree: "((2 * 3) * 4)", value: 24},
+	{expr: "min([3, -1, 2])", tree: "((3 min -1) min 2)", value: -1},
+	{expr: "max([3, 1+4, 2])", tree: "((3 max (1 + 4)) max 2)", value: 5},
+	{expr: "avg([1, 2, 3, 6])", tree: "(((1 + 2) + (3 + 6)) / 4)", value: 3},
+	{expr: "count([1, 2], [3])", tree: "3", value: 3},
+	{expr: "sum([])", tree: "0", value: 0},
+	{expr: "prod()", tree: "1", value: 1},
+	{expr: "sum([7])", tree: "7", value: 7},
+	{expr: "xs = [1, 2, 3]; avg(xs) + max(xs, 10)", tree: "((((1 + 2) + 3) / 3) + ((1 max 2) max (3 max 10)))", value: 12},
+	{expr: "xs = [1, 2]; xs = 5; xs*2", tree: "(xs * 2)", value: 10},
+	{expr: "sum([1, 2]) > 2 ? 1 : 0", tree: "(((1 + 2) > 2) ? 1 : 0)", value: 1},
+	{expr: "median([3, 1, 2])", tree: "((3 max 1) min ((3 min 1) max 2))", value: 2},
+	{expr: "median([4, 1, 3, 2])", tree: "(((((4 max 1) min (3 max 2)) min ((4 min 1) max (3 min 2))) + (((4 max 1) min (3 max 2)) max ((4 min 1) max (3 min 2)))) / 2)", value: 2.5},
+	{expr: "median(7)", tree: "7", value: 7},
+	{expr: "stddev([1, 3])", tree: "sqrt((((((1 * 1) + (3 * 3)) / 2) - (((1 + 3) / 2) * ((1 + 3) / 2))) max 0))", value: 1},
+	{expr: "stddev([0.1, 0.1, 0.1])", tree: "sqrt(((((((0.1 * 0.1) + (0.1 * 0.1)) + (0.1 * 0.1)) / 3) - ((((0.1 + 0.1) + 0.1) / 3) * (((0.1 + 0.1) + 0.1) / 3))) max 0))", value: 0},
+	{expr: "avg([])", err: "avg at position 0 needs at least one item"},
+	{expr: "median([])", err: "median at position 0 needs at least one item"},
+	{expr: "stddev()", err: "stddev at position 0 needs at least one item"},
+	{expr: "min()", err: "min at position 0 needs at least one item"},
+	{expr: "[1, 2]", err: "a list at position 0 can only be an argument of an aggregate function such as sum"},
+	{expr: "sum([[1], 2])", err: "a list at position 5 can only be an argument of an aggregate function such as sum"},
+	{expr: "xs = [1]; xs + 1", err: "xs at position 10 is a list, use it in an aggregate function such as sum(xs)"},
+	{expr: "xs = [1, 2]", err: "the result is a list, not a number"},
+	{expr: "sum([1, 2)", err: "missing closing bracket for [ at position 4"},
+	{expr: "sum(1,)", err: "unexpected ) at position 6"},
+	{expr: "sum(", err: "expected number at position 4"},
+	// functions of one number
+	{expr: "sqrt(16) + abs(-3)", tree: "(sqrt(16) + abs(-3))", value: 7},
+	{expr: "-sqrt(4)^2", tree: "(-(sqrt(4) ^ 2))", value: -4},
+	{expr: "arg(-1)", tree: "arg(-1)", value: math.Pi},
+	{expr: "conj(2) + re(3) + im(5)", tree: "((conj(2) + re(3)) + im(5))", value: 5},
+	{expr: "sqrt(1, 2)", err: "sqrt at position 0 takes 1 argument, got 2"},
+	{expr: "sqrt()", err: "expected number at position 5"},
+	// imaginary numbers need complex mode
+	{expr: "3+4i", err: "imaginary number 4i at position 2 needs complex mode"},
+	{expr: "2*i", err: "unknown variable i at position 2"},
+	{expr: "4ix", err: "unexpected 'x' in number at position 2"},
+	{expr: "0x1i", err: "unexpected 'i' in number at position 3"},
+	// units are converted to SI base units
+	{expr: "5 m / 2 s", tree: "(5 / 2)", value: 2.5},
+	{expr: "1 km + 1 m", tree: "(1000 + 1)", value: 1001},
+	{expr: "2 kg * 9.81 m/s^2", tree: "(2 * 9.81)", value: 19.62},
+	{expr: "-3 km^2", tree: "-3e+06", value: -3e6},
+	{expr: "1 h > 59 min", tree: "(3600 > 3540)", value: 1},
+	{expr: "s = 2; 10 m / s", tree: "(10 / s)", value: 5},
+	{expr: "(2 m)^2 / 1 m", tree: "((2 ^ 2) / 1)", value: 4},
+	{expr: "1 m + 1 s", err: "+ at position 4 needs operands of the same dimension, got m and s"},
+	{expr: "1 m < 2", err: "< at position 4 needs operands of the same dimension, got m and a plain number"},
+	{expr: "1 ? 1 m : 1 s", err: "? at position 2 needs operands of the same dimension, got m and s"},
+	{expr: "max(1 m, 2 kg)", err: "max at position 0 needs operands of the same dimension, got m and kg"},
+	{expr: "2^(1 s)", err: "exponent at position 1 must be a plain number, got s"},
+	{expr: "sqrt(2 m)", err: "sqrt at position 0 gives a fractional power of m"},
+	{expr: "a = 2; (1 m)^a * (1 m)^(a+1)", err: "^ at position 22 raises m to a power that is not a constant"},
+	{expr: "2 x", err: "unknown unit x at position 2"},
+	// a name after a number is a unit only if nothing else takes it
+	{expr: "5 min", tree: "300", value: 300},
+	{expr: "5 min(1, 2)", err: "unexpected min at position 2"},
+	{expr: "3 + 4 i", err: "unexpected i at position 6"},
+	{expr: "m = 2; 5 m", err: "unexpected m at position 9"},
+	{expr: "m = 2; 5 * m", tree: "(5 * m)", value: 10},
+	{expr: "2 m^0.5", err: "power 0.5 of a unit at position 4 must be a whole number"},
+	{expr: "2m", err: "unexpected 'm' in number at position 1"},
+	// malformed
+	{expr: "-", err: "expected number at position 1"},
+	{expr: "2^", err: "expected number at position 2"},
+	{expr: "^2", err: "expected number at position 0"},
+	{expr: "2^^2", err: "expected number at position 2"},
+	{expr: "2**2", err: "expected number at position 2"},
+	{expr: "()", err: "expected number at position 1"},
+	{expr: "(-)", err: "expected number at position 2"},
+	{expr: "(-1)-", err: "expected number at position 5"},
+	{expr: "(1)(2)", err: "unexpected ( at position 3"},
+	{expr: "-2a", err: "unexpected 'a' in number at position 2"},
+	{expr: "(-1))", err: "unexpected ) at position 4"},
+	{expr: "(-1", err: "missing closing parenthesis for ( at position 0"},
+}
+
+// tree renders n the way conformance writes trees.
+func tree(n *application.ASTNode) string {
+	switch {
+	case n.IsLeaf && n.Name != "":
+		return n.Name
+	case n.IsLeaf:
+		return strconv.FormatFloat(n.Value, 'g', -1, 64)
+	case n.Operator == application.OpNeg:
+		return "(-" + tree(n.Left) + ")"
+	case n.Operator == application.OpNot:
+		return "(!" + tree(n.Left) + ")"
+	case n.Operator == application.OpIf:
+		return "(" + tree(n.Cond) + " ? " + tree(n.Left) + " : " + tree(n.Right) + ")"
+	case n.Right == nil:
+		return n.Operator + "(" + tree(n.Left) + ")"
+	}
+	return "(" + tree(n.Left) + " " + n.Operator + " " + tree(n.Right) + ")"
+}
+
+func TestParseAST_Conformance(t *testing.T) {
+	for _, tc := range conformance {
+		ast, err := application.ParseAST(tc.expr)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%q: expected error %q, got %v", tc.expr, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.expr, err)
+			continue
+		}
+		if got := tree(ast); got != tc.tree {
+			t.Errorf("%q: expected %s, got %s", tc.expr, tc.tree, got)
+		}
+		if v, err := application.EvalAST(ast); err != nil || v != tc.value {
+			t.Errorf("%q: expected %v, got %v, %v", tc.expr, tc.value, v, err)
+		}
+	}
+}
+
+// The scheduler must agree with the parser: every valid input computed
+// by agents gives the same value as EvalAST.
+func TestScheduler_Conformance(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.RateLimitPerMinute = len(conformance)
+	ctx := context.Background()
+	for _, tc := range conformance {
+		if tc.err != "" {
+			continue
+		}
+		id, err := orch.Calculate(ctx, 1, tc.expr, nil, "", false)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.expr, err)
+		}
+		runTasks(t, orch, "agent")
+		e, err := orch.GetExpression(1, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Status != "done" || e.Result == nil || *e.Result != tc.value {
+			t.Errorf("%q: expected done with %v, got %s %v", tc.expr, tc.value, e.Status, e.Result)
+		}
+	}
+
+	// a unary minus is a task of its own that takes no time
+	id, err := orch.Calculate(ctx, 1, "-(2+3)", nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := runTasks(t, orch, "agent"); n != 2 {
+		t.Errorf("expected 2 tasks, got %d", n)
+	}
+	d, err := orch.ExpressionDetail(1, int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Tree.Operator != application.OpNeg || d.Tree.Right != nil || d.Tree.Left.Operator != "+" || *d.Tree.Value != -5 {
+		t.Errorf("expected a neg node over the sum, got %+v", d.Tree)
+	}
+	if !strings.HasPrefix(d.Tree.TaskID, strconv.FormatInt(id, 10)+"-") {
+		t.Errorf("expected the neg node to be a task, got %+v", d.Tree)
+	}
+}
+
+// An aggregate is computed level by level: all the operations of a
+// level are queued at once, so that agents take them in parallel.
+func TestScheduler_ParallelAggregates(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	items := make([]string, 16)
+	for i := range items {
+		items[i] = strconv.Itoa(i + 1)
+	}
+	id, err := orch.Calculate(context.Background(), 1, "sum(["+strings.Join(items, ", ")+"])", nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("agent-id", "agent"))
+	for _, width := range []int{8, 4, 2, 1} {
+		var level []*calc.TaskResp
+		for {
+			task, err := orch.GetTask(ctx, &calc.Empty{})
+			if status.Code(err) == codes.NotFound {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			level = append(level, task)
+		}
+		if len(level) != width {
+			t.Fatalf("expected %d tasks at once, got %d", width, len(level))
+		}
+		for _, task := range level {
+			res, _ := calculation.Compute(task.Operation, task.Arg1, task.Arg2)
+			if _, err := orch.PostResult(ctx, &calc.ResultReq{Id: task.Id, Result: res}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if e, _ := orch.GetExpression(1, id); e.Status != "done" || *e.Result != 136 {
+		t.Errorf("expected done with 136, got %+v", e)
+	}
+}
+
+// Comparators reused by the median network are single tasks, so that the
+// default MAX_AST_NODES fits aggregates of a few dozen items.
+func TestScheduler_LargeMedian(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	items := make([]string, 16)
+	for i := range items {
+		items[i] = strconv.Itoa((i * 7) % 16)
+	}
+	list := "[" + strings.Join(items, ", ") + "]"
+	for _, tc := range []struct {
+		expr  string
+		value float64
+	}{
+		{"median(" + list + ")", 7.5},
+		{"stddev(" + list + ") * stddev(" + list + ")", 21.25},
+	} {
+		id, err := orch.Calculate(context.Background(), 1, tc.expr, nil, "", false)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		runTasks(t, orch, "agent")
+		if e, _ := orch.GetExpression(1, id); e.Status != "done" || math.Abs(*e.Result-tc.value) > 1e-9 {
+			t.Errorf("%s: expected done with %v, got %+v", tc.expr, tc.value, e)
+		}
+		if _, err := orch.ExpressionDetail(1, int(id)); err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+		}
+	}
+}
+
+// Only the branch a conditional takes is dispatched to agents.
+func TestScheduler_LazyConditionals(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	ctx := context.Background()
+
+	id, err := orch.Calculate(ctx, 1, "x = 2; x*x > 3 ? x+1 : x*100 + x*1000", nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// x*x, then >, then x+1; the other branch never is a task
+	if n := runTasks(t, orch, "agent"); n != 3 {
+		t.Errorf("expected 3 tasks, got %d", n)
+	}
+	d, err := orch.ExpressionDetail(1, int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Expression.Status != "done" || *d.Expression.Result != 3 {
+		t.Fatalf("expected done with 3, got %+v", d.Expression)
+	}
+	if d.Tree.Operator != application.OpIf || d.Tree.State != application.NodeDone || d.Tree.TaskID != "" {
+		t.Errorf("expected a done conditional without a task, got %+v", d.Tree)
+	}
+	if d.Tree.Condition.Operator != ">" || d.Tree.Left.State != application.NodeDone ||
+		d.Tree.Right.State != application.NodeSkipped || d.Tree.Right.Left.State != application.NodeSkipped {
+		t.Errorf("expected the else branch to be skipped, got %+v", d.Tree)
+	}
+
+	// && and || stop at the left side when it decides
+	id, err = orch.Calculate(ctx, 1, "(1 > 2) && (3*4 > 5)", nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := runTasks(t, orch, "agent"); n != 1 {
+		t.Errorf("expected 1 task, got %d", n)
+	}
+	if e, _ := orch.GetExpression(1, id); e.Status != "done" || *e.Result != 0 {
+		t.Errorf("expected done with 0, got %+v", e)
+	}
+
+	// decided by literals alone: no tasks at all
+	id, err = orch.Calculate(ctx, 1, "if(1, 5, 2*3)", nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := orch.GetExpression(1, id); e.Status != "done" || *e.Result != 5 {
+		t.Errorf("expected done with 5 at once, got %+v", e)
+	}
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func TestDefinitions(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	put := func(name string, body map[string]interface{}) *application.Definition {
+		t.Helper()
+		rec := doJSON(t, h, "PUT", "/api/v1/definitions/"+name, tok, body)
+		if rec.Code != http.StatusCreated && rec.Code != http.StatusOK {
+			t.Fatalf("PUT %s: expected 200 or 201, got %d: %s", name, rec.Code, rec.Body)
+		}
+		var d application.Definition
+		json.NewDecoder(rec.Body).Decode(&d)
+		return &d
+	}
+	put("rate", map[string]interface{}{"body": "0.2"})
+	put("tax", map[string]interface{}{"params": []string{"x"}, "body": "x*rate"})
+
+	// submitted with rate 0.2, computed after rate changes
+	rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]interface{}{"expression": "100+tax(100)"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if d := put("rate", map[string]interface{}{"body": "0.5"}); d.Version != 2 {
+		t.Errorf("expected version 2, got %+v", d)
+	}
+	runTasks(t, orch, "agent-1")
+	var d application.ExpressionDetail
+	json.NewDecoder(doJSON(t, h, "GET", "/api/v1/expressions/1/details", tok, nil).Body).Decode(&d)
+	if d.Expression.Status != "done" || *d.Expression.Result != 120 {
+		t.Errorf("expected the old rate to be used, got %+v", d.Expression)
+	}
+	if len(d.Definitions) != 2 || d.Definitions[0].Name != "rate" || d.Definitions[0].Version != 1 {
+		t.Errorf("expected the definitions used, got %+v", d.Definitions)
+	}
+
+	// new expressions use the new version
+	rec = doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]interface{}{"expression": "100+tax(100)"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	runTasks(t, orch, "agent-1")
+	var e struct{ Expression application.ExpressionStatus }
+	json.NewDecoder(doJSON(t, h, "GET", "/api/v1/expressions/2", tok, nil).Body).Decode(&e)
+	if e.Expression.Result == nil || *e.Expression.Result != 150 {
+		t.Errorf("expected 150, got %+v", e.Expression)
+	}
+
+	var old application.Definition
+	json.NewDecoder(doJSON(t, h, "GET", "/api/v1/definitions/rate?version=1", tok, nil).Body).Decode(&old)
+	if old.Body != "0.2" {
+		t.Errorf("expected version 1 to be kept, got %+v", old)
+	}
+
+	for _, tc := range []struct {
+		name string
+		body map[string]interface{}
+		code string
+	}{
+		{"1x", map[string]interface{}{"body": "1"}, application.CodeValidationFailed},
+		{"if", map[string]interface{}{"params": []string{"a"}, "body": "a"}, application.CodeValidationFailed},
+		{"sum", map[string]interface{}{"body": "1"}, application.CodeValidationFailed},
+		{"median", map[string]interface{}{"body": "1"}, application.CodeValidationFailed},
+		{"f", map[string]interface{}{"params": []string{"x", "x"}, "body": "x"}, application.CodeValidationFailed},
+		{"rate", map[string]interface{}{"body": "tax(1)"}, application.CodeInvalidExpression},
+		{"f", map[string]interface{}{"body": "y+1"}, application.CodeInvalidExpression},
+	} {
+		rec := doJSON(t, h, "PUT", "/api/v1/definitions/"+tc.name, tok, tc.body)
+		if got := decodeError(t, rec); got.Code != tc.code {
+			t.Errorf("%s %v: expected %s, got %d %+v", tc.name, tc.body, tc.code, rec.Code, got)
+		}
+	}
+
+	rec = doJSON(t, h, "DELETE", "/api/v1/definitions/rate", tok, nil)
+	if got := decodeError(t, rec); got.Code != application.CodeDefinitionInUse {
+		t.Errorf("expected definition_in_use, got %d %+v", rec.Code, got)
+	}
+	for _, name := range []string{"tax", "rate"} {
+		if rec := doJSON(t, h, "DELETE", "/api/v1/definitions/"+name, tok, nil); rec.Code != http.StatusNoContent {
+			t.Errorf("DELETE %s: expected 204, got %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+	rec = doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]interface{}{"expression": "rate*2"})
+	if got := decodeError(t, rec); got.Code != application.CodeInvalidExpression {
+		t.Errorf("expected a deleted constant to be unknown, got %d %+v", rec.Code, got)
+	}
+	// the history survives the deletion and numbering goes on
+	if d := put("rate", map[string]interface{}{"body": "0.1"}); d.Version != 4 {
+		t.Errorf("expected version 4, got %+v", d)
+	}
+	var versions application.DefinitionList
+	json.NewDecoder(doJSON(t, h, "GET", "/api/v1/definitions/rate/versions", tok, nil).Body).Decode(&versions)
+	if len(versions.Definitions) != 3 {
+		t.Errorf("expected 3 versions, got %+v", versions)
+	}
+
+	// definitions are private
+	other := registerAndLogin(t, h, "bob", "secret123")
+	var list application.DefinitionList
+	json.NewDecoder(doJSON(t, h, "GET", "/api/v1/definitions", other, nil).Body).Decode(&list)
+	if len(list.Definitions) != 0 {
+		t.Errorf("expected no definitions for another user, got %+v", list)
+	}
+}
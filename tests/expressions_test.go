@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+type expressionPage struct {
+	Expressions []struct {
+		ID        int    `json:"id"`
+		Expr      string `json:"expression"`
+		Status    string `json:"status"`
+		CreatedAt string `json:"created_at"`
+	} `json:"expressions"`
+	NextCursor string `json:"next_cursor"`
+}
+
+func listExpressions(t *testing.T, h http.Handler, tok string, q url.Values) (int, expressionPage) {
+	t.Helper()
+	rec := doJSON(t, h, "GET", "/api/v1/expressions?"+q.Encode(), tok, nil)
+	var page expressionPage
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, page
+}
+
+func seedExpressions(t *testing.T, orch *application.Orchestrator, uid int, base time.Time) {
+	t.Helper()
+	rows := []struct {
+		expr, status string
+		age          time.Duration
+	}{
+		{"1+1", "done", 5 * time.Hour},
+		{"2*3", "pending", 4 * time.Hour},
+		{"10%/2", "done", 3 * time.Hour},
+		{"(4+4)*2", "pending", 2 * time.Hour},
+		{"7-1", "done", time.Hour},
+	}
+	for _, r := range rows {
+		ts := base.Add(-r.age).UnixMilli()
+		orch.DB.MustExec(
+			"INSERT INTO expressions(user_id,expr,status,created_at,updated_at) VALUES(?,?,?,?,?)",
+			uid, r.expr, r.status, ts, ts,
+		)
+	}
+	// someone else's expression must never show up
+	orch.DB.MustExec("INSERT INTO expressions(user_id,expr,status,created_at) VALUES(?,?,?,?)", uid+1, "1+1", "done", base.UnixMilli())
+}
+
+func TestListExpressions_Pagination(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	seedExpressions(t, orch, 1, time.Now())
+
+	var got []string
+	q := url.Values{"limit": {"2"}}
+	for page := 0; ; page++ {
+		code, p := listExpressions(t, h, tok, q)
+		if code != http.StatusOK {
+			t.Fatalf("page %d: expected 200, got %d", page, code)
+		}
+		for _, e := range p.Expressions {
+			got = append(got, e.Expr)
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		if page > 5 {
+			t.Fatal("pagination does not terminate")
+		}
+		q.Set("cursor", p.NextCursor)
+	}
+	want := []string{"7-1", "(4+4)*2", "10%/2", "2*3", "1+1"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestListExpressions_Filters(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	now := time.Now()
+	seedExpressions(t, orch, 1, now)
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  []string
+	}{
+		{"status", url.Values{"status": {"pending"}, "sort": {"id"}}, []string{"2*3", "(4+4)*2"}},
+		{"search", url.Values{"q": {"+"}, "sort": {"id"}}, []string{"1+1", "(4+4)*2"}},
+		{"search escapes wildcards", url.Values{"q": {"%"}}, []string{"10%/2"}},
+		{"time range", url.Values{
+			"created_after":  {now.Add(-4*time.Hour - time.Minute).Format(time.RFC3339)},
+			"created_before": {now.Add(-2*time.Hour + time.Minute).Format(time.RFC3339)},
+			"sort":           {"created_at"},
+		}, []string{"2*3", "10%/2", "(4+4)*2"}},
+	}
+	for _, tc := range tests {
+		code, p := listExpressions(t, h, tok, tc.query)
+		if code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tc.name, code)
+			continue
+		}
+		var got []string
+		for _, e := range p.Expressions {
+			got = append(got, e.Expr)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestListExpressions_BadQuery(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	seedExpressions(t, orch, 1, time.Now())
+
+	_, p := listExpressions(t, h, tok, url.Values{"limit": {"1"}, "sort": {"id"}})
+	for _, q := range []url.Values{
+		{"limit": {"0"}},
+		{"sort": {"expr"}},
+		{"cursor": {"garbage"}},
+		{"cursor": {p.NextCursor}, "sort": {"-id"}},
+		{"created_after": {"yesterday"}},
+	} {
+		if code, _ := listExpressions(t, h, tok, q); code != http.StatusBadRequest {
+			t.Errorf("%v: expected 400, got %d", q, code)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+	"github.com/lollmark/digital_calc/proto/calc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func startAPIServer(t *testing.T, orch *application.Orchestrator) calc.CalcAPIClient {
+	t.Helper()
+	return calc.NewCalcAPIClient(dialAPIServer(t, orch))
+}
+
+func dialAPIServer(t *testing.T, orch *application.Orchestrator) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(orch.AuthUnaryInterceptor),
+		grpc.ChainStreamInterceptor(orch.AuthStreamInterceptor),
+	)
+	calc.RegisterCalcAPIServer(srv, application.NewAPIServer(orch))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCAPI_Flow(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	c := startAPIServer(t, orch)
+	ctx := context.Background()
+
+	creds := &calc.Credentials{Login: "alice", Password: "secret123"}
+	if _, err := c.Register(ctx, creds); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Register(ctx, creds); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists, got %v", err)
+	}
+	if _, err := c.Login(ctx, &calc.Credentials{Login: "alice", Password: "wrong"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+	login, err := c.Login(ctx, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Calculate(ctx, &calc.CalculateReq{Expression: "1+1"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a token, got %v", err)
+	}
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+login.Token)
+	if _, err := c.Calculate(authed, &calc.CalculateReq{Expression: "1+"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+	resp, err := c.Calculate(authed, &calc.CalculateReq{Expression: "2*(3+4)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watch, err := c.WatchExpression(authed, &calc.ExpressionReq{Id: resp.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := watch.Recv()
+	if err != nil || first.Status != "pending" {
+		t.Fatalf("expected the pending expression first, got %v, %v", first, err)
+	}
+	runTasks(t, orch, "agent")
+	last, err := watch.Recv()
+	if err != nil || last.Status != "done" || last.GetResult() != 14 || last.FinishedAt == nil {
+		t.Fatalf("expected the finished expression, got %v, %v", last, err)
+	}
+	if _, err := watch.Recv(); err != io.EOF {
+		t.Errorf("expected the stream to end, got %v", err)
+	}
+
+	got, err := c.GetExpression(authed, &calc.ExpressionReq{Id: resp.Id})
+	if err != nil || got.GetResult() != 14 {
+		t.Errorf("unexpected expression %v, %v", got, err)
+	}
+	if _, err := c.GetExpression(authed, &calc.ExpressionReq{Id: 999}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+	list, err := c.ListExpressions(authed, &calc.ListExpressionsReq{Status: []string{"done"}})
+	if err != nil || len(list.Expressions) != 1 || list.Expressions[0].Id != resp.Id {
+		t.Errorf("unexpected list %v, %v", list, err)
+	}
+	if _, err := c.ListExpressions(authed, &calc.ListExpressionsReq{Sort: "bogus"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+
+	// a password change revokes the token for gRPC too
+	if rec := doJSON(t, orch.Routes(), "POST", "/api/v1/password", login.Token, map[string]string{"old_password": "secret123", "new_password": "another123"}); rec.Code != http.StatusOK {
+		t.Fatalf("password change: expected 200, got %d", rec.Code)
+	}
+	if _, err := c.GetExpression(authed, &calc.ExpressionReq{Id: resp.Id}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated with a revoked token, got %v", err)
+	}
+	revoked, err := c.WatchExpression(authed, &calc.ExpressionReq{Id: resp.Id})
+	if err == nil {
+		_, err = revoked.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated for a stream with a revoked token, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lollmark/digital_calc/internal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return strconv.Itoa(lis.Addr().(*net.TCPAddr).Port)
+}
+
+func getHealth(url string) (int, application.HealthResp, error) {
+	var body application.HealthResp
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, body, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body, err
+}
+
+func TestReadyz_NotServing(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	rec := doJSON(t, orch.Routes(), "GET", "/readyz", "", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before RunServer, got %d", rec.Code)
+	}
+	var body application.HealthResp
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body.Checks == nil || body.Checks.DB != "ok" || body.Checks.GRPC != "not serving" {
+		t.Errorf("unexpected checks %+v", body.Checks)
+	}
+}
+
+func TestRunServer_HealthAndShutdown(t *testing.T) {
+	httpPort, grpcPort := freePort(t), freePort(t)
+	t.Setenv("PORT", httpPort)
+	t.Setenv("GRPC_PORT", grpcPort)
+	t.Setenv("SHUTDOWN_TIMEOUT_SEC", "5")
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- orch.RunServer(ctx) }()
+
+	base := fmt.Sprintf("http://127.0.0.1:%s", httpPort)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, body, err := getHealth(base + "/readyz")
+		if err == nil && code == http.StatusOK {
+			if body.Status != "ready" || body.Checks.DB != "ok" || body.Checks.GRPC != "ok" {
+				t.Errorf("unexpected readiness %+v %+v", body, body.Checks)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("never became ready: %d %v", code, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if code, body, err := getHealth(base + "/healthz"); err != nil || code != http.StatusOK || body.Status != "ok" {
+		t.Errorf("healthz: %d %+v %v", code, body, err)
+	}
+
+	conn, err := grpc.NewClient("127.0.0.1:"+grpcPort, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hc := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"", "calc.Calc", "calc.CalcAPI"} {
+		resp, err := hc.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("health check %q: %v", service, err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("expected %q to be serving, got %v", service, resp.Status)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("RunServer: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunServer did not return after shutdown")
+	}
+	if _, _, err := getHealth(base + "/healthz"); err == nil {
+		t.Error("expected HTTP to be closed after shutdown")
+	}
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postWithKey(h http.Handler, tok, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyKey_ReplaysResponse(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	first := postWithKey(h, tok, "k1", `{"expression":"1+2"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", first.Code)
+	}
+	retry := postWithKey(h, tok, "k1", `{"expression":"1+2"}`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the original response, got %d %s", retry.Code, retry.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected Idempotent-Replayed header")
+	}
+	var exprs, tasks int
+	orch.DB.Get(&exprs, "SELECT COUNT(*) FROM expressions")
+	orch.DB.Get(&tasks, "SELECT COUNT(*) FROM tasks")
+	if exprs != 1 || tasks != 1 {
+		t.Errorf("expected 1 expression and 1 task, got %d and %d", exprs, tasks)
+	}
+
+	if rec := postWithKey(h, tok, "k1", `{"expression":"2+2"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with another body: expected 422, got %d", rec.Code)
+	}
+	if rec := postWithKey(h, tok, "k2", `{"expression":"1+2"}`); rec.Code != http.StatusCreated || rec.Body.String() == first.Body.String() {
+		t.Errorf("new key: expected a new expression, got %d %s", rec.Code, rec.Body)
+	}
+	other := registerAndLogin(t, h, "bob", "secret123")
+	if rec := postWithKey(h, other, "k1", `{"expression":"1+2"}`); rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("keys must be scoped to the user")
+	}
+}
+
+func TestIdempotencyKey_ErrorsAreNotStored(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	if rec := postWithKey(h, tok, "k", `{"expression":"1+"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+	var n int
+	orch.DB.Get(&n, "SELECT COUNT(*) FROM idempotency_keys")
+	if n != 0 {
+		t.Errorf("expected the failed request to release its key, got %d rows", n)
+	}
+}
+
+func TestIdempotencyKey_Expires(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.IdempotencyTTL = time.Hour
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	postWithKey(h, tok, "k", `{"expression":"1+2"}`)
+	orch.DB.MustExec("UPDATE idempotency_keys SET created_at = ?", time.Now().Add(-2*time.Hour).UnixMilli())
+	if rec := postWithKey(h, tok, "k", `{"expression":"1+2"}`); rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("expected an expired key to run the request again")
+	}
+	var n int
+	orch.DB.Get(&n, "SELECT COUNT(*) FROM expressions")
+	if n != 2 {
+		t.Errorf("expected 2 expressions, got %d", n)
+	}
+}
+
+// Browsers send Idempotency-Key only if the preflight allows it.
+func TestIdempotencyKey_CORSPreflight(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	req := httptest.NewRequest("OPTIONS", "/api/v1/calculate", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "authorization, content-type, idempotency-key")
+	rec := httptest.NewRecorder()
+	orch.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if allowed := rec.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(allowed, "Idempotency-Key") {
+		t.Errorf("expected Idempotency-Key to be allowed, got %q", allowed)
+	}
+	if exposed := rec.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, "Idempotent-Replayed") {
+		t.Errorf("expected Idempotent-Replayed to be exposed, got %q", exposed)
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+	"github.com/lollmark/digital_calc/proto/calc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// logBuffer is written by the agent workers other tests leave running,
+// so it has to be safe for concurrent use.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the JSON log records with the given message.
+func (b *logBuffer) lines(t *testing.T, msg string) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []map[string]interface{}
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		var rec map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("log line is not JSON: %s", sc.Text())
+		}
+		if rec["msg"] == msg {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+func captureLogs(t *testing.T) *logBuffer {
+	t.Helper()
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_LEVEL", "debug")
+	buf := &logBuffer{}
+	prev := slog.Default()
+	if err := application.SetupLogging(buf); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+	return buf
+}
+
+func TestLogging_RequestAndExpressionIDs(t *testing.T) {
+	logs := captureLogs(t)
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression":"2*3+1"}`))
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set(application.RequestIDHeader, "client-req-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(application.RequestIDHeader); got != "client-req-1" {
+		t.Errorf("expected the client request id back, got %q", got)
+	}
+
+	created := logs.lines(t, "expression created")
+	if len(created) != 1 {
+		t.Fatalf("expected one expression created line, got %d", len(created))
+	}
+	for key, want := range map[string]interface{}{"request_id": "client-req-1", "user_id": 1.0, "expr_id": 1.0} {
+		if created[0][key] != want {
+			t.Errorf("expected %s=%v, got %v", key, want, created[0][key])
+		}
+	}
+	access := logs.lines(t, "http request")
+	last := access[len(access)-1]
+	if last["request_id"] != "client-req-1" || last["status"] != 201.0 || last["path"] != "/api/v1/calculate" || last["user_id"] != 1.0 {
+		t.Errorf("unexpected access log line %v", last)
+	}
+	// requests without a token are logged without a user
+	for _, line := range access {
+		if line["path"] == "/api/v1/login" && line["user_id"] != nil {
+			t.Errorf("expected no user_id for a login, got %v", line)
+		}
+	}
+
+	runTasks(t, orch, "agent-1")
+	handed := logs.lines(t, "task handed out")
+	if len(handed) != 2 {
+		t.Fatalf("expected two task handed out lines, got %d", len(handed))
+	}
+	if handed[0]["task_id"] != "1-1" || handed[0]["expr_id"] != 1.0 {
+		t.Errorf("unexpected task line %v", handed[0])
+	}
+	if done := logs.lines(t, "expression done"); len(done) != 1 || done[0]["expr_id"] != 1.0 {
+		t.Errorf("expected an expression done line for expression 1, got %v", done)
+	}
+}
+
+func TestLogging_GeneratesRequestID(t *testing.T) {
+	captureLogs(t)
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+
+	for _, sent := range []string{"", "bad id", strings.Repeat("x", 100)} {
+		req := httptest.NewRequest("GET", "/api/v1/openapi.json", nil)
+		if sent != "" {
+			req.Header.Set(application.RequestIDHeader, sent)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		got := rec.Header().Get(application.RequestIDHeader)
+		if len(got) != 16 || got == sent {
+			t.Errorf("sent %q: expected a generated request id, got %q", sent, got)
+		}
+	}
+}
+
+func TestSetupLogging_RejectsBadConfig(t *testing.T) {
+	prev := slog.Default()
+	defer slog.SetDefault(prev)
+	t.Setenv("LOG_LEVEL", "loud")
+	if err := application.SetupLogging(os.Stderr); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("LOG_FORMAT", "xml")
+	if err := application.SetupLogging(os.Stderr); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestLogging_GRPCUserID(t *testing.T) {
+	logs := captureLogs(t)
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(application.LoggingUnaryInterceptor, orch.AuthUnaryInterceptor),
+		grpc.ChainStreamInterceptor(application.LoggingStreamInterceptor, orch.AuthStreamInterceptor),
+	)
+	calc.RegisterCalcAPIServer(srv, application.NewAPIServer(orch))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := calc.NewCalcAPIClient(conn)
+
+	ctx := context.Background()
+	creds := &calc.Credentials{Login: "alice", Password: "secret123"}
+	if _, err := c.Register(ctx, creds); err != nil {
+		t.Fatal(err)
+	}
+	login, err := c.Login(ctx, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+login.Token)
+	if _, err := c.Calculate(authed, &calc.CalculateReq{Expression: "5"}); err != nil {
+		t.Fatal(err)
+	}
+
+	users := map[string]interface{}{}
+	for _, line := range logs.lines(t, "grpc call") {
+		users[line["method"].(string)] = line["user_id"]
+	}
+	for method, want := range map[string]interface{}{
+		"/calc.CalcAPI/Login":     nil,
+		"/calc.CalcAPI/Calculate": 1.0,
+	} {
+		if got, ok := users[method]; !ok || got != want {
+			t.Errorf("%s: expected user_id %v, got %v", method, want, got)
+		}
+	}
+}
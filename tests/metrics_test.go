@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func scrape(t *testing.T, h http.Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestMetrics_Orchestrator(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+2+3*4"})
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "2*2"})
+
+	body := scrape(t, h)
+	for _, want := range []string{
+		`calc_tasks_queued{operation="*"} 2`,
+		`calc_tasks_queued{operation="+"} 1`,
+		`calc_tasks_in_progress 0`,
+		`calc_expressions{status="pending"} 2`,
+		`calc_http_requests_total{code="201",method="post",route="/api/v1/calculate"}`,
+		`calc_http_request_duration_seconds_bucket{method="post",route="/api/v1/calculate",le="+Inf"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in metrics", want)
+		}
+	}
+
+	runTasks(t, orch, "agent")
+	body = scrape(t, h)
+	for _, want := range []string{
+		`calc_expressions{status="done"} 2`,
+		`calc_task_duration_seconds_count{operation="*"}`,
+		`calc_task_queue_wait_seconds_count{operation="+"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in metrics", want)
+		}
+	}
+	if strings.Contains(body, "calc_tasks_queued{") {
+		t.Error("expected an empty queue")
+	}
+}
+
+func TestMetrics_Agent(t *testing.T) {
+	body := scrape(t, application.AgentMetricsHandler())
+	if !strings.Contains(body, "go_goroutines") {
+		t.Error("expected runtime metrics from the agent")
+	}
+}
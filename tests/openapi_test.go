@@ -0,0 +1,278 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type openAPIMedia map[string]struct {
+	Schema map[string]interface{} `json:"schema"`
+}
+
+type openAPIOperation struct {
+	RequestBody *struct {
+		Content openAPIMedia `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content openAPIMedia `json:"content"`
+	} `json:"responses"`
+}
+
+type openAPIDoc struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+// matchPath finds the spec path template matching a request path.
+func (d *openAPIDoc) matchPath(path string) (string, bool) {
+	segs := strings.Split(path, "/")
+	for tmpl := range d.Paths {
+		tsegs := strings.Split(tmpl, "/")
+		if len(tsegs) != len(segs) {
+			continue
+		}
+		ok := true
+		for i := range tsegs {
+			if !strings.HasPrefix(tsegs[i], "{") && tsegs[i] != segs[i] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return tmpl, true
+		}
+	}
+	return "", false
+}
+
+// validate checks a decoded JSON value against a schema of the document.
+func (d *openAPIDoc) validate(s map[string]interface{}, v interface{}, at string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		name := ref[strings.LastIndex(ref, "/")+1:]
+		target, ok := d.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, ref)
+		}
+		return d.validate(target, v, at)
+	}
+	if v == nil {
+		if s["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+	switch s["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %T", at, v)
+		}
+		props, _ := s["properties"].(map[string]interface{})
+		required, _ := s["required"].([]interface{})
+		for _, r := range required {
+			if _, ok := obj[r.(string)]; !ok {
+				return fmt.Errorf("%s: missing required %q", at, r)
+			}
+		}
+		for k, fv := range obj {
+			ps, ok := props[k].(map[string]interface{})
+			if !ok {
+				if s["additionalProperties"] == false {
+					return fmt.Errorf("%s: undocumented property %q", at, k)
+				}
+				continue
+			}
+			if err := d.validate(ps, fv, at+"."+k); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %T", at, v)
+		}
+		items, _ := s["items"].(map[string]interface{})
+		for i, it := range arr {
+			if err := d.validate(items, it, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string, got %T", at, v)
+		}
+		if s["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, str)
+			}
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: expected an integer, got %v", at, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: expected a number, got %T", at, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %T", at, v)
+		}
+	}
+	return nil
+}
+
+// contractClient sends requests through the handler and checks both the
+// request and the response against the spec.
+type contractClient struct {
+	t       *testing.T
+	h       http.Handler
+	doc     *openAPIDoc
+	covered map[string]bool
+}
+
+func (c *contractClient) call(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	c.t.Helper()
+	tmpl, ok := c.doc.matchPath(strings.SplitN(path, "?", 2)[0])
+	if !ok {
+		c.t.Fatalf("%s %s is not in the spec", method, path)
+	}
+	op, ok := c.doc.Paths[tmpl][strings.ToLower(method)]
+	if !ok {
+		c.t.Fatalf("%s %s is not in the spec", method, tmpl)
+	}
+	c.covered[method+" "+tmpl] = true
+	if body != nil {
+		if op.RequestBody == nil {
+			c.t.Fatalf("%s %s: spec has no request body", method, tmpl)
+		}
+		var v interface{}
+		b, _ := json.Marshal(body)
+		json.Unmarshal(b, &v)
+		if err := c.doc.validate(op.RequestBody.Content["application/json"].Schema, v, "request"); err != nil {
+			c.t.Errorf("%s %s: %v", method, tmpl, err)
+		}
+	}
+
+	rec := doJSON(c.t, c.h, method, path, token, body)
+	resp, ok := op.Responses[fmt.Sprint(rec.Code)]
+	if !ok {
+		c.t.Fatalf("%s %s: status %d is not in the spec: %s", method, tmpl, rec.Code, rec.Body)
+	}
+	if media, ok := resp.Content["application/json"]; ok {
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			c.t.Errorf("%s %s: expected application/json, got %q", method, tmpl, ct)
+		}
+		var v interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+			c.t.Fatalf("%s %s: %v", method, tmpl, err)
+		}
+		if err := c.doc.validate(media.Schema, v, "response"); err != nil {
+			c.t.Errorf("%s %s %d: %v", method, tmpl, rec.Code, err)
+		}
+	}
+	return rec
+}
+
+func TestOpenAPI_Contract(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	var doc openAPIDoc
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("spec is not JSON: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("expected OpenAPI 3, got %q", doc.OpenAPI)
+	}
+	c := &contractClient{t: t, h: h, doc: &doc, covered: map[string]bool{}}
+	c.covered["GET /api/v1/openapi.json"] = true
+
+	creds := map[string]string{"login": "alice", "password": "secret123"}
+	c.call("POST", "/api/v1/register", "", creds)
+	c.call("POST", "/api/v1/register", "", creds)
+	c.call("POST", "/api/v1/login", "", map[string]string{"login": "alice", "password": "wrong1234"})
+	var login struct{ Token string }
+	json.NewDecoder(c.call("POST", "/api/v1/login", "", creds).Body).Decode(&login)
+	tok := login.Token
+
+	var calc struct{ ID int64 }
+	json.NewDecoder(c.call("POST", "/api/v1/calculate", tok, map[string]string{"expression": "2*(3+4)"}).Body).Decode(&calc)
+	c.call("POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+"})
+	var complexCalc struct{ ID int64 }
+	json.NewDecoder(c.call("POST", "/api/v1/calculate", tok, map[string]interface{}{"expression": "sqrt(-1)+2", "complex": true}).Body).Decode(&complexCalc)
+	exprPath := fmt.Sprintf("/api/v1/expressions/%d", calc.ID)
+	c.call("GET", exprPath, tok, nil)
+	c.call("GET", exprPath+"/details", tok, nil)
+	runTasks(t, orch, "agent")
+	c.call("GET", exprPath, tok, nil)
+	c.call("GET", exprPath+"/details", tok, nil)
+	c.call("GET", fmt.Sprintf("/api/v1/expressions/%d", complexCalc.ID), tok, nil)
+	c.call("GET", fmt.Sprintf("/api/v1/expressions/%d/details", complexCalc.ID), tok, nil)
+	c.call("GET", "/api/v1/expressions/999", tok, nil)
+	c.call("POST", exprPath+"/cancel", tok, nil)
+	var pending struct{ ID int64 }
+	json.NewDecoder(c.call("POST", "/api/v1/calculate", tok, map[string]string{"expression": "5-1"}).Body).Decode(&pending)
+	c.call("POST", fmt.Sprintf("/api/v1/expressions/%d/cancel", pending.ID), tok, nil)
+	c.call("POST", "/api/v1/expressions/999/cancel", tok, nil)
+	c.call("GET", "/api/v1/expressions?limit=1", tok, nil)
+	c.call("GET", "/api/v1/expressions?sort=bogus", tok, nil)
+
+	var batch struct {
+		BatchID int64 `json:"batch_id"`
+	}
+	items := map[string]interface{}{"items": []map[string]string{
+		{"client_id": "a", "expression": "1+2"},
+		{"client_id": "b", "expression": "1/0"},
+	}}
+	json.NewDecoder(c.call("POST", "/api/v1/calculate/batch", tok, items).Body).Decode(&batch)
+	c.call("GET", fmt.Sprintf("/api/v1/batches/%d", batch.BatchID), tok, nil)
+	c.call("GET", "/api/v1/batches/999", tok, nil)
+	c.call("PUT", "/api/v1/definitions/rate", tok, map[string]string{"body": "0.2"})
+	c.call("PUT", "/api/v1/definitions/rate", tok, map[string]string{"body": "0.25"})
+	c.call("PUT", "/api/v1/definitions/tax", tok, map[string]interface{}{"params": []string{"x"}, "body": "x*rate"})
+	c.call("PUT", "/api/v1/definitions/loop", tok, map[string]string{"body": "loop+1"})
+	c.call("GET", "/api/v1/definitions", tok, nil)
+	c.call("GET", "/api/v1/definitions/rate?version=1", tok, nil)
+	c.call("GET", "/api/v1/definitions/rate?version=x", tok, nil)
+	c.call("GET", "/api/v1/definitions/missing", tok, nil)
+	c.call("GET", "/api/v1/definitions/rate/versions", tok, nil)
+	c.call("GET", "/api/v1/definitions/missing/versions", tok, nil)
+	c.call("DELETE", "/api/v1/definitions/rate", tok, nil)
+	c.call("DELETE", "/api/v1/definitions/tax", tok, nil)
+	c.call("DELETE", "/api/v1/definitions/tax", tok, nil)
+	c.call("GET", "/api/v1/me/usage", tok, nil)
+	c.call("POST", "/api/v1/password", tok, map[string]string{"old_password": "secret123", "new_password": "another123"})
+	c.call("DELETE", "/api/v1/account", tok, map[string]string{"password": "another123"})
+	c.call("GET", "/healthz", "", nil)
+	// RunServer is not running, so gRPC is not serving
+	c.call("GET", "/readyz", "", nil)
+
+	var missing []string
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if key := strings.ToUpper(method) + " " + path; !c.covered[key] {
+				missing = append(missing, key)
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("operations not exercised by the contract test: %v", missing)
+	}
+}
@@ -1,105 +1,491 @@
-package tests
-
-import (
-	"context"
-	"testing"
-
-	"github.com/lollmark/digital_calc/internal"
-	"github.com/lollmark/digital_calc/proto/calc"
-	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
-)
-
-func setupOrchestrator(t *testing.T) (*application.Orchestrator, func()) {
-	db, err := sqlx.Connect("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatal(err)
-	}
-	schema := `
-	CREATE TABLE users (
-	  id INTEGER PRIMARY KEY AUTOINCREMENT,
-	  login TEXT UNIQUE NOT NULL,
-	  password_hash TEXT NOT NULL
-	);
-	CREATE TABLE expressions (
-	  id INTEGER PRIMARY KEY AUTOINCREMENT,
-	  user_id INTEGER NOT NULL,
-	  expr TEXT NOT NULL,
-	  status TEXT NOT NULL,
-	  result REAL
-	);
-	CREATE TABLE tasks (
-	  id TEXT PRIMARY KEY,
-	  expr_id INTEGER NOT NULL,
-	  arg1 REAL,
-	  arg2 REAL,
-	  operation TEXT,
-	  operation_time INTEGER,
-	  in_progress BOOLEAN NOT NULL DEFAULT 0,
-	  done BOOLEAN NOT NULL DEFAULT 0,
-	  UNIQUE(expr_id, arg1, arg2, operation)
-	);
-	`
-	if _, err := db.Exec(schema); err != nil {
-		t.Fatal(err)
-	}
-	orch := &application.Orchestrator{Config: application.ConfigFromEnv(), DB: db}
-	return orch, func() { db.Close() }
-}
-
-func TestGetTask_NoTask(t *testing.T) {
-	orch, teardown := setupOrchestrator(t)
-	defer teardown()
-
-	_, err := orch.GetTask(context.Background(), &calc.Empty{})
-	st, _ := status.FromError(err)
-	if st.Code() != codes.NotFound {
-		t.Fatalf("expected NotFound, got %v", st.Code())
-	}
-}
-
-func TestPostResult_FullFlow(t *testing.T) {
-	orch, teardown := setupOrchestrator(t)
-	defer teardown()
-
-	// Вставляем выражение и задачу
-	res := orch.DB.MustExec(
-		`INSERT INTO expressions(user_id, expr, status) VALUES (1, '(1+2)', 'pending')`,
-	)
-	exprID, _ := res.LastInsertId()
-	orch.DB.MustExec(
-		`INSERT INTO tasks(id, expr_id, arg1, arg2, operation, operation_time)
-		 VALUES ('task1', ?, 1, 2, '+', 1)`,
-		exprID,
-	)
-
-	// Получаем задачу
-	taskResp, err := orch.GetTask(context.Background(), &calc.Empty{})
-	if err != nil {
-		t.Fatal(err)
-	}
-	if taskResp.Id != "task1" {
-		t.Errorf("expected task1, got %s", taskResp.Id)
-	}
-
-	// Отправляем результат
-	_, err = orch.PostResult(context.Background(), &calc.ResultReq{Id: "task1", Result: 3})
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// Проверка обновления выражения
-	var statusStr string
-	var resultVal float64
-	if err := orch.DB.Get(&statusStr, "SELECT status FROM expressions WHERE id=?", exprID); err != nil {
-		t.Fatal(err)
-	}
-	if err := orch.DB.Get(&resultVal, "SELECT result FROM expressions WHERE id=?", exprID); err != nil {
-		t.Fatal(err)
-	}
-	if statusStr != "done" || resultVal != 3 {
-		t.Errorf("expected done/3, got %s/%f", statusStr, resultVal)
-	}
-}
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lollmark/digital_calc/internal"
+	"github.com/lollmark/digital_calc/pkg/calculator"
+	"github.com/lollmark/digital_calc/proto/calc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func setupOrchestrator(t *testing.T) (*application.Orchestrator, func()) {
+	db, err := application.NewDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orch := &application.Orchestrator{Config: application.ConfigFromEnv(), DB: db}
+	return orch, func() { db.Close() }
+}
+
+func TestGetTask_NoTask(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+
+	_, err := orch.GetTask(context.Background(), &calc.Empty{})
+	st, _ := status.FromError(err)
+	if st.Code() != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", st.Code())
+	}
+}
+
+func TestPostResult_FullFlow(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+
+	// Вставляем выражение и задачу
+	res := orch.DB.MustExec(
+		`INSERT INTO expressions(user_id, expr, status) VALUES (1, '(1+2)', 'pending')`,
+	)
+	exprID, _ := res.LastInsertId()
+	orch.DB.MustExec(
+		`INSERT INTO tasks(id, expr_id, arg1, arg2, operation, operation_time)
+		 VALUES ('task1', ?, 1, 2, '+', 1)`,
+		exprID,
+	)
+
+	// Получаем задачу
+	taskResp, err := orch.GetTask(context.Background(), &calc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskResp.Id != "task1" {
+		t.Errorf("expected task1, got %s", taskResp.Id)
+	}
+
+	// Отправляем результат
+	_, err = orch.PostResult(context.Background(), &calc.ResultReq{Id: "task1", Result: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Проверка обновления выражения
+	var statusStr string
+	var resultVal float64
+	if err := orch.DB.Get(&statusStr, "SELECT status FROM expressions WHERE id=?", exprID); err != nil {
+		t.Fatal(err)
+	}
+	if err := orch.DB.Get(&resultVal, "SELECT result FROM expressions WHERE id=?", exprID); err != nil {
+		t.Fatal(err)
+	}
+	if statusStr != "done" || resultVal != 3 {
+		t.Errorf("expected done/3, got %s/%f", statusStr, resultVal)
+	}
+}
+
+func TestPostResult_RejectsStaleResults(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "(1+2)*4"})
+
+	agent := metadata.NewIncomingContext(context.Background(), metadata.Pairs("agent-id", "agent-1/0"))
+	other := metadata.NewIncomingContext(context.Background(), metadata.Pairs("agent-id", "agent-2/0"))
+	if _, err := orch.PostResult(agent, &calc.ResultReq{Id: "1-1", Result: 3}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("result of a queued task: expected FailedPrecondition, got %v", err)
+	}
+	task, err := orch.GetTask(agent, &calc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orch.PostResult(other, &calc.ResultReq{Id: task.Id, Result: 5}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("result from another agent: expected FailedPrecondition, got %v", err)
+	}
+	if _, err := orch.PostResult(agent, &calc.ResultReq{Id: task.Id, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orch.PostResult(agent, &calc.ResultReq{Id: task.Id, Result: 7}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("duplicate result: expected FailedPrecondition, got %v", err)
+	}
+
+	// the root was queued with the first result, which stays in place
+	root, err := orch.GetTask(agent, &calc.Empty{})
+	if err != nil || root.Arg1 != 3 {
+		t.Fatalf("expected the root with 3, got %v, %v", root, err)
+	}
+	d, err := orch.ExpressionDetail(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := d.Tree.Left.Value; v == nil || *v != 3 {
+		t.Errorf("expected the first result to stay, got %v", v)
+	}
+}
+
+// A task whose agent went away without releasing it goes back to the
+// queue once its lease is over, and the late result is rejected.
+func TestGetTask_RequeuesExpiredLease(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+2"})
+
+	gone := metadata.NewIncomingContext(context.Background(), metadata.Pairs("agent-id", "agent-1/0"))
+	other := metadata.NewIncomingContext(context.Background(), metadata.Pairs("agent-id", "agent-2/0"))
+	task, err := orch.GetTask(gone, &calc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orch.GetTask(other, &calc.Empty{}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected the leased task to stay with its agent, got %v", err)
+	}
+	orch.DB.MustExec("UPDATE tasks SET started_at = started_at - ?", orch.Config.TaskLease.Milliseconds()+1000)
+	again, err := orch.GetTask(other, &calc.Empty{})
+	if err != nil || again.Id != task.Id {
+		t.Fatalf("expected %s to be handed out again, got %v, %v", task.Id, again, err)
+	}
+	if _, err := orch.PostResult(gone, &calc.ResultReq{Id: task.Id, Result: 3}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("result after the lease: expected FailedPrecondition, got %v", err)
+	}
+	if _, err := orch.PostResult(other, &calc.ResultReq{Id: task.Id, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Agents that send no agent-id, and tasks taken before agent ids were
+// stored, are held by the empty id.
+func TestPostResult_WithoutAgentID(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "(1+2)*(3+4)"})
+
+	anonymous := context.Background()
+	named := metadata.NewIncomingContext(context.Background(), metadata.Pairs("agent-id", "agent-1/0"))
+	first, err := orch.GetTask(anonymous, &calc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orch.PostResult(named, &calc.ResultReq{Id: first.Id, Result: 3}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("result from a named agent: expected FailedPrecondition, got %v", err)
+	}
+	if _, err := orch.PostResult(anonymous, &calc.ResultReq{Id: first.Id, Result: 3}); err != nil {
+		t.Fatalf("result from the anonymous agent: %v", err)
+	}
+
+	// a task left in progress by an older version has neither an agent
+	// nor a start time; it is not lost but handed out again
+	orch.DB.MustExec("UPDATE tasks SET in_progress = 1, agent_id = NULL, started_at = NULL WHERE done = 0")
+	second, err := orch.GetTask(named, &calc.Empty{})
+	if err != nil {
+		t.Fatalf("expected the legacy task to be requeued, got %v", err)
+	}
+	if _, err := orch.PostResult(anonymous, &calc.ResultReq{Id: second.Id, Result: 7}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("result from the anonymous agent: expected FailedPrecondition, got %v", err)
+	}
+	if _, err := orch.PostResult(named, &calc.ResultReq{Id: second.Id, Result: 7}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runTasks plays the agent: it takes every queued task, computes it and
+// posts the result, until the queue is empty.
+func runTasks(t *testing.T, orch *application.Orchestrator, agent string) int {
+	t.Helper()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("agent-id", agent))
+	n := 0
+	for {
+		task, err := orch.GetTask(ctx, &calc.Empty{})
+		if status.Code(err) == codes.NotFound {
+			return n
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res complex128
+		if task.Complex {
+			res, err = calculation.ComputeComplex(task.Operation, complex(task.Arg1, task.Arg1Imag), complex(task.Arg2, task.Arg2Imag))
+		} else {
+			var r float64
+			r, err = calculation.Compute(task.Operation, task.Arg1, task.Arg2)
+			res = complex(r, 0)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := orch.PostResult(ctx, &calc.ResultReq{Id: task.Id, Result: real(res), ResultImag: imag(res)}); err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+}
+
+func TestScheduler_ComputesWholeTree(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "(1+2)*(1+2)-10/4"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var queued int
+	orch.DB.Get(&queued, "SELECT COUNT(*) FROM tasks")
+	if queued != 3 {
+		t.Fatalf("expected the 3 independent nodes to be queued, got %d", queued)
+	}
+	if n := runTasks(t, orch, "agent-1/0"); n != 5 {
+		t.Errorf("expected 5 tasks to run, got %d", n)
+	}
+	var e struct {
+		Status string  `db:"status"`
+		Result float64 `db:"result"`
+	}
+	if err := orch.DB.Get(&e, "SELECT status, result FROM expressions"); err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != "done" || e.Result != 6.5 {
+		t.Errorf("expected done/6.5, got %s/%v", e.Status, e.Result)
+	}
+}
+
+func TestCalculate_Variables(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]interface{}{
+		"expression": "a = x+1; b = a*a; b-y",
+		"variables":  map[string]float64{"x": 2, "y": 4},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	// a is used twice but computed once
+	if n := runTasks(t, orch, "agent-1"); n != 3 {
+		t.Errorf("expected 3 tasks to run, got %d", n)
+	}
+	rec = doJSON(t, h, "GET", "/api/v1/expressions/1/details", tok, nil)
+	var d application.ExpressionDetail
+	json.NewDecoder(rec.Body).Decode(&d)
+	if d.Tree.State != application.NodeDone || *d.Tree.Value != 5 {
+		t.Fatalf("expected done with 5, got %+v", d.Tree)
+	}
+	b := d.Tree.Left
+	if b.Variable != "b" || b.Left.Variable != "a" || b.Left.TaskID != b.Right.TaskID || b.Left.Left.Variable != "x" {
+		t.Errorf("expected the variables in the tree, got %+v", b)
+	}
+
+	for _, tc := range []struct {
+		body map[string]interface{}
+		code string
+	}{
+		{map[string]interface{}{"expression": "x+1", "variables": map[string]float64{"1x": 1}}, application.CodeValidationFailed},
+		{map[string]interface{}{"expression": "x+z", "variables": map[string]float64{"x": 1}}, application.CodeInvalidExpression},
+		{map[string]interface{}{"expression": "a = 0; 1/a"}, application.CodeInvalidExpression},
+	} {
+		rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, tc.body)
+		if got := decodeError(t, rec); got.Code != tc.code {
+			t.Errorf("%v: expected %s, got %d %+v", tc.body, tc.code, rec.Code, got)
+		}
+	}
+}
+
+func TestCancelExpression(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "(1+2)*(3+4)"})
+
+	// an agent holds 1+2 while 3+4 waits in the queue
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("agent-id", "agent-1"))
+	task, err := orch.GetTask(ctx, &calc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := doJSON(t, h, "POST", "/api/v1/expressions/1/cancel", tok, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var e application.Expression
+	json.NewDecoder(rec.Body).Decode(&e)
+	if e.Status != "cancelled" || e.FinishedAt == nil {
+		t.Errorf("expected a finished cancelled expression, got %+v", e)
+	}
+	var queued int
+	orch.DB.Get(&queued, "SELECT COUNT(*) FROM tasks WHERE in_progress = 0 AND done = 0")
+	if queued != 0 {
+		t.Errorf("expected the queued task to be dropped, %d left", queued)
+	}
+
+	// the late result neither finishes the expression nor queues the root
+	if _, err := orch.PostResult(ctx, &calc.ResultReq{Id: task.Id, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if n := runTasks(t, orch, "agent-1"); n != 0 {
+		t.Errorf("expected no more tasks, got %d", n)
+	}
+	var status string
+	orch.DB.Get(&status, "SELECT status FROM expressions WHERE id = 1")
+	if status != "cancelled" {
+		t.Errorf("expected the expression to stay cancelled, got %s", status)
+	}
+
+	rec = doJSON(t, h, "POST", "/api/v1/expressions/1/cancel", tok, nil)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a second cancel, got %d", rec.Code)
+	}
+	other := registerAndLogin(t, h, "bob", "secret123")
+	if rec := doJSON(t, h, "POST", "/api/v1/expressions/1/cancel", other, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another user's expression, got %d", rec.Code)
+	}
+}
+
+func TestExpressionDetail(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "(1+2)*4"})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("agent-id", "agent-7/1"))
+	if _, err := orch.GetTask(ctx, &calc.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := doJSON(t, h, "GET", "/api/v1/expressions/1/details", tok, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var d application.ExpressionDetail
+	if err := json.NewDecoder(rec.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Expression.Expr != "(1+2)*4" {
+		t.Errorf("expected the original expression, got %q", d.Expression.Expr)
+	}
+	if d.Tree.Operator != "*" || d.Tree.State != application.NodeWaiting {
+		t.Errorf("root: expected waiting *, got %s %s", d.Tree.State, d.Tree.Operator)
+	}
+	if l := d.Tree.Left; l.State != application.NodeRunning || l.AgentID != "agent-7/1" || l.StartedAt == nil {
+		t.Errorf("left: expected running on agent-7/1, got %+v", l)
+	}
+	if r := d.Tree.Right; r.State != application.NodeDone || r.Value == nil || *r.Value != 4 {
+		t.Errorf("right: expected literal 4, got %+v", r)
+	}
+
+	orch.PostResult(ctx, &calc.ResultReq{Id: d.Tree.Left.TaskID, Result: 3})
+	runTasks(t, orch, "agent-7/1")
+	rec = doJSON(t, h, "GET", "/api/v1/expressions/1/details", tok, nil)
+	d = application.ExpressionDetail{}
+	json.NewDecoder(rec.Body).Decode(&d)
+	if d.Tree.State != application.NodeDone || *d.Tree.Value != 12 {
+		t.Errorf("root: expected done with 12, got %+v", d.Tree)
+	}
+	if d.Timings.CriticalPathMs > d.Timings.WallClockMs {
+		t.Errorf("critical path %dms is longer than wall clock %dms", d.Timings.CriticalPathMs, d.Timings.WallClockMs)
+	}
+
+	if rec := doJSON(t, h, "GET", "/api/v1/expressions/2/details", tok, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestNewDB_MigratesLegacyTasks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.MustExec(`
+	CREATE TABLE tasks (
+	  id TEXT PRIMARY KEY,
+	  expr_id INTEGER NOT NULL,
+	  arg1 REAL,
+	  arg2 REAL,
+	  operation TEXT,
+	  operation_time INTEGER,
+	  in_progress BOOLEAN NOT NULL DEFAULT 0,
+	  done BOOLEAN NOT NULL DEFAULT 0,
+	  UNIQUE(expr_id, arg1, arg2, operation)
+	);
+	INSERT INTO tasks(id, expr_id, arg1, arg2, operation, operation_time) VALUES ('old', 1, 1, 2, '+', 100);
+	`)
+	legacy.Close()
+
+	db, err := application.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var id string
+	if err := db.Get(&id, "SELECT id FROM tasks WHERE node IS NULL"); err != nil || id != "old" {
+		t.Fatalf("expected the legacy task to survive, got %q, %v", id, err)
+	}
+	// the same operands on two nodes used to violate the old constraint
+	db.MustExec("INSERT INTO tasks(id, expr_id, node, arg1, arg2, operation) VALUES ('a', 2, 1, 1, 2, '+'), ('b', 2, 2, 1, 2, '+')")
+}
+
+// A database created by the first release ends up with the same columns
+// as a new one.
+func TestNewDB_MigratesFirstRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "first.db")
+	legacy, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.MustExec(`
+	CREATE TABLE users (
+	  id INTEGER PRIMARY KEY AUTOINCREMENT,
+	  login TEXT UNIQUE NOT NULL,
+	  password_hash TEXT NOT NULL
+	);
+	CREATE TABLE expressions (
+	  id INTEGER PRIMARY KEY AUTOINCREMENT,
+	  user_id INTEGER NOT NULL,
+	  expr TEXT NOT NULL,
+	  status TEXT NOT NULL,
+	  result REAL,
+	  FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE tasks (
+	  id TEXT PRIMARY KEY,
+	  expr_id INTEGER NOT NULL,
+	  arg1 REAL,
+	  arg2 REAL,
+	  operation TEXT,
+	  operation_time INTEGER,
+	  done BOOLEAN NOT NULL DEFAULT 0,
+	  FOREIGN KEY(expr_id) REFERENCES expressions(id)
+	);
+	`)
+	legacy.Close()
+
+	migrated, err := application.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrated.Close()
+	fresh, err := application.NewDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	columns := func(db *sqlx.DB, table string) string {
+		var names []string
+		if err := db.Select(&names, "SELECT name FROM pragma_table_info(?)", table); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(names, ",")
+	}
+	for _, table := range []string{"users", "expressions", "tasks"} {
+		if got, want := columns(migrated, table), columns(fresh, table); got != want {
+			t.Errorf("%s: expected columns %s, got %s", table, want, got)
+		}
+	}
+}
@@ -1,81 +1,265 @@
-package tests
-
-import (
-	"fmt"
-	"testing"
-
-	"github.com/lollmark/digital_calc/internal"
-)
-
-func evalAST(node *application.ASTNode) (float64, error) {
-	if node.IsLeaf {
-		return node.Value, nil
-	}
-	left, err := evalAST(node.Left)
-	if err != nil {
-		return 0, err
-	}
-	right, err := evalAST(node.Right)
-	if err != nil {
-		return 0, err
-	}
-	switch node.Operator {
-	case "+":
-		return left + right, nil
-	case "-":
-		return left - right, nil
-	case "*":
-		return left * right, nil
-	case "/":
-		if right == 0 {
-			return 0, fmt.Errorf("division by zero")
-		}
-		return left / right, nil
-	default:
-		return 0, fmt.Errorf("unknown operator: %s", node.Operator)
-	}
-}
-
-func TestParseASTValid(t *testing.T) {
-	tests := []struct {
-		expr     string
-		expected float64
-	}{
-		{"1+2", 3},
-		{"3", 3},
-		{"(1+2)*3", 9},
-		{"(4/2)-1", 1},
-		{"2+3*4", 14},
-	}
-	for _, tc := range tests {
-		ast, err := application.ParseAST(tc.expr)
-		if err != nil {
-			t.Errorf("Unexpected error for expression %s: %v", tc.expr, err)
-			continue
-		}
-		result, err := evalAST(ast)
-		if err != nil {
-			t.Errorf("AST evaluation error for %s: %v", tc.expr, err)
-			continue
-		}
-		if result != tc.expected {
-			t.Errorf("Expected %f for expression %s, but got %f", tc.expected, tc.expr, result)
-		}
-	}
-}
-
-func TestParseASTInvalid(t *testing.T) {
-	invalidExprs := []string{
-		"",
-		"1+",
-		"(1+2",
-		"1++2",
-		"abc",
-	}
-	for _, expr := range invalidExprs {
-		_, err := application.ParseAST(expr)
-		if err == nil {
-			t.Errorf("Expected an error for invalid expression %q, but no error occurred", expr)
-		}
-	}
-}
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func evalAST(node *application.ASTNode) (float64, error) {
+	if node.IsLeaf {
+		return node.Value, nil
+	}
+	left, err := evalAST(node.Left)
+	if err != nil {
+		return 0, err
+	}
+	right, err := evalAST(node.Right)
+	if err != nil {
+		return 0, err
+	}
+	switch node.Operator {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	default:
+		return 0, fmt.Errorf("unknown operator: %s", node.Operator)
+	}
+}
+
+func TestParseASTValid(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected float64
+	}{
+		{"1+2", 3},
+		{"3", 3},
+		{"(1+2)*3", 9},
+		{"(4/2)-1", 1},
+		{"2+3*4", 14},
+	}
+	for _, tc := range tests {
+		ast, err := application.ParseAST(tc.expr)
+		if err != nil {
+			t.Errorf("Unexpected error for expression %s: %v", tc.expr, err)
+			continue
+		}
+		result, err := evalAST(ast)
+		if err != nil {
+			t.Errorf("AST evaluation error for %s: %v", tc.expr, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Expected %f for expression %s, but got %f", tc.expected, tc.expr, result)
+		}
+	}
+}
+
+func TestParseASTInvalid(t *testing.T) {
+	invalidExprs := []string{
+		"",
+		"1+",
+		"(1+2",
+		"1*/2",
+		"abc",
+	}
+	for _, expr := range invalidExprs {
+		_, err := application.ParseAST(expr)
+		if err == nil {
+			t.Errorf("Expected an error for invalid expression %q, but no error occurred", expr)
+		}
+	}
+}
+
+func TestParseASTWithVars(t *testing.T) {
+	vars := map[string]float64{"x": 2, "rate_2": 0.5}
+	tests := []struct {
+		expr     string
+		expected float64
+	}{
+		{"x*3", 6},
+		{"x * rate_2", 1},
+		{"a = 2+3; b = a*4; b-1", 19},
+		{"a=2+3;a*a", 25},
+		{"a = x+1; a = a*a; a", 9},
+		{"x = 10; x/4", 2.5},
+		{"a = +1; a-x", -1},
+		{"a = 7;", 7},
+	}
+	for _, tc := range tests {
+		ast, err := application.ParseASTWithVars(tc.expr, vars)
+		if err != nil {
+			t.Errorf("Unexpected error for expression %s: %v", tc.expr, err)
+			continue
+		}
+		result, err := application.EvalAST(ast)
+		if err != nil || result != tc.expected {
+			t.Errorf("Expected %v for expression %s, but got %v, %v", tc.expected, tc.expr, result, err)
+		}
+	}
+
+	for _, expr := range []string{"y+1", "a = 1; b", "a =", ";", "1 = 2", "a = 1)", "2a"} {
+		if _, err := application.ParseASTWithVars(expr, vars); err == nil {
+			t.Errorf("Expected an error for invalid expression %q, but no error occurred", expr)
+		}
+	}
+}
+
+func TestParseASTWithVars_SharesAssignedNodes(t *testing.T) {
+	ast, err := application.ParseAST("a = 2+3; a*a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ast.Left != ast.Right || ast.Left.Name != "a" {
+		t.Errorf("expected both operands to be the node of a, got %+v and %+v", ast.Left, ast.Right)
+	}
+	// the size limit counts the node of a once, as it is one task
+	if n := application.CountNodes(ast); n != 4 {
+		t.Errorf("expected 4 nodes, got %d", n)
+	}
+
+	// a chain of squares must not be walked once per path
+	expr := "a0 = 1+1"
+	for i := 1; i <= 64; i++ {
+		expr += fmt.Sprintf("; a%d = a%d*a%d", i, i-1, i-1)
+	}
+	ast, err = application.ParseAST(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := application.CountNodes(ast); n != 67 {
+		t.Errorf("expected 67 nodes, got %d", n)
+	}
+}
+
+func TestParseASTInScope_Definitions(t *testing.T) {
+	defs := map[string]*application.Definition{
+		"rate":   {Name: "rate", Body: "0.2", Version: 1},
+		"tax":    {Name: "tax", Params: []string{"x"}, Body: "x*rate", Version: 3},
+		"gross":  {Name: "gross", Params: []string{"x", "y"}, Body: "x+tax(x)+y", Version: 1},
+		"unused": {Name: "unused", Body: "1", Version: 1},
+	}
+	tests := []struct {
+		expr     string
+		expected float64
+	}{
+		{"rate*10", 2},
+		{"tax(100)", 20},
+		{"gross(100, 5)", 125},
+		{"rate = 0.5; tax(10)", 2}, // assignments hide definitions only in the expression
+		{"tax(tax(100))", 4},
+	}
+	for _, tc := range tests {
+		ast, used, err := application.ParseASTInScope(tc.expr, application.Scope{Defs: defs})
+		if err != nil {
+			t.Errorf("Unexpected error for expression %s: %v", tc.expr, err)
+			continue
+		}
+		if _, ok := used["unused"]; ok {
+			t.Errorf("%s: unused definition reported as used", tc.expr)
+		}
+		result, err := application.EvalAST(ast)
+		if err != nil || result != tc.expected {
+			t.Errorf("Expected %v for expression %s, but got %v, %v", tc.expected, tc.expr, result, err)
+		}
+	}
+	_, used, _ := application.ParseASTInScope("gross(1, 2)", application.Scope{Defs: defs})
+	if len(used) != 3 || used["tax"].Version != 3 {
+		t.Errorf("expected gross, tax and rate to be used, got %v", used)
+	}
+
+	for _, expr := range []string{"tax", "tax(1, 2)", "rate(1)", "nope(1)", "gross(1"} {
+		if _, _, err := application.ParseASTInScope(expr, application.Scope{Defs: defs}); err == nil {
+			t.Errorf("Expected an error for invalid expression %q, but no error occurred", expr)
+		}
+	}
+
+	cyclic := map[string]*application.Definition{
+		"a": {Name: "a", Body: "b(1)+1"},
+		"b": {Name: "b", Params: []string{"x"}, Body: "x*a"},
+	}
+	_, _, err := application.ParseASTInScope("b(2)", application.Scope{Defs: cyclic})
+	if err == nil || !strings.Contains(err.Error(), "b -> a -> b") {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+
+	// every call doubles the expression; the limit stops the expansion
+	deep := map[string]*application.Definition{"f0": {Name: "f0", Params: []string{"x"}, Body: "x+x"}}
+	for i := 1; i <= 40; i++ {
+		deep[fmt.Sprintf("f%d", i)] = &application.Definition{Name: fmt.Sprintf("f%d", i), Params: []string{"x"}, Body: fmt.Sprintf("f%d(x)+f%d(x)", i-1, i-1)}
+	}
+	if _, _, err := application.ParseASTInScope("f40(1)", application.Scope{Defs: deep, MaxNodes: 1000}); err == nil {
+		t.Error("expected the node limit to stop the expansion")
+	}
+}
+
+func TestParseAST_NumberLiterals(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected float64
+	}{
+		{"1e6", 1e6},
+		{"2.5E-3*2", 0.005},
+		{"1e+2", 100},
+		{".5+5.", 5.5},
+		{"0xFF", 255},
+		{"0Xff+0b1010", 265},
+		{"0B1", 1},
+		{"1_000_000", 1000000},
+		{"1_0.2_5e1_0", 10.25e10},
+		{"0x1_0000_0000_0000_0001", 1 << 64},
+		{" 1 +\t2\n* 3 ", 7},
+		{"-1e3", -1000},
+	}
+	for _, tc := range tests {
+		ast, err := application.ParseAST(tc.expr)
+		if err != nil {
+			t.Errorf("Unexpected error for expression %q: %v", tc.expr, err)
+			continue
+		}
+		result, err := evalAST(ast)
+		if err != nil || result != tc.expected {
+			t.Errorf("Expected %v for expression %q, but got %v, %v", tc.expected, tc.expr, result, err)
+		}
+	}
+
+	// errors point at the offending character of the original input
+	errors := []struct {
+		expr, err string
+	}{
+		{"1.2.3", "unexpected '.' in number at position 3"},
+		{"2 + 1..5", "unexpected '.' in number at position 6"},
+		{"0b102", "unexpected '2' in number at position 4"},
+		{"0xFG", "unexpected 'G' in number at position 3"},
+		{"0x", "expected hexadecimal digit at position 2"},
+		{"1 + 0b", "expected binary digit at position 6"},
+		{"1e", "expected exponent digit at position 2"},
+		{"1e+x", "expected exponent digit at position 3"},
+		{"1__000", "'_' must separate digits at position 1"},
+		{"1000_", "'_' must separate digits at position 4"},
+		{"1_.5", "'_' must separate digits at position 1"},
+		{"1._5", "'_' must separate digits at position 2"},
+		{"12abc", "unexpected 'a' in number at position 2"},
+		{"1e999", "number 1e999 at position 0 is out of range"},
+		{"1 2", "unexpected 2 at position 2"},
+		{"1 + 2 $", "unexpected character '$' at position 6"},
+		{"(1 + 2", "missing closing parenthesis for ( at position 0"},
+		{"1 +", "expected number at position 3"},
+	}
+	for _, tc := range errors {
+		_, err := application.ParseAST(tc.expr)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q: expected error %q, got %v", tc.expr, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCalculate_RateLimit(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.RateLimitPerMinute = 2
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	for i := 0; i < 2; i++ {
+		if rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1"}); rec.Code != http.StatusCreated {
+			t.Fatalf("request %d: expected 201, got %d", i+1, rec.Code)
+		}
+	}
+	rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1"})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+
+	other := registerAndLogin(t, h, "bob", "secret123")
+	if rec := doJSON(t, h, "POST", "/api/v1/calculate", other, map[string]string{"expression": "1"}); rec.Code != http.StatusCreated {
+		t.Errorf("other user: expected 201, got %d", rec.Code)
+	}
+}
+
+func TestCalculate_PendingLimit(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.MaxPendingExpressions = 1
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	if rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+2"}); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+	if rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "3+4"}); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	// a plain number finishes immediately and is not pending
+	if rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "5"}); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for a literal, got %d", rec.Code)
+	}
+}
+
+// Concurrent submissions must not all pass the pending check before any
+// of them is stored.
+func TestCalculate_PendingLimitConcurrent(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.MaxPendingExpressions = 3
+	orch.Config.RateLimitPerMinute = 100
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+2"})
+		}()
+		go func() {
+			defer wg.Done()
+			doJSON(t, h, "POST", "/api/v1/calculate/batch", tok, map[string]interface{}{
+				"items": []map[string]string{{"expression": "3+4"}},
+			})
+		}()
+	}
+	wg.Wait()
+	var n int
+	orch.DB.Get(&n, "SELECT COUNT(*) FROM expressions WHERE status = 'pending'")
+	if n != 3 {
+		t.Errorf("expected 3 pending expressions, got %d", n)
+	}
+}
+
+func TestCalculate_SizeLimits(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.MaxASTNodes = 5
+	orch.Config.MaxExpressionLength = 20
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	if rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+2+3+4"}); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("too many nodes: expected 422, got %d", rec.Code)
+	}
+	long := strings.Repeat("1", 21)
+	if rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": long}); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("too long: expected 422, got %d", rec.Code)
+	}
+	if rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+2+3"}); rec.Code != http.StatusCreated {
+		t.Errorf("within limits: expected 201, got %d", rec.Code)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+2"})
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "7"})
+
+	rec := doJSON(t, h, "GET", "/api/v1/me/usage", tok, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var usage struct {
+		RequestsPerMinute  struct{ Used, Limit int } `json:"requests_per_minute"`
+		PendingExpressions struct{ Used, Limit int } `json:"pending_expressions"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.RequestsPerMinute.Used != 2 || usage.RequestsPerMinute.Limit != orch.Config.RateLimitPerMinute {
+		t.Errorf("unexpected requests usage %+v", usage.RequestsPerMinute)
+	}
+	if usage.PendingExpressions.Used != 1 {
+		t.Errorf("expected 1 pending expression, got %d", usage.PendingExpressions.Used)
+	}
+}
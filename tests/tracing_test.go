@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lollmark/digital_calc/internal"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing_ExpressionTrace(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	startCalcServer(t, orch)
+	agent := application.NewAgent()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.Worker(ctx, 0)
+
+	exp.Reset()
+	rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "2*3"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var status string
+		if err := orch.DB.Get(&status, "SELECT status FROM expressions"); err != nil {
+			t.Fatal(err)
+		}
+		if status == "done" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expression still %s", status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var stored *string
+	if err := orch.DB.Get(&stored, "SELECT trace_parent FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || *stored == "" {
+		t.Error("expected the task to keep its trace context")
+	}
+
+	// the PostResult span ends only after the expression is stored as done
+	var spans tracetest.SpanStubs
+	for {
+		spans = exp.GetSpans()
+		if len(spans) > 0 && spans[len(spans)-1].Name == "PostResult" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(spans) == 0 {
+		t.Fatal("no spans recorded")
+	}
+	traceID := spans[0].SpanContext.TraceID()
+	names := map[string]bool{}
+	for _, s := range spans {
+		names[s.Name] = true
+		if s.SpanContext.TraceID() != traceID {
+			t.Errorf("span %q belongs to another trace", s.Name)
+		}
+	}
+	for _, want := range []string{"POST /api/v1/calculate", "Calculate", "schedule task", "GetTask", "Compute", "PostResult"} {
+		if !names[want] {
+			t.Errorf("expected a %q span, got %v", want, names)
+		}
+	}
+}
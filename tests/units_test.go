@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+func TestParseAST_Units(t *testing.T) {
+	for _, tc := range []struct {
+		expr, unit string
+		want       float64
+	}{
+		{expr: "5 m / 2 s", unit: "m/s", want: 2.5},
+		{expr: "3 kg * 9.81 m/s^2", unit: "kg*m/s^2", want: 3 * 9.81},
+		{expr: "1 km + 500 m", unit: "m", want: 1500},
+		{expr: "90 km/h", unit: "m/s", want: 25},
+		{expr: "2 Hz", unit: "s^-1", want: 2},
+		{expr: "1 / (4 s * 5 m^2)", unit: "m^-2*s^-1", want: 0.05},
+		{expr: "12 V / 4 A", unit: "kg*m^2/s^3/A^2", want: 3},
+		{expr: "sqrt(16 m^2) + abs(-1 m)", unit: "m", want: 5},
+		{expr: "(3 m)^2 / 3 m", unit: "m", want: 3},
+		{expr: "1 L / 1 mL", unit: "", want: 1000},
+		{expr: "2 m^1000/m^999", unit: "m", want: 2},
+		{expr: "d = 100 m; t = 20 s; d / t", unit: "m/s", want: 5},
+		{expr: "avg([1 m, 2 m, 3000 mm])", unit: "m", want: 2},
+		{expr: "median([1 m, 300 cm, 2 m])", unit: "m", want: 2},
+		{expr: "stddev([1 s, 3 s])", unit: "s", want: 1},
+		{expr: "1 km > 999 m ? 1 kJ : 2 N*m", unit: "kg*m^2/s^2", want: 1000},
+	} {
+		ast, err := application.ParseAST(tc.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.expr, err)
+			continue
+		}
+		if got := ast.Dim.String(); got != tc.unit {
+			t.Errorf("%q: expected unit %q, got %q", tc.expr, tc.unit, got)
+		}
+		if v, err := application.EvalAST(ast); err != nil || math.Abs(v-tc.want) > 1e-9 {
+			t.Errorf("%q: expected %v, got %v, %v", tc.expr, tc.want, v, err)
+		}
+	}
+}
+
+func TestUnits(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	submit := func(expr string) *application.Expression {
+		t.Helper()
+		rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]interface{}{"expression": expr})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("%q: expected 201, got %d: %s", expr, rec.Code, rec.Body)
+		}
+		var resp application.CalculateResp
+		json.NewDecoder(rec.Body).Decode(&resp)
+		runTasks(t, orch, "agent")
+		e, err := orch.GetExpression(1, resp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	e := submit("3 kg * 9.81 m/s^2")
+	if e.Status != "done" || e.Result == nil || math.Abs(*e.Result-29.43) > 1e-9 || e.Unit == nil || *e.Unit != "kg*m/s^2" {
+		t.Errorf("expected 29.43 kg*m/s^2, got %+v", e)
+	}
+	var status struct{ Expression application.ExpressionStatus }
+	json.NewDecoder(doJSON(t, h, "GET", "/api/v1/expressions/1", tok, nil).Body).Decode(&status)
+	if status.Expression.Unit == nil || *status.Expression.Unit != "kg*m/s^2" {
+		t.Errorf("expected Unit kg*m/s^2, got %+v", status.Expression)
+	}
+	d, err := orch.ExpressionDetail(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Tree.Unit != "kg*m/s^2" || d.Tree.Left.Unit != "kg" || d.Tree.Right.Unit != "m/s^2" {
+		t.Errorf("expected units on the nodes, got %+v", d.Tree)
+	}
+	// plain numbers have no unit
+	if e = submit("2+3"); e.Unit != nil {
+		t.Errorf("expected no unit, got %q", *e.Unit)
+	}
+
+	// a formula takes any quantity and is checked when it is called
+	rec := doJSON(t, h, "PUT", "/api/v1/definitions/later", tok, map[string]interface{}{"params": []string{"x"}, "body": "x + 10 min"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if e = submit("later(1 h)"); *e.Result != 4200 || *e.Unit != "s" {
+		t.Errorf("expected 4200 s, got %+v", e)
+	}
+	rec = doJSON(t, h, "PUT", "/api/v1/definitions/bad", tok, map[string]interface{}{"body": "1 m + 1 s"})
+	if got := decodeError(t, rec); rec.Code != http.StatusUnprocessableEntity || got.Message != "in bad: + at position 4 needs operands of the same dimension, got m and s" {
+		t.Errorf("expected 422 for a constant mixing units, got %d %+v", rec.Code, got)
+	}
+
+	for expr, msg := range map[string]string{
+		"5 m + 2 s":               "+ at position 4 needs operands of the same dimension, got m and s",
+		"later(1 kg)":             "in later: + at position 2 needs operands of the same dimension, got kg and s",
+		"2 parsecs":               "unknown unit parsecs at position 2",
+		"median([1 m, 2 s, 3 m])": "median at position 0 needs operands of the same dimension, got m and s",
+		"0 km^200":                "unit at position 2 is out of range",
+		"1 mm^200":                "unit at position 2 is out of range",
+		"1 km^200 / km^200":       "unit at position 2 is out of range",
+		"1e300 km^3":              "number 1e300 at position 0 is out of range",
+		"1e-320 mm^2":             "number 1e-320 at position 0 is out of range",
+		"2 m^1001":                "power 1001 of a unit at position 4 is out of range, the limit is 1000",
+		"2 m^1.5":                 "power 1.5 of a unit at position 4 must be a whole number",
+	} {
+		rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]interface{}{"expression": expr})
+		if got := decodeError(t, rec); rec.Code != http.StatusUnprocessableEntity || got.Message != msg {
+			t.Errorf("%q: expected 422 %q, got %d %+v", expr, msg, rec.Code, got)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lollmark/digital_calc/internal"
+)
+
+type webhookStub struct {
+	mu       sync.Mutex
+	failNext int
+	calls    int
+	received []application.WebhookPayload
+	sigs     []string
+	bodies   [][]byte
+}
+
+func (s *webhookStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.failNext > 0 {
+		s.failNext--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var p application.WebhookPayload
+	json.Unmarshal(body, &p)
+	s.received = append(s.received, p)
+	s.sigs = append(s.sigs, r.Header.Get("X-Signature-256"))
+	s.bodies = append(s.bodies, body)
+}
+
+func TestWebhook_DeliveredOnCompletion(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.WebhookSecret = "s3cret"
+	orch.Config.WebhookAllowPrivate = true // the stub listens on 127.0.0.1
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	stub := &webhookStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "2*(3+4)", "callback_url": srv.URL})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+	if n, _ := orch.DeliverWebhooks(context.Background()); n != 0 {
+		t.Fatalf("nothing must be sent before the expression is done, sent %d", n)
+	}
+	runTasks(t, orch, "agent")
+	if n, err := orch.DeliverWebhooks(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected 1 delivery, got %d, %v", n, err)
+	}
+	if len(stub.received) != 1 {
+		t.Fatalf("expected 1 callback, got %d", len(stub.received))
+	}
+	p := stub.received[0]
+	if p.ID != 1 || p.Status != "done" || p.Result == nil || *p.Result != 14 {
+		t.Errorf("unexpected payload %+v", p)
+	}
+	if want := application.SignWebhook([]byte("s3cret"), stub.bodies[0]); stub.sigs[0] != want {
+		t.Errorf("expected signature %s, got %s", want, stub.sigs[0])
+	}
+	if n, _ := orch.DeliverWebhooks(context.Background()); n != 0 {
+		t.Errorf("delivered webhooks must not be sent again, sent %d", n)
+	}
+}
+
+func TestWebhook_RetriesWithBackoff(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.WebhookMaxAttempts = 3
+	orch.Config.WebhookAllowPrivate = true
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	stub := &webhookStub{failNext: 1}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	// a literal is done at once, so its webhook is due immediately
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "5", "callback_url": srv.URL})
+	if n, _ := orch.DeliverWebhooks(context.Background()); n != 0 {
+		t.Fatalf("expected the first attempt to fail, sent %d", n)
+	}
+	var d struct {
+		Attempts  int    `db:"attempts"`
+		LastError string `db:"last_error"`
+	}
+	orch.DB.Get(&d, "SELECT attempts, last_error FROM webhook_deliveries")
+	if d.Attempts != 1 || d.LastError == "" {
+		t.Errorf("expected 1 failed attempt, got %+v", d)
+	}
+	if n, _ := orch.DeliverWebhooks(context.Background()); n != 0 || stub.calls != 1 {
+		t.Fatalf("a retry must wait for its backoff, calls=%d", stub.calls)
+	}
+
+	orch.DB.MustExec("UPDATE webhook_deliveries SET next_attempt_at = 0")
+	if n, _ := orch.DeliverWebhooks(context.Background()); n != 1 {
+		t.Fatalf("expected the retry to succeed, sent %d", n)
+	}
+}
+
+func TestWebhook_GivesUp(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.WebhookMaxAttempts = 2
+	orch.Config.WebhookAllowPrivate = true
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	stub := &webhookStub{failNext: 10}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "5", "callback_url": srv.URL})
+	for i := 0; i < 4; i++ {
+		orch.DB.MustExec("UPDATE webhook_deliveries SET next_attempt_at = 0")
+		orch.DeliverWebhooks(context.Background())
+	}
+	if stub.calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", stub.calls)
+	}
+}
+
+func TestWebhook_InvalidCallbackURL(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	for _, u := range []string{"ftp://example.com/hook", "/relative", "http://"} {
+		rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+1", "callback_url": u})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", u, rec.Code)
+		}
+	}
+}
+
+func TestWebhook_RejectsInternalAddresses(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+
+	for _, u := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		rec := doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "1+1", "callback_url": u})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", u, rec.Code)
+		}
+	}
+}
+
+func TestWebhook_DeliveryRefusesInternalAddresses(t *testing.T) {
+	orch, teardown := setupOrchestrator(t)
+	defer teardown()
+	orch.Config.WebhookAllowPrivate = true
+	h := orch.Routes()
+	tok := registerAndLogin(t, h, "alice", "secret123")
+	stub := &webhookStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	doJSON(t, h, "POST", "/api/v1/calculate", tok, map[string]string{"expression": "5", "callback_url": srv.URL})
+	// the address is checked again when the callback is dialled
+	orch.Config.WebhookAllowPrivate = false
+	if n, _ := orch.DeliverWebhooks(context.Background()); n != 0 {
+		t.Fatalf("expected the delivery to be refused, sent %d", n)
+	}
+	if stub.calls != 0 {
+		t.Errorf("the callback must not be reached, got %d calls", stub.calls)
+	}
+	var lastError string
+	orch.DB.Get(&lastError, "SELECT last_error FROM webhook_deliveries")
+	if !strings.Contains(lastError, "not allowed") {
+		t.Errorf("expected a refused address, got %q", lastError)
+	}
+}